@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// roleAttribute is the certificate attribute carrying the submitter's role
+const roleAttribute = "role"
+
+// roleAdmin is the value of roleAttribute granting administrative access
+const roleAdmin = "admin"
+
+// operatorObjectType keys the MSP ID of the channel operator, set by Init.
+// Every organization runs its own CA and could give itself roleAttribute, so
+// only identities of the operator MSP with the admin role are administrators.
+const operatorObjectType = "Operator"
+
+// readOperatorMSP returns the MSP ID of the channel operator, empty before
+// Init configured one
+func readOperatorMSP(APIstub shim.ChaincodeStubInterface) (string, error) {
+	operatorKey, err := APIstub.CreateCompositeKey(operatorObjectType, []string{})
+	if err != nil {
+		return "", err
+	}
+	mspAsBytes, err := APIstub.GetState(operatorKey)
+	return string(mspAsBytes), err
+}
+
+// putOperatorMSP configures the MSP ID of the channel operator
+func putOperatorMSP(APIstub shim.ChaincodeStubInterface, mspID string) error {
+	operatorKey, err := APIstub.CreateCompositeKey(operatorObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(operatorKey, []byte(mspID))
+}
+
+// Caller describes the identity that signed the transaction proposal
+type Caller struct {
+	ID      string
+	MSPID   string
+	Role    string
+	IsAdmin bool
+}
+
+// AccessError is the details of a denied invocation
+type AccessError struct {
+	Function string `json:"function"`
+	MSPID    string `json:"msp_id,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// ==========================================================================
+// getCaller - read MSP ID and certificate attributes of the submitter
+// ==========================================================================
+func getCaller(APIstub shim.ChaincodeStubInterface) (*Caller, error) {
+	identity, err := cid.New(APIstub)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := identity.GetID()
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return nil, err
+	}
+	role, _, err := identity.GetAttributeValue(roleAttribute)
+	if err != nil {
+		return nil, err
+	}
+	operatorMSP, err := readOperatorMSP(APIstub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Caller{ID: id, MSPID: mspID, Role: role, IsAdmin: role == roleAdmin && operatorMSP != "" && mspID == operatorMSP}, nil
+}
+
+// accessDenied builds the error response for a refused invocation, a
+// submitter that cannot be identified is unauthenticated
+func accessDenied(function string, caller *Caller, reason string) peer.Response {
+	accessErr := newError(codeUnauthenticated, "access denied: "+reason)
+	details := &AccessError{Function: function, Reason: reason}
+	if caller != nil {
+		accessErr = newError(codeAccessDenied, "access denied: "+reason)
+		details.MSPID = caller.MSPID
+	}
+	accessErr.Details = details
+
+	fmt.Printf("- access denied for %s: %s\n", function, reason)
+	return errorResponse(accessErr)
+}
+
+// Access policies of the registered functions
+const (
+	policyAnyone            = "anyone"
+	policyAdmin             = "admin"
+	policyModelUploader     = "modelUploader"
+	policyModelOwner        = "modelOwner"
+	policyModelReader       = "modelReader"
+	policyAgreementIssuer   = "agreementIssuer"
+	policyAgreementReader   = "agreementReader"
+	policyAgreementSigner   = "agreementSigner"
+	policyTermsHolder       = "termsHolder"
+	policyParticipant       = "participant"
+	policyTransitionParty   = "transitionParty"
+	policyAmendmentProposer = "amendmentProposer"
+	policyAmendmentParty    = "amendmentParty"
+	policyOwnOrganization   = "ownOrganization"
+	policyOwner             = "owner"
+	policyGrantRedeemer     = "grantRedeemer"
+)
+
+// accessPolicy decides who may invoke a function. check returns the reason
+// an invocation is refused, empty when it is allowed.
+type accessPolicy struct {
+	Description string
+	check       func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error)
+}
+
+// accessPolicies maps the policy names of the function registry to their checks.
+// Argument count errors are left to the function itself, so the checks only
+// look at args that are present.
+var accessPolicies = map[string]accessPolicy{
+	policyAnyone: {"any identified submitter", allowAnyone},
+	policyAdmin: {"administrators of the operator organization only", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		if !caller.IsAdmin {
+			return "function is restricted to administrators", nil
+		}
+		return "", nil
+	}},
+	policyModelUploader: {"upload_org is the submitter's organization", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		// invalid input is left to the function to report
+		input, err := readModelInput(APIstub, args)
+		if err == nil && input.Upload_org != caller.MSPID {
+			return "model can only be uploaded on behalf of the submitter's organization", nil
+		}
+		return "", nil
+	}},
+	policyModelOwner: {"the uploading organization of the model", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		input, err := readModelVersionInput(APIstub, args)
+		if err != nil {
+			return "", nil
+		}
+		Model, err := readModel(APIstub, input.Model_id)
+		if err != nil || Model == nil {
+			return "", err
+		}
+		if Model.Upload_org != caller.MSPID {
+			return "only the uploading organization may publish versions of the model", nil
+		}
+		return "", nil
+	}},
+	policyModelReader: {"the uploading organization of the model or administrators", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		if len(args) < 1 || caller.IsAdmin {
+			return "", nil
+		}
+		Model, err := readModel(APIstub, args[0])
+		if err != nil || Model == nil {
+			return "", err
+		}
+		if Model.Upload_org != caller.MSPID {
+			return "only the uploading organization may list the agreements of the model", nil
+		}
+		return "", nil
+	}},
+	policyAgreementIssuer: {"Agreement_issuer is the submitter's organization", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		input, err := readAgreementInput(APIstub, args)
+		if err == nil && input.Agreement_issuer != caller.MSPID {
+			return "agreement can only be issued on behalf of the submitter's organization", nil
+		}
+		return "", nil
+	}},
+	policyAgreementReader: {"the parties of the Agreement or administrators", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		if len(args) < 1 || caller.IsAdmin {
+			return "", nil
+		}
+		return checkAgreement(APIstub, args[0], func(agreement *Agreement) string {
+			if !isParty(agreement, caller.MSPID) {
+				return "only the parties of the agreement may read it"
+			}
+			return ""
+		})
+	}},
+	policyAgreementSigner: {"the parties of the Agreement", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		if len(args) < 1 {
+			return "", nil
+		}
+		return checkAgreement(APIstub, args[0], func(agreement *Agreement) string {
+			if !isParty(agreement, caller.MSPID) {
+				return "only the parties of the agreement may sign it"
+			}
+			return ""
+		})
+	}},
+	policyTermsHolder: {"the issuer or participant organization of the Agreement", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		// administrators of other organizations are not members of the collection
+		if len(args) < 1 {
+			return "", nil
+		}
+		return checkAgreement(APIstub, args[0], func(agreement *Agreement) string {
+			if agreement.Agreement_issuer != caller.MSPID && agreement.Agreement_participant != caller.MSPID {
+				return "only the issuer or participant organization may read the private terms"
+			}
+			return ""
+		})
+	}},
+	policyParticipant: {"a participant organization of the Agreement", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		if len(args) < 1 {
+			return "", nil
+		}
+		return checkAgreement(APIstub, args[0], func(agreement *Agreement) string {
+			if !hasRole(agreement, caller.MSPID, partyParticipant) {
+				return "only a participant organization may use the model"
+			}
+			return ""
+		})
+	}},
+	policyTransitionParty: {"the party the lifecycle transition names", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		tr, ok := agreementTransitions[function]
+		if !ok || len(args) < 1 {
+			return "", nil
+		}
+		return checkAgreement(APIstub, args[0], func(agreement *Agreement) string {
+			if !tr.allowedParty(agreement, caller.MSPID) {
+				return "only the " + tr.Party + " organization may perform " + function
+			}
+			return ""
+		})
+	}},
+	policyAmendmentProposer: {"the issuer or a participant organization of the Agreement", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		input, err := readAmendmentInput(APIstub, args)
+		if err != nil {
+			return "", nil
+		}
+		return checkAgreement(APIstub, input.AgreementID, func(agreement *Agreement) string {
+			if !hasRole(agreement, caller.MSPID, partyIssuer, partyParticipant) {
+				return "only the issuer or a participant organization may propose amendments"
+			}
+			return ""
+		})
+	}},
+	policyAmendmentParty: {"the counterparties of the proposer accept, the proposer or a counterparty rejects, the parties read", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		if len(args) < 1 {
+			return "", nil
+		}
+		amendment, err := readAmendment(APIstub, args[0])
+		if err != nil || amendment == nil {
+			return "", err
+		}
+		return checkAgreement(APIstub, amendment.AgreementID, func(agreement *Agreement) string {
+			decides := counterparty(agreement, amendment.Proposer, caller.MSPID)
+			switch {
+			case function == "acceptAmendment" && !decides:
+				return "only the counterparty of the proposer may accept the amendment"
+			case function == "rejectAmendment" && !decides && amendment.Proposer != caller.MSPID:
+				return "only the proposer or its counterparty may reject the amendment"
+			case function == "queryAmendment" && !caller.IsAdmin && !isParty(agreement, caller.MSPID):
+				return "only the parties of the agreement may read its amendments"
+			}
+			return ""
+		})
+	}},
+	policyOwnOrganization: {"the organization named in the arguments or administrators", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		if len(args) > 0 && args[0] != caller.MSPID && !caller.IsAdmin {
+			return "organizations can only list their own agreements", nil
+		}
+		return "", nil
+	}},
+	policyOwner: {"the uploading organization of a model or the issuer of an Agreement", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		if len(args) < 1 {
+			return "", nil
+		}
+		return t.authorizeDelete(APIstub, caller, args[0])
+	}},
+	policyGrantRedeemer: {"the uploading organization of the granted model or administrators", func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+		if len(args) < 1 || caller.IsAdmin {
+			return "", nil
+		}
+		grant, err := readAccessGrant(APIstub, args[0])
+		if err != nil || grant == nil {
+			return "", err
+		}
+		Model, err := readModel(APIstub, grant.Model_id)
+		if err != nil || Model == nil {
+			return "", err
+		}
+		if Model.Upload_org != caller.MSPID {
+			return "only the uploading organization of the model may redeem its access grants", nil
+		}
+		return "", nil
+	}},
+}
+
+// allowAnyone is the check of the anyone policy
+func allowAnyone(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+	return "", nil
+}
+
+// checkAgreement applies refuse to the stored Agreement, a missing Agreement
+// is refused as no one is its party
+func checkAgreement(APIstub shim.ChaincodeStubInterface, AgreementID string, refuse func(agreement *Agreement) string) (string, error) {
+	agreement, err := readAgreement(APIstub, AgreementID)
+	if err != nil {
+		return "", err
+	} else if agreement == nil {
+		return "agreement " + AgreementID + " does not exist", nil
+	}
+	return refuse(agreement), nil
+}
+
+// ==========================================================================
+// authorize - check that the caller may run function with the given args
+//
+// An empty reason means the invocation is allowed. The function registry
+// names the access policy of every function.
+// ==========================================================================
+func (t *MAGNIT_CC) authorize(APIstub shim.ChaincodeStubInterface, caller *Caller, function string, args []string) (string, error) {
+
+	registered, ok := functionIndex[function]
+	if !ok {
+		return "function " + function + " has no access policy", nil
+	}
+	policy, ok := accessPolicies[registered.Policy]
+	if !ok {
+		return "", fmt.Errorf("Function %s has an unknown access policy %s", function, registered.Policy)
+	}
+	return policy.check(t, APIstub, caller, function, args)
+}
+
+// authorizeDelete allows owners to delete their own models and agreements,
+// no caller may delete any other key
+func (t *MAGNIT_CC) authorizeDelete(APIstub shim.ChaincodeStubInterface, caller *Caller, key string) (string, error) {
+
+	valAsbytes, err := APIstub.GetState(key)
+	if err != nil || valAsbytes == nil {
+		return "", err
+	}
+
+	record := struct {
+		ObjectType string `json:"docType"`
+		Upload_org string `json:"upload_org"`
+		Issuer     string `json:"Agreement_issuer"`
+	}{}
+	// records that are not JSON documents fall through to the refusal
+	json.Unmarshal(valAsbytes, &record)
+
+	switch record.ObjectType {
+	case "model":
+		if record.Upload_org != caller.MSPID {
+			return "only the uploading organization may delete the model", nil
+		}
+	case "Agreement":
+		if record.Issuer != caller.MSPID {
+			return "only the issuer organization may delete the agreement", nil
+		}
+	default:
+		return "only models and agreements can be deleted", nil
+	}
+
+	return "", nil
+}
+
+// readAgreement returns the stored Agreement for AgreementID, or nil if none exists
+func readAgreement(APIstub shim.ChaincodeStubInterface, AgreementID string) (*Agreement, error) {
+	valAsbytes, err := APIstub.GetState(AgreementID)
+	if err != nil {
+		return nil, err
+	} else if valAsbytes == nil {
+		return nil, nil
+	}
+
+	Agreement := &Agreement{}
+	err = json.Unmarshal(valAsbytes, Agreement)
+	if err != nil {
+		return nil, err
+	}
+	return Agreement, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+var adminAttrs = map[string]string{roleAttribute: roleAdmin}
+
+// setupAgreement stores Model-tx1 uploaded by Org1MSP and Agreement-tx2 issued
+// by Org1MSP to Org2MSP
+func setupAgreement(t testing.TB) *callerStub {
+	stub := newCallerStub(t)
+
+	if res := stub.as(t, "Org1MSP", nil).invoke("tx1", "initmodel", "Model", "Org1MSP"); res.Status != shim.OK {
+		t.Fatalf("initmodel failed: %s", res.Message)
+	}
+	res := stub.invoke("tx2", "insertAgreementinfo", "Agreement", "Model-tx1", "10", "Org1MSP", "Org2MSP", "remark", "http://image", "proposed", docHash)
+	if res.Status != shim.OK {
+		t.Fatalf("insertAgreementinfo failed: %s", res.Message)
+	}
+	return stub
+}
+
+// setupActiveAgreement approves and activates the agreement of setupAgreement
+func setupActiveAgreement(t testing.TB) *callerStub {
+	stub := setupAgreement(t)
+
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke("approve", "approveAgreement", "Agreement-tx2"))
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("activate", "activateAgreement", "Agreement-tx2"))
+	return stub
+}
+
+func expectDenied(t *testing.T, res peer.Response) {
+	t.Helper()
+	if res.Status == shim.OK {
+		t.Fatalf("Expected access to be denied")
+	}
+	expectError(t, res, codeAccessDenied)
+}
+
+func expectOK(t testing.TB, res peer.Response) {
+	t.Helper()
+	if res.Status != shim.OK {
+		t.Fatalf("Expected success, got: %s", res.Message)
+	}
+}
+
+func TestAccessNoIdentity(t *testing.T) {
+	stub := newCallerStub(t)
+
+	expectError(t, stub.invoke("tx1", "initmodel", "Model", "Org1MSP"), codeUnauthenticated)
+}
+
+func TestAccessUploader(t *testing.T) {
+	stub := newCallerStub(t)
+
+	expectDenied(t, stub.as(t, "Org2MSP", nil).invoke("tx1", "initmodel", "Model", "Org1MSP"))
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("tx2", "initmodel", "Model", "Org1MSP"))
+
+	expectDenied(t, stub.as(t, "Org2MSP", nil).invoke("tx3", "del", "Model-tx2", "retired"))
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("tx4", "del", "Model-tx2", "retired"))
+}
+
+func TestAccessIssuer(t *testing.T) {
+	stub := setupAgreement(t)
+
+	expectDenied(t, stub.as(t, "Org2MSP", nil).invoke("tx3", "insertAgreementinfo", "Agreement", "Model-tx1", "10", "Org1MSP", "Org2MSP", "remark", "http://image", "proposed", docHash))
+	expectDenied(t, stub.as(t, "Org3MSP", nil).invoke("tx4", "queryByAgreementID", "Agreement-tx2"))
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("tx5", "queryByAgreementID", "Agreement-tx2"))
+}
+
+func TestAccessParticipant(t *testing.T) {
+	stub := setupAgreement(t)
+
+	expectDenied(t, stub.as(t, "Org1MSP", nil).invoke("tx3", "approveAgreement", "Agreement-tx2", "approved"))
+	expectDenied(t, stub.as(t, "Org3MSP", nil).invoke("tx4", "approveAgreement", "Agreement-tx2", "approved"))
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke("tx5", "approveAgreement", "Agreement-tx2", "approved"))
+
+	expectDenied(t, stub.as(t, "Org2MSP", nil).invoke("tx6", "activateAgreement", "Agreement-tx2"))
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("tx7", "activateAgreement", "Agreement-tx2"))
+
+	expectDenied(t, stub.as(t, "Org1MSP", nil).invoke("tx8", "queryModelByAgreementID", "Agreement-tx2"))
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke("tx9", "queryModelByAgreementID", "Agreement-tx2"))
+}
+
+func TestAccessAdmin(t *testing.T) {
+	stub := setupAgreement(t)
+
+	expectDenied(t, stub.as(t, "Org1MSP", nil).invoke("tx3", "queryAllAsset"))
+	expectOK(t, stub.as(t, "Org1MSP", adminAttrs).invoke("tx4", "queryAllAsset"))
+
+	// counter left behind by a deployment that still used sequential IDs
+	stub.MockTransactionStart("legacy")
+	stub.PutState("ModelCounterNO", []byte(`{"counter":1}`))
+	stub.MockTransactionEnd("legacy")
+	expectDenied(t, stub.as(t, "Org1MSP", nil).invoke("tx5", "del", "ModelCounterNO", "cleanup"))
+	expectDenied(t, stub.as(t, "Org1MSP", adminAttrs).invoke("tx6", "del", "ModelCounterNO", "cleanup"))
+
+	// only the operator MSP has administrators, other organizations issue
+	// themselves the role attribute to no effect
+	expectDenied(t, stub.as(t, "Org3MSP", adminAttrs).invoke("tx7", "queryByAgreementID", "Agreement-tx2"))
+	expectDenied(t, stub.invoke("tx8", "queryAllAsset"))
+	expectDenied(t, stub.invoke("tx9", "rebuildIndexes"))
+}
+
+func TestOperatorMSP(t *testing.T) {
+	stub := newCallerStub(t)
+
+	init := func(args ...string) peer.Response {
+		stub.args = [][]byte{[]byte("init")}
+		for _, arg := range args {
+			stub.args = append(stub.args, []byte(arg))
+		}
+		stub.MockTransactionStart("upgrade")
+		defer stub.MockTransactionEnd("upgrade")
+		return stub.cc.Init(stub)
+	}
+	expectFieldError(t, init("Org 2"), "operator")
+	expectFieldError(t, init("Org2MSP", "Org1MSP", ""), "organizations[1]")
+
+	// an upgrade without arguments keeps the operator and the organizations
+	expectOK(t, init())
+	expectOK(t, stub.as(t, "Org1MSP", adminAttrs).invoke("tx1", "queryAllAsset"))
+	expectOK(t, init("Org2MSP"))
+	expectDenied(t, stub.as(t, "Org1MSP", adminAttrs).invoke("tx2", "queryAllAsset"))
+
+	// functions without a registered policy are refused
+	caller := &Caller{MSPID: "Org2MSP", Role: roleAdmin, IsAdmin: true}
+	if reason, err := stub.cc.authorize(stub, caller, "unregistered", nil); reason == "" || err != nil {
+		t.Fatalf("Expected an unregistered function to be refused: %q %v", reason, err)
+	}
+}
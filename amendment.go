@@ -0,0 +1,561 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/imineev/cc1/events"
+)
+
+// Amendment statuses
+const (
+	AmendmentStatusProposed = "proposed"
+	AmendmentStatusAccepted = "accepted"
+	AmendmentStatusRejected = "rejected"
+)
+
+// amendmentIndex lists the amendments of an Agreement
+const amendmentIndex = "agreement~amendmentID"
+
+// Amendment is a proposed change of an Agreement. It applies once the
+// counterparty of the proposer accepts it, every participant for an
+// amendment of the issuer.
+type Amendment struct {
+	ObjectType    string        `json:"docType"`
+	AmendmentID   string        `json:"AmendmentID"`
+	AgreementID   string        `json:"AgreementID"`
+	Revision      int           `json:"revision"` // Agreement_revision once applied
+	Changes       []FieldChange `json:"changes"`
+	Status        string        `json:"status"`
+	Proposer      string        `json:"proposer"` // MSP ID
+	Propose_time  string        `json:"propose_time"`
+	Accepted_by   []string      `json:"accepted_by,omitempty"` // MSP IDs, in order of acceptance
+	Decided_by    string        `json:"decided_by,omitempty"`
+	Decide_time   string        `json:"decide_time,omitempty"`
+	Reject_reason string        `json:"reject_reason,omitempty"`
+}
+
+// FieldChange is one changed field of an Amendment with its JSON values
+type FieldChange struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from"`
+	To    json.RawMessage `json:"to"`
+}
+
+// AmendmentInput is the input of proposeAmendment, fields left out stay unchanged
+type AmendmentInput struct {
+	AgreementID               string  `json:"AgreementID"`
+	Agreement_name            *string `json:"Agreement_name"`
+	Agreement_model_count_use *Count  `json:"Agreement_model_account_use"`
+	Agreement_remark          *string `json:"Agreement_remark"`
+	Agreement_url_image       *string `json:"Agreement_url_image"`
+	Agreement_hash            *string `json:"Agreement_hash"`
+	Agreement_valid_until     *string `json:"Agreement_valid_until"`
+}
+
+// readAmendmentInput reads the JSON input of proposeAmendment
+func readAmendmentInput(APIstub shim.ChaincodeStubInterface, args []string) (*AmendmentInput, error) {
+	input := &AmendmentInput{}
+	isJSON, err := readInput(APIstub, args, input)
+	if err != nil {
+		return nil, err
+	}
+	if !isJSON {
+		return nil, argumentError("Incorrect arguments. Expecting a JSON amendment")
+	}
+	v := &validator{}
+	if v.required("AgreementID", input.AgreementID) {
+		v.assetID("AgreementID", input.AgreementID, agreementIDPrefix)
+	}
+	if input.Agreement_name != nil {
+		v.maxLength("Agreement_name", *input.Agreement_name, maxNameLength)
+	}
+	if input.Agreement_model_count_use != nil {
+		v.check(validQuota("Agreement_model_account_use", *input.Agreement_model_count_use))
+	}
+	if input.Agreement_remark != nil {
+		v.maxLength("Agreement_remark", *input.Agreement_remark, maxTextLength)
+	}
+	if input.Agreement_url_image != nil {
+		v.url("Agreement_url_image", *input.Agreement_url_image)
+	}
+	if input.Agreement_hash != nil {
+		v.hash("Agreement_hash", input.Agreement_hash)
+	}
+	if input.Agreement_valid_until != nil {
+		*input.Agreement_valid_until, err = parseValidityTime("Agreement_valid_until", *input.Agreement_valid_until)
+		v.check(err)
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+// amendableField is a field an Amendment may change
+type amendableField struct {
+	Name  string
+	Value interface{} // new value, nil when unchanged
+	Field interface{} // pointer to the Agreement field
+}
+
+// amendableFields maps the fields of an Amendment input to the Agreement
+// fields they set, a nil input lists the fields without new values
+func amendableFields(input *AmendmentInput, Agreement *Agreement) []amendableField {
+	fields := []amendableField{
+		{"Agreement_name", nil, &Agreement.Agreement_name},
+		{"Agreement_model_account_use", nil, &Agreement.Agreement_model_count_use},
+		{"Agreement_remark", nil, &Agreement.Agreement_remark},
+		{"Agreement_url_image", nil, &Agreement.Agreement_url_image},
+		{"Agreement_hash", nil, &Agreement.Agreement_hash},
+		{"Agreement_valid_until", nil, &Agreement.Agreement_valid_until},
+	}
+	if input == nil {
+		return fields
+	}
+	if input.Agreement_name != nil {
+		fields[0].Value = *input.Agreement_name
+	}
+	if input.Agreement_model_count_use != nil {
+		fields[1].Value = *input.Agreement_model_count_use
+	}
+	if input.Agreement_remark != nil {
+		fields[2].Value = *input.Agreement_remark
+	}
+	if input.Agreement_url_image != nil {
+		fields[3].Value = *input.Agreement_url_image
+	}
+	if input.Agreement_hash != nil {
+		fields[4].Value = *input.Agreement_hash
+	}
+	if input.Agreement_valid_until != nil {
+		fields[5].Value = *input.Agreement_valid_until
+	}
+	return fields
+}
+
+// privateFields are kept in the private terms of a confidential Agreement
+var privateFields = map[string]bool{
+	"Agreement_model_account_use": true,
+	"Agreement_remark":            true,
+	"Agreement_hash":              true,
+}
+
+// counterparty reports whether mspID may decide an Amendment proposed by proposer.
+// The issuer decides the amendments of participants and the participants
+// decide the amendments of the issuer, any of them may reject it.
+func counterparty(Agreement *Agreement, proposer string, mspID string) bool {
+	if mspID == proposer {
+		return false
+	}
+	if proposer == Agreement.Agreement_issuer {
+		return hasRole(Agreement, mspID, partyParticipant)
+	}
+	return mspID == Agreement.Agreement_issuer
+}
+
+// pendingAcceptances returns the organizations that still have to accept an
+// Amendment before it applies
+func pendingAcceptances(Agreement *Agreement, Amendment *Amendment) []string {
+	accepted := map[string]bool{}
+	for _, mspID := range Amendment.Accepted_by {
+		accepted[mspID] = true
+	}
+	pending := []string{}
+	for _, party := range agreementParties(Agreement) {
+		if !accepted[party.MSPID] && counterparty(Agreement, Amendment.Proposer, party.MSPID) {
+			pending = append(pending, party.MSPID)
+		}
+	}
+	return pending
+}
+
+// checkAmendable refuses to amend deleted Agreements and Agreements in a final status
+func checkAmendable(Agreement *Agreement) error {
+	if Agreement.Agreement_tombstone != nil {
+		return stateError("Agreement " + Agreement.AgreementID + " is deleted")
+	}
+	if isFinalStatus(Agreement.Agreement_status) {
+		return stateError("Agreement " + Agreement.AgreementID + " is " + Agreement.Agreement_status + " and can no longer be amended")
+	}
+	return nil
+}
+
+// readAmendment returns the stored Amendment for AmendmentID, or nil if none exists
+func readAmendment(APIstub shim.ChaincodeStubInterface, AmendmentID string) (*Amendment, error) {
+	valAsbytes, err := APIstub.GetState(AmendmentID)
+	if err != nil {
+		return nil, err
+	} else if valAsbytes == nil {
+		return nil, nil
+	}
+
+	Amendment := &Amendment{}
+	err = json.Unmarshal(valAsbytes, Amendment)
+	if err != nil {
+		return nil, err
+	}
+	if Amendment.ObjectType != "amendment" {
+		return nil, nil
+	}
+	return Amendment, nil
+}
+
+// putAmendment stores an Amendment
+func putAmendment(APIstub shim.ChaincodeStubInterface, Amendment *Amendment) error {
+	AmendmentAsBytes, err := json.Marshal(Amendment)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(Amendment.AmendmentID, AmendmentAsBytes)
+}
+
+// amendmentDetails are the event details of an Amendment
+func amendmentDetails(Amendment *Amendment) *events.AmendmentDetails {
+	fields := []string{}
+	for _, change := range Amendment.Changes {
+		fields = append(fields, change.Field)
+	}
+	return &events.AmendmentDetails{AgreementID: Amendment.AgreementID, Revision: Amendment.Revision, Fields: fields}
+}
+
+// ===========================================================================
+// proposeAmendment - propose a change of an Agreement
+//
+// JSON input with AgreementID and the fields to change, any of
+// Agreement_name, Agreement_model_account_use, Agreement_remark,
+// Agreement_url_image, Agreement_hash and Agreement_valid_until.
+//
+// An Agreement has at most one pending Amendment. Returns the Amendment with
+// the diff of the changed fields.
+// ===========================================================================
+func (t *MAGNIT_CC) proposeAmendment(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	input, err := readAmendmentInput(APIstub, args)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	Agreement, err := readAgreement(APIstub, input.AgreementID)
+	if err != nil {
+		return errorResponse(err)
+	} else if Agreement == nil {
+		return errorResponse(notFoundError("Agreement not exist"))
+	}
+	if err := checkAmendable(Agreement); err != nil {
+		return errorResponse(err)
+	}
+	if Agreement.Agreement_pending_amendment != "" {
+		return errorResponse(stateError("Agreement " + Agreement.AgreementID + " already has the pending amendment " + Agreement.Agreement_pending_amendment))
+	}
+
+	changes := []FieldChange{}
+	for _, field := range amendableFields(input, Agreement) {
+		if field.Value == nil {
+			continue
+		}
+		if Agreement.Agreement_collection != "" && privateFields[field.Name] {
+			return errorResponse(&FieldError{field.Name, "is part of the private terms and cannot be amended"})
+		}
+		fromAsBytes, err := json.Marshal(field.Field)
+		if err != nil {
+			return errorResponse(err)
+		}
+		toAsBytes, err := json.Marshal(field.Value)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if string(fromAsBytes) != string(toAsBytes) {
+			changes = append(changes, FieldChange{field.Name, fromAsBytes, toAsBytes})
+		}
+	}
+	if len(changes) == 0 {
+		return errorResponse(argumentError("Amendment of " + Agreement.AgreementID + " changes no field"))
+	}
+
+	if input.Agreement_valid_until != nil && *input.Agreement_valid_until != "" {
+		if Agreement.Agreement_valid_from != "" && *input.Agreement_valid_until <= Agreement.Agreement_valid_from {
+			return errorResponse(&FieldError{"Agreement_valid_until", "must be after Agreement_valid_from"})
+		}
+		if err := requireFutureUntil(APIstub, *input.Agreement_valid_until); err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	AmendmentID, err := newAssetID(APIstub, amendmentIDPrefix)
+	if err != nil {
+		return errorResponse(err)
+	}
+	caller, err := getCaller(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	propose_time, errTx := t.GetTxTimestampChannel(APIstub)
+	if errTx != nil {
+		return errorResponse(errTx)
+	}
+
+	Amendment := &Amendment{
+		ObjectType:   "amendment",
+		AmendmentID:  AmendmentID,
+		AgreementID:  Agreement.AgreementID,
+		Revision:     Agreement.Agreement_revision + 1,
+		Changes:      changes,
+		Status:       AmendmentStatusProposed,
+		Proposer:     caller.MSPID,
+		Propose_time: propose_time,
+	}
+	err = putAmendment(APIstub, Amendment)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = updateIndexes(APIstub, nil, []indexEntry{{amendmentIndex, []string{Agreement.AgreementID, AmendmentID}}})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// the pending amendment blocks concurrent proposals on the same Agreement
+	Agreement.Agreement_pending_amendment = AmendmentID
+	AgreementJSONasBytes, err := json.Marshal(Agreement)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = APIstub.PutState(Agreement.AgreementID, AgreementJSONasBytes)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(APIstub, &events.Event{Type: events.AmendmentProposed, AssetID: AmendmentID}, amendmentDetails(Amendment))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Printf("- %s proposed %s of %s\n", caller.MSPID, AmendmentID, Agreement.AgreementID)
+
+	AmendmentAsBytes, err := json.Marshal(Amendment)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(AmendmentAsBytes)
+}
+
+// pendingAmendment returns a pending Amendment and its Agreement
+func pendingAmendment(APIstub shim.ChaincodeStubInterface, AmendmentID string) (*Amendment, *Agreement, error) {
+	Amendment, err := readAmendment(APIstub, AmendmentID)
+	if err != nil {
+		return nil, nil, err
+	} else if Amendment == nil {
+		return nil, nil, notFoundError("Amendment not exist")
+	}
+	if Amendment.Status != AmendmentStatusProposed {
+		return nil, nil, stateError("Amendment " + AmendmentID + " is already " + Amendment.Status)
+	}
+
+	Agreement, err := readAgreement(APIstub, Amendment.AgreementID)
+	if err != nil {
+		return nil, nil, err
+	} else if Agreement == nil {
+		return nil, nil, notFoundError("Agreement not exist")
+	}
+	if Agreement.Agreement_pending_amendment != AmendmentID {
+		return nil, nil, stateError("Amendment " + AmendmentID + " is not pending on " + Agreement.AgreementID)
+	}
+	return Amendment, Agreement, nil
+}
+
+// ===========================================================================
+// acceptAmendment - accept a pending Amendment, by the counterparty of its
+// proposer
+//
+// args[0] AmendmentID
+// The Amendment applies once every counterparty accepted it and the updated
+// Agreement is returned. Until then the Amendment with its acceptances is.
+// ===========================================================================
+func (t *MAGNIT_CC) acceptAmendment(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting AmendmentID"))
+	}
+
+	Amendment, Agreement, err := pendingAmendment(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	if err := checkAmendable(Agreement); err != nil {
+		return errorResponse(err)
+	}
+
+	// the fields cannot change while the amendment is pending, check anyway
+	fields := map[string]interface{}{}
+	for _, field := range amendableFields(nil, Agreement) {
+		fields[field.Name] = field.Field
+	}
+	for _, change := range Amendment.Changes {
+		field, ok := fields[change.Field]
+		if !ok {
+			return errorResponse(newError(codeInternal, "Amendment "+Amendment.AmendmentID+" changes the unknown field "+change.Field))
+		}
+		currentAsBytes, err := json.Marshal(field)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if string(currentAsBytes) != string(change.From) {
+			return errorResponse(stateError("Field " + change.Field + " of " + Agreement.AgreementID + " changed since the amendment was proposed"))
+		}
+		err = json.Unmarshal(change.To, field)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	caller, err := getCaller(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	decide_time, errTx := t.GetTxTimestampChannel(APIstub)
+	if errTx != nil {
+		return errorResponse(errTx)
+	}
+
+	for _, mspID := range Amendment.Accepted_by {
+		if mspID == caller.MSPID {
+			return errorResponse(stateError("Amendment " + Amendment.AmendmentID + " was already accepted by " + caller.MSPID))
+		}
+	}
+	Amendment.Accepted_by = append(Amendment.Accepted_by, caller.MSPID)
+	if pending := pendingAcceptances(Agreement, Amendment); len(pending) > 0 {
+		err = putAmendment(APIstub, Amendment)
+		if err != nil {
+			return errorResponse(err)
+		}
+		fmt.Printf("- %s accepted %s, waiting for %v\n", caller.MSPID, Amendment.AmendmentID, pending)
+
+		AmendmentAsBytes, err := json.Marshal(Amendment)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return shim.Success(AmendmentAsBytes)
+	}
+
+	Agreement.Agreement_revision = Amendment.Revision
+	Agreement.Agreement_pending_amendment = ""
+	Agreement.Agreement_update_time = decide_time
+	for _, change := range Amendment.Changes {
+		if change.Field == "Agreement_hash" {
+			err = recordDocument(APIstub, Agreement, decide_time)
+			if err != nil {
+				return errorResponse(err)
+			}
+		}
+	}
+	AgreementJSONasBytes, err := json.Marshal(Agreement)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = APIstub.PutState(Agreement.AgreementID, AgreementJSONasBytes)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	Amendment.Status = AmendmentStatusAccepted
+	Amendment.Decided_by = caller.MSPID
+	Amendment.Decide_time = decide_time
+	err = putAmendment(APIstub, Amendment)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(APIstub, &events.Event{Type: events.AmendmentAccepted, AssetID: Amendment.AmendmentID}, amendmentDetails(Amendment))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Printf("- %s accepted %s, %s is at revision %d\n", caller.MSPID, Amendment.AmendmentID, Agreement.AgreementID, Agreement.Agreement_revision)
+	return shim.Success(AgreementJSONasBytes)
+}
+
+// ===========================================================================
+// rejectAmendment - discard a pending Amendment, by the counterparty of its
+// proposer or by the proposer withdrawing it
+//
+// args[0] AmendmentID
+// args[1] reason, optional
+// ===========================================================================
+func (t *MAGNIT_CC) rejectAmendment(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting AmendmentID and an optional reason"))
+	}
+
+	Amendment, Agreement, err := pendingAmendment(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	caller, err := getCaller(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	decide_time, errTx := t.GetTxTimestampChannel(APIstub)
+	if errTx != nil {
+		return errorResponse(errTx)
+	}
+
+	Agreement.Agreement_pending_amendment = ""
+	Agreement.Agreement_update_time = decide_time
+	AgreementJSONasBytes, err := json.Marshal(Agreement)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = APIstub.PutState(Agreement.AgreementID, AgreementJSONasBytes)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	Amendment.Status = AmendmentStatusRejected
+	Amendment.Decided_by = caller.MSPID
+	Amendment.Decide_time = decide_time
+	if len(args) == 2 {
+		Amendment.Reject_reason = args[1]
+	}
+	err = putAmendment(APIstub, Amendment)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(APIstub, &events.Event{Type: events.AmendmentRejected, AssetID: Amendment.AmendmentID}, amendmentDetails(Amendment))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Printf("- %s rejected %s\n", caller.MSPID, Amendment.AmendmentID)
+
+	AmendmentAsBytes, err := json.Marshal(Amendment)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(AmendmentAsBytes)
+}
+
+// ===========================================================================
+// queryAmendment - an Amendment by ID
+// ===========================================================================
+func (t *MAGNIT_CC) queryAmendment(ctx *TransactionContext, AmendmentID string) (*Amendment, error) {
+
+	stored, err := readAmendment(ctx.GetStub(), AmendmentID)
+	if err != nil {
+		return nil, err
+	} else if stored == nil {
+		return nil, notFoundError("Amendment not exist")
+	}
+	return stored, nil
+}
+
+// ===========================================================================
+// queryAmendments - all Amendments of an Agreement, whatever their status
+// ===========================================================================
+func (t *MAGNIT_CC) queryAmendments(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	return t.queryIndex(APIstub, amendmentIndex, args)
+}
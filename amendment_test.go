@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/imineev/cc1/events"
+)
+
+func TestAmendmentWorkflow(t *testing.T) {
+	stub := setupActiveAgreement(t).as(t, "Org1MSP", nil)
+
+	res := stub.invoke("tx3", "proposeAmendment", `{"AgreementID":"Agreement-tx2","Agreement_model_account_use":20,"Agreement_remark":"remark","Agreement_url_image":"http://new"}`)
+	expectOK(t, res)
+	amendment := Amendment{}
+	json.Unmarshal(res.Payload, &amendment)
+	if amendment.AmendmentID != "Amendment-tx3" || amendment.Revision != 1 || amendment.Status != AmendmentStatusProposed || len(amendment.Changes) != 2 {
+		t.Fatalf("Unexpected amendment %s", res.Payload)
+	}
+	if change := amendment.Changes[0]; change.Field != "Agreement_model_account_use" || string(change.From) != "10" || string(change.To) != "20" {
+		t.Fatalf("Unexpected diff %+v", change)
+	}
+	details := events.AmendmentDetails{}
+	if err := lastEvent(t, stub, events.AmendmentProposed).DecodeDetails(&details); err != nil || details.AgreementID != "Agreement-tx2" || len(details.Fields) != 2 {
+		t.Fatalf("Unexpected event details %+v: %v", details, err)
+	}
+
+	// proposals do not change the agreement and block further proposals
+	expectFailure(t, stub.as(t, "Org2MSP", nil).invoke("use1", "consumeModelUsage", "Agreement-tx2", "15"))
+	expectFailure(t, stub.invoke("tx4", "proposeAmendment", `{"AgreementID":"Agreement-tx2","Agreement_remark":"other"}`))
+
+	expectDenied(t, stub.as(t, "Org1MSP", nil).invoke("tx5", "acceptAmendment", "Amendment-tx3"))
+	expectDenied(t, stub.as(t, "Org3MSP", nil).invoke("tx6", "acceptAmendment", "Amendment-tx3"))
+
+	res = stub.as(t, "Org2MSP", nil).invoke("tx7", "acceptAmendment", "Amendment-tx3")
+	expectOK(t, res)
+	Agreement := &Agreement{}
+	json.Unmarshal(res.Payload, Agreement)
+	if Agreement.Agreement_model_count_use != 20 || Agreement.Agreement_url_image != "http://new" || Agreement.Agreement_revision != 1 || Agreement.Agreement_pending_amendment != "" {
+		t.Fatalf("Amendment was not applied: %s", res.Payload)
+	}
+	lastEvent(t, stub, events.AmendmentAccepted)
+	expectOK(t, stub.invoke("use2", "consumeModelUsage", "Agreement-tx2", "15"))
+	expectFailure(t, stub.invoke("tx8", "acceptAmendment", "Amendment-tx3"))
+
+	// the proposer may withdraw its amendment
+	expectOK(t, stub.invoke("tx9", "proposeAmendment", `{"AgreementID":"Agreement-tx2","Agreement_name":"Renamed"}`))
+	res = stub.invoke("tx10", "rejectAmendment", "Amendment-tx9", "withdrawn")
+	expectOK(t, res)
+	json.Unmarshal(res.Payload, &amendment)
+	if amendment.Status != AmendmentStatusRejected || amendment.Decided_by != "Org2MSP" || amendment.Reject_reason != "withdrawn" {
+		t.Fatalf("Unexpected rejection %s", res.Payload)
+	}
+	expectFailure(t, stub.as(t, "Org1MSP", nil).invoke("tx11", "acceptAmendment", "Amendment-tx9"))
+
+	res = stub.invoke("q1", "queryAmendments", "Agreement-tx2")
+	expectOK(t, res)
+	amendments := []Amendment{}
+	json.Unmarshal(res.Payload, &amendments)
+	if len(amendments) != 2 {
+		t.Fatalf("Expected 2 amendments, got %s", res.Payload)
+	}
+	expectOK(t, stub.invoke("q2", "queryAmendment", "Amendment-tx3"))
+	expectDenied(t, stub.as(t, "Org3MSP", nil).invoke("q3", "queryAmendment", "Amendment-tx3"))
+
+	Agreement, _ = readAgreement(stub, "Agreement-tx2")
+	if Agreement.Agreement_name != "Agreement" || Agreement.Agreement_revision != 1 {
+		t.Fatalf("Rejected amendment changed the agreement: %+v", Agreement)
+	}
+	if Agreement.Agreement_update_time != amendment.Decide_time || Agreement.Agreement_pending_amendment != "" {
+		t.Fatalf("Expected the rejection to update the agreement at %s: %+v", amendment.Decide_time, Agreement)
+	}
+}
+
+func TestAmendmentNeedsEveryParticipant(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", nil)
+	expectOK(t, stub.invoke("tx3", "insertAgreementinfo", `{"Agreement_model_id":"Model-tx1","Agreement_model_account_use":10,"Agreement_issuer":"Org1MSP",`+
+		`"Agreement_parties":[{"msp_id":"Org1MSP","role":"issuer"},{"msp_id":"Org2MSP","role":"participant"},{"msp_id":"Org3MSP","role":"participant"}]}`))
+	expectOK(t, stub.invoke("tx4", "proposeAmendment", `{"AgreementID":"Agreement-tx3","Agreement_model_account_use":20}`))
+
+	res := stub.as(t, "Org2MSP", nil).invoke("tx5", "acceptAmendment", "Amendment-tx4")
+	expectOK(t, res)
+	amendment := Amendment{}
+	json.Unmarshal(res.Payload, &amendment)
+	if amendment.Status != AmendmentStatusProposed || len(amendment.Accepted_by) != 1 || amendment.Accepted_by[0] != "Org2MSP" {
+		t.Fatalf("Expected the amendment to wait for Org3MSP: %s", res.Payload)
+	}
+	if Agreement, _ := readAgreement(stub, "Agreement-tx3"); Agreement.Agreement_model_count_use != 10 || Agreement.Agreement_pending_amendment != "Amendment-tx4" {
+		t.Fatalf("A single participant applied the amendment: %+v", Agreement)
+	}
+	expectFailure(t, stub.invoke("tx6", "acceptAmendment", "Amendment-tx4"))
+
+	res = stub.as(t, "Org3MSP", nil).invoke("tx7", "acceptAmendment", "Amendment-tx4")
+	expectOK(t, res)
+	Agreement := &Agreement{}
+	json.Unmarshal(res.Payload, Agreement)
+	if Agreement.Agreement_model_count_use != 20 || Agreement.Agreement_pending_amendment != "" {
+		t.Fatalf("Amendment was not applied after every participant accepted: %s", res.Payload)
+	}
+	lastEvent(t, stub, events.AmendmentAccepted)
+}
+
+func TestAmendmentValidation(t *testing.T) {
+	stub := setupPrivateAgreement(t).as(t, "Org1MSP", nil)
+
+	expectFailure(t, stub.invoke("tx4", "proposeAmendment", `{"AgreementID":"Agreement-tx2","Agreement_remark":"remark"}`))
+	expectFieldError(t, stub.invoke("tx5", "proposeAmendment", `{"AgreementID":"Agreement-tx2","Agreement_status":"active"}`), "Agreement_status")
+	expectFieldError(t, stub.invoke("tx6", "proposeAmendment", `{"AgreementID":"Agreement-tx2","Agreement_valid_until":"2000-01-01T00:00:00Z"}`), "Agreement_valid_until")
+	expectFieldError(t, stub.invoke("tx7", "proposeAmendment", `{"AgreementID":"Agreement-tx3","Agreement_model_account_use":5}`), "Agreement_model_account_use")
+	expectFailure(t, stub.invoke("tx8", "proposeAmendment", "Agreement-tx2", "remark"))
+
+	// public fields of a confidential agreement can be amended
+	expectOK(t, stub.invoke("tx9", "proposeAmendment", `{"AgreementID":"Agreement-tx3","Agreement_url_image":"http://new"}`))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func agreementIDs(t *testing.T, payload []byte) []string {
+	t.Helper()
+	Agreements := []Agreement{}
+	if err := json.Unmarshal(payload, &Agreements); err != nil {
+		t.Fatalf("Invalid result %s: %v", payload, err)
+	}
+	ids := []string{}
+	for _, Agreement := range Agreements {
+		ids = append(ids, Agreement.AgreementID)
+	}
+	return ids
+}
+
+func TestCompositeIndexQueries(t *testing.T) {
+	stub := setupAgreement(t)
+	expectOK(t, stub.invoke("tx3", "insertAgreementinfo", "Other", "Model-tx1", "10", "Org1MSP", "Org3MSP", "", "", "", docHash))
+
+	res := stub.invoke("q1", "queryAgreementsByIssuer", "Org1MSP")
+	expectOK(t, res)
+	if ids := agreementIDs(t, res.Payload); len(ids) != 2 {
+		t.Fatalf("Expected 2 agreements of the issuer, got %v", ids)
+	}
+
+	// the same lookup through a rich query returns the same agreements
+	page := expectPage(t, stub.invoke("q2", "queryAgreements", `{"Agreement_issuer":"Org1MSP"}`), 2)
+	for i, record := range page.Records {
+		Agreement := Agreement{}
+		json.Unmarshal(record, &Agreement)
+		if Agreement.AgreementID != agreementIDs(t, res.Payload)[i] {
+			t.Fatalf("Index and rich query results differ")
+		}
+	}
+
+	res = stub.invoke("q3", "queryAgreementsByModel", "Model-tx1")
+	expectOK(t, res)
+	if ids := agreementIDs(t, res.Payload); len(ids) != 2 {
+		t.Fatalf("Expected 2 agreements of the model, got %v", ids)
+	}
+
+	res = stub.as(t, "Org3MSP", nil).invoke("q4", "queryAgreementsByParticipant", "Org3MSP")
+	expectOK(t, res)
+	if ids := agreementIDs(t, res.Payload); len(ids) != 1 || ids[0] != "Agreement-tx3" {
+		t.Fatalf("Expected Agreement-tx3 for the participant, got %v", ids)
+	}
+	expectDenied(t, stub.invoke("q5", "queryAgreementsByIssuer", "Org1MSP"))
+	expectDenied(t, stub.invoke("q6", "queryAgreementsByModel", "Model-tx1"))
+
+	res = stub.invoke("q7", "queryModelsByOrg", "Org1MSP")
+	expectOK(t, res)
+	Models := []Model{}
+	if err := json.Unmarshal(res.Payload, &Models); err != nil || len(Models) != 1 || Models[0].Model_id != "Model-tx1" {
+		t.Fatalf("Expected Model-tx1 for the org, got %s", res.Payload)
+	}
+}
+
+func TestStatusIndexFollowsTransitions(t *testing.T) {
+	stub := setupActiveAgreement(t)
+
+	proposed, _ := queryByIndex(stub, statusIndex, StatusProposed)
+	active, _ := queryByIndex(stub, statusIndex, StatusActive)
+	if len(agreementIDs(t, proposed)) != 0 || len(agreementIDs(t, active)) != 1 {
+		t.Fatalf("Status index was not moved: proposed %s, active %s", proposed, active)
+	}
+}
+
+func TestRebuildIndexes(t *testing.T) {
+	stub := newCallerStub(t)
+
+	stub.MockTransactionStart("legacy")
+	stub.PutState("Model1", []byte(`{"docType":"model","upload_org":"Org1MSP"}`))
+	stub.PutState("Agreement1", []byte(`{"docType":"Agreement","AgreementID":"Agreement1","Agreement_model_id":"Model1","Agreement_issuer":"Org1MSP","Agreement_participant":"Org2MSP","Agreement_status":"approved"}`))
+	stub.PutState("Agreement2", []byte(`{"docType":"Agreement","AgreementID":"Agreement2","Agreement_model_id":"Model1","Agreement_issuer":"Org1MSP","Agreement_participant":"Org2MSP","Agreement_status":"terminated","Agreement_tombstone":{"reason":"obsolete"}}`))
+	stub.MockTransactionEnd("legacy")
+
+	rebuilt := struct {
+		Indexed int    `json:"indexed"`
+		Next    string `json:"next"`
+	}{}
+	res := stub.as(t, "Org1MSP", adminAttrs).invoke("tx1", "rebuildIndexes", "2")
+	expectOK(t, res)
+	json.Unmarshal(res.Payload, &rebuilt)
+	if rebuilt.Indexed != 1 || rebuilt.Next != "Model1" {
+		t.Fatalf("Expected Agreement1 indexed and Model1 next, got %s", res.Payload)
+	}
+	res = stub.invoke("tx2", "rebuildIndexes", "2", rebuilt.Next)
+	expectOK(t, res)
+	rebuilt.Next = ""
+	json.Unmarshal(res.Payload, &rebuilt)
+	if rebuilt.Indexed != 1 || rebuilt.Next != "" {
+		t.Fatalf("Expected Model1 indexed on the last page, got %s", res.Payload)
+	}
+
+	res = stub.invoke("q1", "queryAgreementsByParticipant", "Org2MSP")
+	expectOK(t, res)
+	if ids := agreementIDs(t, res.Payload); len(ids) != 1 || ids[0] != "Agreement1" {
+		t.Fatalf("Expected Agreement1 after rebuilding, got %v", ids)
+	}
+	res = stub.invoke("q2", "queryModelsByOrg", "Org1MSP")
+	expectOK(t, res)
+	if string(res.Payload) == "[]" {
+		t.Fatalf("Expected Model1 after rebuilding")
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// contractName is the name of the chaincode's only contract in its metadata
+const contractName = "MAGNIT_CC"
+
+// metadataFunction is the function the Fabric SDKs discover contracts with.
+// The chaincode serves its metadata under that name so the SDKs can discover
+// it, it is not built on fabric-contract-api-go.
+const metadataFunction = "org.hyperledger.fabric:GetMetadata"
+
+// TransactionContext is handed to the typed transaction functions of the
+// registry. The before hooks identify the submitter and restrict the stub of
+// read functions.
+type TransactionContext struct {
+	stub     shim.ChaincodeStubInterface
+	caller   *Caller
+	function *Function
+}
+
+// GetStub returns the stub of the transaction
+func (ctx *TransactionContext) GetStub() shim.ChaincodeStubInterface {
+	return ctx.stub
+}
+
+// GetCaller returns the identity that submitted the transaction
+func (ctx *TransactionContext) GetCaller() *Caller {
+	return ctx.caller
+}
+
+// deniedError refuses an invocation, see accessDenied
+type deniedError struct {
+	caller *Caller
+	reason string
+}
+
+func (e *deniedError) Error() string {
+	return e.reason
+}
+
+// beforeTransaction runs in order before every transaction function, the
+// first error refuses the invocation
+var beforeTransaction = []func(t *MAGNIT_CC, ctx *TransactionContext, args []string) error{
+	identifySubmitter,
+	authorizeSubmitter,
+	validateArguments,
+	restrictReads,
+}
+
+// afterTransaction runs in order on the response of every transaction function
+var afterTransaction = []func(ctx *TransactionContext, response peer.Response) peer.Response{
+	logTransaction,
+}
+
+// identifySubmitter checks who is calling before any state is touched
+func identifySubmitter(t *MAGNIT_CC, ctx *TransactionContext, args []string) error {
+	caller, err := getCaller(ctx.stub)
+	if err != nil {
+		return &deniedError{nil, "unable to identify submitter: " + err.Error()}
+	}
+	ctx.caller = caller
+	return nil
+}
+
+// authorizeSubmitter applies the access policy of the function
+func authorizeSubmitter(t *MAGNIT_CC, ctx *TransactionContext, args []string) error {
+	reason, err := t.authorize(ctx.stub, ctx.caller, ctx.function.Name, args)
+	if err != nil {
+		return err
+	} else if reason != "" {
+		return &deniedError{ctx.caller, reason}
+	}
+	return nil
+}
+
+// validateArguments checks the argument count of typed transaction functions,
+// the other functions check their arguments themselves
+func validateArguments(t *MAGNIT_CC, ctx *TransactionContext, args []string) error {
+	if ctx.function.transaction == nil || len(args) == len(ctx.function.Args) {
+		return nil
+	}
+	names := []string{}
+	for _, arg := range ctx.function.Args {
+		names = append(names, arg.Name)
+	}
+	if len(names) == 0 {
+		return argumentError("Incorrect number of arguments. Expecting 0")
+	}
+	return argumentError("Incorrect number of arguments. Expecting " + strings.Join(names, " and "))
+}
+
+// restrictReads hands read functions a stub that refuses writes
+func restrictReads(t *MAGNIT_CC, ctx *TransactionContext, args []string) error {
+	if ctx.function.Mode == modeRead {
+		ctx.stub = readOnlyStub{ctx.stub, ctx.function.Name}
+	}
+	return nil
+}
+
+// logTransaction reports failed transactions in the chaincode log
+func logTransaction(ctx *TransactionContext, response peer.Response) peer.Response {
+	if response.Status != shim.OK {
+		fmt.Printf("- %s failed: %s\n", ctx.function.Name, response.Message)
+	}
+	return response
+}
+
+// transactionRefused builds the response of an invocation a before hook refused
+func transactionRefused(ctx *TransactionContext, err error) peer.Response {
+	if denied, ok := err.(*deniedError); ok {
+		return accessDenied(ctx.function.Name, denied.caller, denied.reason)
+	}
+	return errorResponse(err)
+}
+
+// invoke runs the function within its transaction context
+func (function *Function) invoke(t *MAGNIT_CC, ctx *TransactionContext, args []string) peer.Response {
+	if function.transaction == nil {
+		return function.handler(t, ctx.stub, args)
+	}
+
+	transaction := reflect.ValueOf(function.transaction)
+	in := []reflect.Value{reflect.ValueOf(t), reflect.ValueOf(ctx)}
+	for i, arg := range args {
+		value, err := parseArg(function.Args[i], transaction.Type().In(i+2), arg)
+		if err != nil {
+			return errorResponse(err)
+		}
+		in = append(in, value)
+	}
+
+	out := transaction.Call(in)
+	if err, _ := out[len(out)-1].Interface().(error); err != nil {
+		return errorResponse(err)
+	}
+	if len(out) == 1 {
+		return shim.Success(nil)
+	}
+	resultAsBytes, err := json.Marshal(out[0].Interface())
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// parseArg converts an argument to the type of its parameter, JSON arguments
+// are decoded strictly
+func parseArg(arg Arg, paramType reflect.Type, value string) (reflect.Value, error) {
+	switch paramType.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(value).Convert(paramType), nil
+	case reflect.Int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return reflect.Value{}, &FieldError{arg.Name, fmt.Sprintf("must be an integer, got %q", value)}
+		}
+		return reflect.ValueOf(number).Convert(paramType), nil
+	case reflect.Bool:
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return reflect.Value{}, &FieldError{arg.Name, fmt.Sprintf("must be true or false, got %q", value)}
+		}
+		return reflect.ValueOf(flag), nil
+	}
+	decoded := reflect.New(paramType)
+	if err := decodeInput([]byte(value), decoded.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return decoded.Elem(), nil
+}
+
+// ContractMetadata lists the transactions of the chaincode with JSON schemas
+// of their parameters and results, see metadataFunction
+type ContractMetadata struct {
+	Info       MetadataInfo                   `json:"info"`
+	Contracts  map[string]ContractDescription `json:"contracts"`
+	Components MetadataComponents             `json:"components"`
+}
+
+// MetadataInfo names a chaincode or contract in its metadata
+type MetadataInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// ContractDescription lists the transactions of a contract
+type ContractDescription struct {
+	Name         string                `json:"name"`
+	Info         MetadataInfo          `json:"info"`
+	Transactions []TransactionMetadata `json:"transactions"`
+	Default      bool                  `json:"default"`
+}
+
+// TransactionMetadata describes one transaction function, tagged submit or
+// evaluate by its mode
+type TransactionMetadata struct {
+	Name       string                 `json:"name"`
+	Tag        []string               `json:"tag"`
+	Parameters []ParameterMetadata    `json:"parameters"`
+	Returns    map[string]interface{} `json:"returns,omitempty"`
+}
+
+// ParameterMetadata describes a parameter with its JSON schema
+type ParameterMetadata struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema"`
+}
+
+// MetadataComponents holds the schemas of the returned objects
+type MetadataComponents struct {
+	Schemas map[string]interface{} `json:"schemas"`
+}
+
+// argSchema is the JSON schema of an argument type
+func argSchema(argType string) map[string]interface{} {
+	if argType == argJSON {
+		return map[string]interface{}{"type": argString, "format": argJSON}
+	}
+	return map[string]interface{}{"type": argType}
+}
+
+// typeSchema is the JSON schema of a Go type, structs are added to schemas
+// and referenced by name
+func typeSchema(goType reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if goType == reflect.TypeOf(json.RawMessage{}) || goType.Kind() == reflect.Interface {
+		return map[string]interface{}{}
+	}
+	switch goType.Kind() {
+	case reflect.Ptr:
+		return typeSchema(goType.Elem(), schemas)
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": argArray, "items": typeSchema(goType.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": argObject, "additionalProperties": typeSchema(goType.Elem(), schemas)}
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + goType.Name()}
+		if _, ok := schemas[goType.Name()]; ok {
+			return ref
+		}
+		properties := map[string]interface{}{}
+		schema := map[string]interface{}{"$id": goType.Name(), "type": argObject, "properties": properties}
+		// registered before the fields, so recursive types terminate
+		schemas[goType.Name()] = schema
+		for i := 0; i < goType.NumField(); i++ {
+			field := goType.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" || field.PkgPath != "" {
+				continue
+			}
+			properties[name] = typeSchema(field.Type, schemas)
+		}
+		return ref
+	}
+	return map[string]interface{}{"type": jsonType(goType)}
+}
+
+// contractMetadata generates the metadata of the function registry
+func contractMetadata() ContractMetadata {
+	schemas := map[string]interface{}{}
+	names := []string{}
+	for name := range functionIndex {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	transactions := []TransactionMetadata{}
+	for _, name := range names {
+		function := functionIndex[name]
+		transaction := TransactionMetadata{Name: name, Tag: []string{"submit"}, Parameters: []ParameterMetadata{}}
+		if function.Mode == modeRead {
+			transaction.Tag = []string{"evaluate"}
+		}
+		for _, arg := range function.Args {
+			transaction.Parameters = append(transaction.Parameters, ParameterMetadata{arg.Name, arg.Description, argSchema(arg.Type)})
+		}
+		if function.transaction != nil {
+			if transactionType := reflect.TypeOf(function.transaction); transactionType.NumOut() == 2 {
+				transaction.Returns = typeSchema(transactionType.Out(0), schemas)
+			}
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	info := MetadataInfo{contractName, "latest"}
+	return ContractMetadata{
+		Info:       info,
+		Contracts:  map[string]ContractDescription{contractName: {contractName, info, transactions, true}},
+		Components: MetadataComponents{schemas},
+	}
+}
+
+// ===========================================================================
+// getMetadata - the contract metadata of the chaincode, generated from the
+// function registry
+// ===========================================================================
+func (t *MAGNIT_CC) getMetadata(ctx *TransactionContext) (ContractMetadata, error) {
+	return contractMetadata(), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTransactionSignatures(t *testing.T) {
+	contextType := reflect.TypeOf(&TransactionContext{})
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	for name, function := range functionIndex {
+		if function.transaction == nil {
+			continue
+		}
+		transaction := reflect.TypeOf(function.transaction)
+		if transaction.NumIn() != len(function.Args)+2 || transaction.In(1) != contextType {
+			t.Fatalf("%s must take the TransactionContext and one parameter per arg", name)
+		}
+		if transaction.NumOut() < 1 || transaction.NumOut() > 2 || transaction.Out(transaction.NumOut()-1) != errorType {
+			t.Fatalf("%s must return an error last", name)
+		}
+		for i, arg := range function.Args {
+			if paramType := jsonType(transaction.In(i + 2)); arg.Type != argJSON && paramType != arg.Type {
+				t.Fatalf("Arg %s of %s is %s, its parameter is %s", arg.Name, name, arg.Type, paramType)
+			}
+		}
+	}
+}
+
+func TestTypedTransactionArguments(t *testing.T) {
+	stub := setupActiveAgreement(t).as(t, "Org2MSP", nil)
+
+	expectFieldError(t, stub.invoke("tx3", "consumeModelUsage", "Agreement-tx2", "many"), "units")
+	expectFieldError(t, stub.invoke("tx4", "consumeModelUsage", "Agreement-tx2", "0"), "units")
+	if chaincodeErr := expectError(t, stub.invoke("tx5", "consumeModelUsage", "Agreement-tx2"), codeInvalidArgument); chaincodeErr.Message != "Incorrect number of arguments. Expecting AgreementID and units" {
+		t.Fatalf("Expected an argument count error, got %s", chaincodeErr.Message)
+	}
+
+	res := stub.invoke("tx6", "consumeModelUsage", "Agreement-tx2", "3")
+	expectOK(t, res)
+	consumption := Consumption{}
+	json.Unmarshal(res.Payload, &consumption)
+	if consumption.Units != 3 || consumption.Remaining != 7 {
+		t.Fatalf("Unexpected consumption %s", res.Payload)
+	}
+
+	expectOK(t, stub.invoke("compact", "compactUsage", "Agreement-tx2"))
+
+	// typed failures keep their structured errors
+	usageErr := UsageError{}
+	errorDetails(t, expectError(t, stub.invoke("tx7", "consumeModelUsage", "Agreement-tx2", "11"), codeQuotaExhausted), &usageErr)
+	if usageErr.Quota != 10 || usageErr.Requested != 11 || usageErr.CurrentCount != 3 {
+		t.Fatalf("Unexpected usage error %+v", usageErr)
+	}
+
+	// consumptions report the compacted count
+	res = stub.invoke("tx8", "consumeModelUsage", "Agreement-tx2", "2")
+	expectOK(t, res)
+	json.Unmarshal(res.Payload, &consumption)
+	if consumption.CurrentCount != 5 || consumption.Remaining != 5 {
+		t.Fatalf("Unexpected consumption %s", res.Payload)
+	}
+}
+
+func TestContractMetadata(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org3MSP", nil)
+
+	res := stub.invoke("q1", "org.hyperledger.fabric:GetMetadata")
+	expectOK(t, res)
+	metadata := ContractMetadata{}
+	json.Unmarshal(res.Payload, &metadata)
+
+	contract, ok := metadata.Contracts[contractName]
+	if !ok || !contract.Default || len(contract.Transactions) != len(functionIndex) {
+		t.Fatalf("Expected every function in the default contract: %s", res.Payload)
+	}
+	transactions := map[string]TransactionMetadata{}
+	for _, transaction := range contract.Transactions {
+		transactions[transaction.Name] = transaction
+	}
+
+	consume := transactions["consumeModelUsage"]
+	if consume.Tag[0] != "submit" || len(consume.Parameters) != 2 || consume.Parameters[1].Schema["type"] != argInteger {
+		t.Fatalf("Unexpected metadata %+v", consume)
+	}
+	if consume.Returns["$ref"] != "#/components/schemas/Consumption" || metadata.Components.Schemas["Consumption"] == nil {
+		t.Fatalf("Expected the Consumption schema: %+v", consume.Returns)
+	}
+	if versions := transactions["queryModelVersions"]; versions.Tag[0] != "evaluate" || versions.Returns["type"] != argArray {
+		t.Fatalf("Unexpected metadata %+v", versions)
+	}
+	if legacy := transactions["insertAgreementinfo"]; len(legacy.Parameters) != 10 || legacy.Returns != nil {
+		t.Fatalf("Unexpected metadata %+v", legacy)
+	}
+}
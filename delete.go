@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/imineev/cc1/events"
+)
+
+// How del treats a model that agreements still reference
+const (
+	deleteBlock   = "block"   // refuse the deletion
+	deleteSuspend = "suspend" // suspend the active agreements and delete
+)
+
+// Tombstone marks a soft-deleted model or agreement. The record stays in the
+// world state so history and references keep resolving.
+type Tombstone struct {
+	Reason      string `json:"reason"`
+	Deleted_by  string `json:"deleted_by"` // ID of the deleting identity
+	Deleter_msp string `json:"deleter_msp"`
+	Delete_time string `json:"delete_time"`
+	Delete_txid string `json:"delete_txid"`
+}
+
+// newTombstone records who deletes a record in the current transaction
+func (t *MAGNIT_CC) newTombstone(APIstub shim.ChaincodeStubInterface, reason string) (*Tombstone, error) {
+	caller, err := getCaller(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	delete_time, err := t.GetTxTimestampChannel(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	return &Tombstone{reason, caller.ID, caller.MSPID, delete_time, APIstub.GetTxID()}, nil
+}
+
+// isInternalKey reports keys that are bookkeeping rather than assets
+func isInternalKey(key string) bool {
+	// composite keys hold indexes, usage deltas and version records
+	if strings.HasPrefix(key, "\x00") {
+		return true
+	}
+	for _, counterKey := range legacyCounterKeys {
+		if key == counterKey {
+			return true
+		}
+	}
+	return false
+}
+
+// referencingAgreements returns the live agreements bound to a model. Until
+// the indexes are built it scans every Agreement, the model index would miss
+// those stored before it existed.
+func referencingAgreements(APIstub shim.ChaincodeStubInterface, model_id string) ([]*Agreement, error) {
+	built, err := indexesBuilt(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	live := func(Agreement *Agreement) bool {
+		return Agreement != nil && Agreement.Agreement_tombstone == nil && !isFinalStatus(Agreement.Agreement_status)
+	}
+
+	Agreements := []*Agreement{}
+	if !built {
+		resultsIterator, err := APIstub.GetStateByRange(agreementIDPrefix, agreementIDPrefix+"~")
+		if err != nil {
+			return nil, err
+		}
+		defer resultsIterator.Close()
+
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				return nil, err
+			}
+			Agreement := &Agreement{}
+			if json.Unmarshal(queryResponse.Value, Agreement) != nil || Agreement.ObjectType != "Agreement" {
+				continue
+			}
+			if Agreement.Agreement_model_id == model_id && live(Agreement) {
+				Agreements = append(Agreements, Agreement)
+			}
+		}
+		return Agreements, nil
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(modelIndex, []string{model_id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		Agreement, err := readAgreement(APIstub, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		if live(Agreement) {
+			Agreements = append(Agreements, Agreement)
+		}
+	}
+	return Agreements, nil
+}
+
+// isFinalStatus reports statuses no transition leaves
+func isFinalStatus(status string) bool {
+	for _, tr := range agreementTransitions {
+		if tr.allowedFrom(status) {
+			return false
+		}
+	}
+	return status != StatusActive
+}
+
+// ===========================================================
+// del - soft-delete a model or an agreement
+//
+// id - Model or Agreement ID
+// reason - why the record is deleted
+// mode - optional for models, "block" (default) refuses while live agreements
+// reference the model, "suspend" suspends the active ones and deletes anyway
+// ===========================================================
+func (t *MAGNIT_CC) del(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 2 && len(args) != 3 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting id, reason and optional mode"))
+	}
+	if len(args[1]) <= 0 {
+		return errorResponse(&FieldError{"reason", "must be a non-empty string"})
+	}
+	mode := deleteBlock
+	if len(args) == 3 {
+		mode = args[2]
+	}
+	if mode != deleteBlock && mode != deleteSuspend {
+		return errorResponse(&FieldError{"mode", "must be " + deleteBlock + " or " + deleteSuspend})
+	}
+
+	id := args[0]
+	if isInternalKey(id) {
+		return errorResponse(argumentError("Internal record cannot be deleted: " + id))
+	}
+
+	valAsbytes, err := APIstub.GetState(id)
+	if err != nil {
+		return errorResponse(err)
+	} else if valAsbytes == nil {
+		return errorResponse(notFoundError("Record does not exist: " + id))
+	}
+	record := struct {
+		ObjectType string `json:"docType"`
+	}{}
+	json.Unmarshal(valAsbytes, &record)
+
+	tombstone, err := t.newTombstone(APIstub, args[1])
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	details := &events.DeletionDetails{Reason: tombstone.Reason}
+	event := &events.Event{AssetID: id}
+	switch record.ObjectType {
+	case "Agreement":
+		event.Type = events.AgreementDeleted
+		err = t.deleteAgreement(APIstub, id, tombstone)
+	case "model":
+		event.Type = events.ModelDeleted
+		details.SuspendedAgreements, err = t.deleteModel(APIstub, id, tombstone, mode)
+	default:
+		err = argumentError("Only models and agreements can be deleted")
+	}
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(APIstub, event, details)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Printf("- %s deleted by %s: %s\n", id, tombstone.Deleter_msp, tombstone.Reason)
+	return shim.Success(nil)
+}
+
+// deleteAgreement tombstones an agreement that is no longer in use
+func (t *MAGNIT_CC) deleteAgreement(APIstub shim.ChaincodeStubInterface, AgreementID string, tombstone *Tombstone) error {
+	Agreement, err := readAgreement(APIstub, AgreementID)
+	if err != nil {
+		return err
+	}
+	if Agreement.Agreement_tombstone != nil {
+		return stateError("Agreement is already deleted: " + AgreementID)
+	}
+	if Agreement.Agreement_status == StatusActive || Agreement.Agreement_status == StatusSuspended {
+		return stateError(fmt.Sprintf("Agreement %s is %s, terminate or revoke it first", AgreementID, Agreement.Agreement_status))
+	}
+
+	// deleted agreements drop out of the index lookups
+	err = indexAgreement(APIstub, Agreement, nil)
+	if err != nil {
+		return err
+	}
+
+	Agreement.Agreement_tombstone = tombstone
+	Agreement.Agreement_update_time = tombstone.Delete_time
+	AgreementJSONasBytes, err := json.Marshal(Agreement)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(AgreementID, AgreementJSONasBytes)
+}
+
+// deleteModel tombstones a model, checking the agreements that reference it.
+// It returns the agreements it suspended.
+func (t *MAGNIT_CC) deleteModel(APIstub shim.ChaincodeStubInterface, model_id string, tombstone *Tombstone, mode string) ([]string, error) {
+	Model, err := readModel(APIstub, model_id)
+	if err != nil {
+		return nil, err
+	}
+	if Model.Model_tombstone != nil {
+		return nil, stateError("Model is already deleted: " + model_id)
+	}
+
+	Agreements, err := referencingAgreements(APIstub, model_id)
+	if err != nil {
+		return nil, err
+	}
+	if len(Agreements) > 0 && mode == deleteBlock {
+		return nil, stateError(fmt.Sprintf("Model %s is referenced by %d live agreements, e.g. %s", model_id, len(Agreements), Agreements[0].AgreementID))
+	}
+	suspended := []string{}
+	for _, Agreement := range Agreements {
+		if Agreement.Agreement_status != StatusActive {
+			continue
+		}
+		err = setAgreementStatus(APIstub, Agreement, StatusSuspended, tombstone.Delete_time)
+		if err != nil {
+			return nil, err
+		}
+		suspended = append(suspended, Agreement.AgreementID)
+	}
+
+	err = indexModel(APIstub, Model, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	Model.Model_tombstone = tombstone
+	Model.Model_update_time = tombstone.Delete_time
+	ModelJSONasBytes, err := json.Marshal(Model)
+	if err != nil {
+		return nil, err
+	}
+	err = APIstub.PutState(model_id, ModelJSONasBytes)
+	if err != nil {
+		return nil, err
+	}
+	return suspended, nil
+}
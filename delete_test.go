@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+func expectFailure(t *testing.T, res peer.Response) {
+	t.Helper()
+	if res.Status == shim.OK {
+		t.Fatalf("Expected failure, got success")
+	}
+}
+
+func TestDeleteAgreement(t *testing.T) {
+	stub := setupActiveAgreement(t)
+
+	// an agreement in use has to be terminated first
+	expectFailure(t, stub.invoke("tx3", "del", "Agreement-tx2", "obsolete"))
+	expectOK(t, stub.invoke("tx4", "terminateAgreement", "Agreement-tx2"))
+	expectOK(t, stub.invoke("tx5", "del", "Agreement-tx2", "obsolete"))
+	expectFailure(t, stub.invoke("tx6", "del", "Agreement-tx2", "obsolete"))
+
+	Agreement, err := readAgreement(stub, "Agreement-tx2")
+	if err != nil || Agreement == nil {
+		t.Fatalf("Deleted agreement must stay in the world state: %v", err)
+	}
+	tombstone := Agreement.Agreement_tombstone
+	if tombstone == nil || tombstone.Reason != "obsolete" || tombstone.Deleter_msp != "Org1MSP" || tombstone.Delete_txid != "tx5" {
+		t.Fatalf("Unexpected tombstone %+v", tombstone)
+	}
+
+	res := stub.invoke("q1", "queryAgreementsByIssuer", "Org1MSP")
+	expectOK(t, res)
+	if ids := agreementIDs(t, res.Payload); len(ids) != 0 {
+		t.Fatalf("Deleted agreement is still indexed: %v", ids)
+	}
+	expectPage(t, stub.as(t, "Org1MSP", nil).invoke("q2", "queryAgreements", `{"Agreement_issuer":"Org1MSP"}`), 0)
+
+	expectFailure(t, stub.as(t, "Org2MSP", nil).invoke("tx7", "rejectAgreement", "Agreement-tx2"))
+}
+
+func TestDeleteModelBlockedByAgreements(t *testing.T) {
+	stub := setupActiveAgreement(t)
+
+	expectFailure(t, stub.invoke("tx3", "del", "Model-tx1", "retired"))
+	expectFailure(t, stub.invoke("tx4", "del", "Model-tx1", "retired", "cascade"))
+
+	Model, err := readModel(stub, "Model-tx1")
+	if err != nil || Model == nil || Model.Model_tombstone != nil {
+		t.Fatalf("Blocked deletion must leave the model untouched: %+v %v", Model, err)
+	}
+}
+
+func TestDeleteModelSuspendsAgreements(t *testing.T) {
+	stub := setupActiveAgreement(t)
+
+	expectOK(t, stub.invoke("tx3", "del", "Model-tx1", "retired", "suspend"))
+	expectStatus(t, stub, "Agreement-tx2", StatusSuspended)
+
+	// a deleted model can neither be used nor bound again
+	expectFailure(t, stub.invoke("tx4", "resumeAgreement", "Agreement-tx2"))
+	expectFailure(t, stub.invoke("tx5", "insertAgreementinfo", "Agreement", "Model-tx1", "10", "Org1MSP", "Org2MSP", "", "", "", docHash))
+	expectFailure(t, stub.invoke("tx6", "publishModelVersion", "Model-tx1", "2.0", "sha256:b", "ipfs://b"))
+
+	res := stub.invoke("q1", "queryModelsByOrg", "Org1MSP")
+	expectOK(t, res)
+	if string(res.Payload) != "[]" {
+		t.Fatalf("Deleted model is still indexed: %s", res.Payload)
+	}
+
+	// the suspended agreement can still be wound down and deleted
+	expectOK(t, stub.invoke("tx7", "terminateAgreement", "Agreement-tx2"))
+	expectOK(t, stub.invoke("tx8", "del", "Agreement-tx2", "model retired"))
+}
+
+func TestDeleteArguments(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", nil)
+
+	expectFailure(t, stub.invoke("tx3", "del", "Agreement-tx2"))
+	expectFailure(t, stub.invoke("tx4", "del", "Agreement-tx2", ""))
+	expectFailure(t, stub.invoke("tx5", "del", "Agreement-missing", "typo"))
+	expectOK(t, stub.invoke("tx6", "del", "Agreement-tx2", "withdrawn"))
+}
+
+func TestRebuildIndexesKeepsDeletedUnindexed(t *testing.T) {
+	stub := setupActiveAgreement(t)
+
+	expectOK(t, stub.invoke("tx3", "terminateAgreement", "Agreement-tx2"))
+	expectOK(t, stub.invoke("tx4", "del", "Agreement-tx2", "obsolete"))
+	expectOK(t, stub.as(t, "Org1MSP", adminAttrs).invoke("tx5", "rebuildIndexes"))
+
+	res := stub.invoke("q1", "queryAgreementsByIssuer", "Org1MSP")
+	expectOK(t, res)
+	if ids := agreementIDs(t, res.Payload); len(ids) != 0 {
+		t.Fatalf("Deleted agreement was indexed again: %v", ids)
+	}
+	terminated, err := queryByIndex(stub, statusIndex, StatusTerminated)
+	if err != nil || len(agreementIDs(t, terminated)) != 0 {
+		t.Fatalf("Deleted agreement is in the status index: %s %v", terminated, err)
+	}
+}
+
+func TestDeleteModelFindsUnindexedAgreements(t *testing.T) {
+	stub := newCallerStub(t)
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("tx1", "initmodel", "Model", "Org1MSP"))
+
+	// an agreement of a deployment from before the indexes
+	markerKey, _ := stub.CreateCompositeKey(indexesBuiltObjectType, []string{})
+	stub.MockTransactionStart("legacy")
+	stub.DelState(markerKey)
+	stub.PutState("Agreement1", []byte(`{"docType":"Agreement","AgreementID":"Agreement1","Agreement_model_id":"Model-tx1","Agreement_issuer":"Org1MSP","Agreement_participant":"Org2MSP","Agreement_status":"active"}`))
+	stub.MockTransactionEnd("legacy")
+
+	if chaincodeErr := expectError(t, stub.invoke("tx2", "del", "Model-tx1", "retired"), codeInvalidState); !strings.Contains(chaincodeErr.Message, "Agreement1") {
+		t.Fatalf("Expected Agreement1 to block the deletion, got %s", chaincodeErr.Message)
+	}
+
+	// once rebuilt the index finds it
+	expectOK(t, stub.as(t, "Org1MSP", adminAttrs).invoke("tx3", "rebuildIndexes"))
+	if built, _ := indexesBuilt(stub); !built {
+		t.Fatalf("Expected the last page of rebuildIndexes to mark the indexes built")
+	}
+	expectError(t, stub.as(t, "Org1MSP", nil).invoke("tx4", "del", "Model-tx1", "retired"), codeInvalidState)
+}
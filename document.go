@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// digestLengths maps the supported digest algorithms to their length in bytes
+var digestLengths = map[string]int{
+	"sha256":   32,
+	"sha512":   64,
+	"sha3-256": 32,
+	"sha3-512": 64,
+}
+
+// documentObjectType prefixes the document revisions of an Agreement
+const documentObjectType = "AgreementDocument"
+
+// DocumentRevision records one version of the off-chain document of an Agreement
+type DocumentRevision struct {
+	ObjectType  string `json:"docType"`
+	AgreementID string `json:"AgreementID"`
+	Revision    int    `json:"revision"`
+	Hash        string `json:"hash"`
+	Record_time string `json:"record_time"`
+	TxID        string `json:"txid"`
+}
+
+// Reasons a presented digest does not match the current document
+const (
+	mismatchSuperseded = "SUPERSEDED"         // the digest is of an earlier revision
+	mismatchAlgorithm  = "ALGORITHM_MISMATCH" // the digest uses another algorithm than the document hash
+	mismatchDigest     = "DIGEST_MISMATCH"
+)
+
+// DocumentVerification is the result of verifyAgreementDocument
+type DocumentVerification struct {
+	AgreementID string `json:"AgreementID"`
+	Match       bool   `json:"match"`     // the digest is the current document hash
+	Algorithm   string `json:"algorithm"` // algorithm of the current document hash
+	Revision    int    `json:"revision"`  // revision of the current document
+	// earlier revision the digest matches, set when the document was superseded
+	Matched_revision int    `json:"matched_revision,omitempty"`
+	Reason           string `json:"reason,omitempty"` // why the digest does not match
+}
+
+// parseDigest checks an algorithm-tagged digest such as sha256:<hex> and
+// returns it with a lower-case hex part
+func parseDigest(field string, digest string) (string, string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 {
+		return "", "", &FieldError{field, "must be an algorithm-tagged digest such as sha256:<hex>"}
+	}
+	algorithm := strings.ToLower(parts[0])
+	length, ok := digestLengths[algorithm]
+	if !ok {
+		return "", "", &FieldError{field, "algorithm must be sha256, sha512, sha3-256 or sha3-512, got " + parts[0]}
+	}
+	value, err := hex.DecodeString(parts[1])
+	if err != nil || len(value) != length {
+		return "", "", &FieldError{field, fmt.Sprintf("%s digest must be %d hex characters", algorithm, 2*length)}
+	}
+	return algorithm + ":" + hex.EncodeToString(value), algorithm, nil
+}
+
+// normalizeDocumentHash validates an optional document hash, empty stays empty
+func normalizeDocumentHash(field string, hash string) (string, error) {
+	if hash == "" {
+		return "", nil
+	}
+	normalized, _, err := parseDigest(field, hash)
+	return normalized, err
+}
+
+// recordDocument adds the current document hash of an Agreement to its
+// document history. Confidential Agreements keep their hash off the ledger
+// and have no public history.
+func recordDocument(APIstub shim.ChaincodeStubInterface, Agreement *Agreement, record_time string) error {
+	if Agreement.Agreement_collection != "" || Agreement.Agreement_hash == "" {
+		return nil
+	}
+	Agreement.Agreement_document_revision++
+
+	documentKey, err := APIstub.CreateCompositeKey(documentObjectType, []string{Agreement.AgreementID, fmt.Sprintf("%06d", Agreement.Agreement_document_revision)})
+	if err != nil {
+		return err
+	}
+	documentAsBytes, err := json.Marshal(DocumentRevision{"agreementDocument", Agreement.AgreementID, Agreement.Agreement_document_revision, Agreement.Agreement_hash, record_time, APIstub.GetTxID()})
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(documentKey, documentAsBytes)
+}
+
+// documentHistory returns the document revisions of an Agreement, oldest first
+func documentHistory(APIstub shim.ChaincodeStubInterface, AgreementID string) ([]DocumentRevision, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(documentObjectType, []string{AgreementID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	revisions := []DocumentRevision{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		revision := DocumentRevision{}
+		err = json.Unmarshal(queryResponse.Value, &revision)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid document revision %s: %s", queryResponse.Key, err.Error())
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+// ===========================================================================
+// verifyAgreementDocument - check an off-chain document against the hash of
+// an Agreement
+//
+// args[0] AgreementID
+// args[1] digest of the document, e.g. sha256:<hex>
+//
+// The document itself never goes on the ledger, the client presents its digest.
+// ===========================================================================
+func (t *MAGNIT_CC) verifyAgreementDocument(ctx *TransactionContext, AgreementID string, presented string) (*DocumentVerification, error) {
+
+	APIstub := ctx.GetStub()
+	digest, algorithm, err := parseDigest("digest", presented)
+	if err != nil {
+		return nil, err
+	}
+
+	Agreement, err := readAgreement(APIstub, AgreementID)
+	if err != nil {
+		return nil, err
+	} else if Agreement == nil {
+		return nil, notFoundError("Agreement not exist")
+	}
+
+	// the hash of a confidential Agreement is in its private terms
+	view, err := withTerms(APIstub, Agreement)
+	if err != nil {
+		return nil, err
+	}
+	if view.Agreement_hash == "" {
+		return nil, stateError("Agreement " + Agreement.AgreementID + " has no document hash")
+	}
+	_, storedAlgorithm, err := parseDigest("Agreement_hash", view.Agreement_hash)
+	if err != nil {
+		return nil, stateError("Agreement_hash of " + Agreement.AgreementID + " is not an algorithm-tagged digest and cannot be verified")
+	}
+
+	verification := &DocumentVerification{
+		AgreementID: Agreement.AgreementID,
+		Match:       digest == view.Agreement_hash,
+		Algorithm:   storedAlgorithm,
+		Revision:    Agreement.Agreement_document_revision,
+	}
+	if !verification.Match {
+		revisions, err := documentHistory(APIstub, Agreement.AgreementID)
+		if err != nil {
+			return nil, err
+		}
+		for _, revision := range revisions {
+			if revision.Hash == digest {
+				verification.Matched_revision = revision.Revision
+			}
+		}
+		switch {
+		case verification.Matched_revision != 0:
+			verification.Reason = mismatchSuperseded
+		case algorithm != storedAlgorithm:
+			verification.Reason = mismatchAlgorithm
+		default:
+			verification.Reason = mismatchDigest
+		}
+	}
+	return verification, nil
+}
+
+// ===========================================================================
+// queryAgreementDocuments - document revisions of an Agreement, oldest first
+// ===========================================================================
+func (t *MAGNIT_CC) queryAgreementDocuments(ctx *TransactionContext, AgreementID string) ([]DocumentRevision, error) {
+	return documentHistory(ctx.GetStub(), AgreementID)
+}
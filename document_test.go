@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// docHash is the document digest of the agreements created by the tests
+var docHash = "sha256:" + strings.Repeat("ab", 32)
+
+func verifyDocument(t *testing.T, stub *callerStub, txID string, AgreementID string, digest string) DocumentVerification {
+	t.Helper()
+	res := stub.invoke(txID, "verifyAgreementDocument", AgreementID, digest)
+	expectOK(t, res)
+	verification := DocumentVerification{}
+	json.Unmarshal(res.Payload, &verification)
+	return verification
+}
+
+func TestDocumentHashFormat(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", nil)
+	insert := func(txID string, hash string) {
+		t.Helper()
+		expectFieldError(t, stub.invoke(txID, "insertAgreementinfo", "Agreement", "Model-tx1", "10", "Org1MSP", "Org2MSP", "", "", "", hash), "Agreement_hash")
+	}
+	insert("tx3", "hash")
+	insert("tx4", "md5:"+strings.Repeat("ab", 16))
+	insert("tx5", "sha256:"+strings.Repeat("ab", 31))
+	insert("tx6", "sha512:"+strings.Repeat("zz", 64))
+
+	res := stub.invoke("tx7", "insertAgreementinfo", `{"Agreement_model_id":"Model-tx1","Agreement_model_account_use":1,"Agreement_issuer":"Org1MSP","Agreement_participant":"Org2MSP","Agreement_hash":"SHA3-256:`+strings.Repeat("AB", 32)+`"}`)
+	expectOK(t, res)
+	Agreement := &Agreement{}
+	json.Unmarshal(res.Payload, Agreement)
+	if Agreement.Agreement_hash != "sha3-256:"+strings.Repeat("ab", 32) || Agreement.Agreement_document_revision != 1 {
+		t.Fatalf("Hash was not normalized: %s", res.Payload)
+	}
+}
+
+func TestVerifyAgreementDocument(t *testing.T) {
+	stub := setupActiveAgreement(t).as(t, "Org2MSP", nil)
+
+	if verification := verifyDocument(t, stub, "q1", "Agreement-tx2", strings.ToUpper(docHash)); !verification.Match || verification.Algorithm != "sha256" || verification.Revision != 1 || verification.Reason != "" {
+		t.Fatalf("Expected the document to match: %+v", verification)
+	}
+	if verification := verifyDocument(t, stub, "q2", "Agreement-tx2", "sha256:"+strings.Repeat("00", 32)); verification.Match || verification.Matched_revision != 0 || verification.Reason != mismatchDigest {
+		t.Fatalf("Expected another document not to match: %+v", verification)
+	}
+	// the stored algorithm is reported, not the presented one
+	if verification := verifyDocument(t, stub, "q2a", "Agreement-tx2", "sha512:"+strings.Repeat("ab", 64)); verification.Match || verification.Algorithm != "sha256" || verification.Reason != mismatchAlgorithm {
+		t.Fatalf("Expected an algorithm mismatch: %+v", verification)
+	}
+	expectFieldError(t, stub.invoke("q3", "verifyAgreementDocument", "Agreement-tx2", "document"), "digest")
+	expectDenied(t, stub.as(t, "Org3MSP", nil).invoke("q4", "verifyAgreementDocument", "Agreement-tx2", docHash))
+
+	// a new document revision supersedes the first one
+	revised := "sha512:" + strings.Repeat("ef", 64)
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("tx3", "proposeAmendment", `{"AgreementID":"Agreement-tx2","Agreement_hash":"`+revised+`"}`))
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke("tx4", "acceptAmendment", "Amendment-tx3"))
+
+	if verification := verifyDocument(t, stub, "q5", "Agreement-tx2", docHash); verification.Match || verification.Matched_revision != 1 || verification.Revision != 2 || verification.Algorithm != "sha512" || verification.Reason != mismatchSuperseded {
+		t.Fatalf("Expected the first revision to be superseded: %+v", verification)
+	}
+	if verification := verifyDocument(t, stub, "q6", "Agreement-tx2", revised); !verification.Match {
+		t.Fatalf("Expected the revised document to match: %+v", verification)
+	}
+
+	res := stub.invoke("q7", "queryAgreementDocuments", "Agreement-tx2")
+	expectOK(t, res)
+	revisions := []DocumentRevision{}
+	json.Unmarshal(res.Payload, &revisions)
+	if len(revisions) != 2 || revisions[0].Hash != docHash || revisions[1].Hash != revised || revisions[1].Revision != 2 {
+		t.Fatalf("Unexpected document history %s", res.Payload)
+	}
+}
+
+func TestVerifyPrivateDocument(t *testing.T) {
+	stub := setupPrivateAgreement(t).as(t, "Org2MSP", nil)
+
+	if verification := verifyDocument(t, stub, "q1", "Agreement-tx3", "sha512:"+privateDocDigest); !verification.Match {
+		t.Fatalf("Expected the private document to match: %+v", verification)
+	}
+	if strings.Contains(string(stub.invoke("q2", "queryAgreementDocuments", "Agreement-tx3").Payload), privateDocDigest) {
+		t.Fatalf("Private document hash is in the public history")
+	}
+}
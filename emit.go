@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/imineev/cc1/events"
+)
+
+// txTimestampUTC returns the transaction timestamp as RFC3339 in UTC
+func txTimestampUTC(APIstub shim.ChaincodeStubInterface) (string, error) {
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return "", err
+	}
+	return txTime.UTC().Format(time.RFC3339Nano), nil
+}
+
+// emitEvent sets the event of the current transaction. Version, submitter,
+// tx timestamp and tx ID are filled in, details may be nil.
+func emitEvent(APIstub shim.ChaincodeStubInterface, event *events.Event, details interface{}) error {
+	err := stampEvent(APIstub, event, details)
+	if err != nil {
+		return err
+	}
+
+	eventAsBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	err = APIstub.SetEvent(event.Type, eventAsBytes)
+	if err != nil {
+		return fmt.Errorf("Failed to emit event %s: %s", event.Type, err.Error())
+	}
+	return nil
+}
+
+// stampEvent fills in version, submitter, tx timestamp and tx ID of an
+// event and encodes its details, for events nested in the details of others
+func stampEvent(APIstub shim.ChaincodeStubInterface, event *events.Event, details interface{}) error {
+	caller, err := getCaller(APIstub)
+	if err != nil {
+		return err
+	}
+	timestamp, err := txTimestampUTC(APIstub)
+	if err != nil {
+		return err
+	}
+
+	event.Version = events.Version
+	event.ActorMSP = caller.MSPID
+	event.Timestamp = timestamp
+	event.TxID = APIstub.GetTxID()
+	if details != nil {
+		event.Details, err = json.Marshal(details)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// agreementDetails are the event details of an Agreement
+func agreementDetails(Agreement *Agreement) *events.AgreementDetails {
+	return &events.AgreementDetails{
+		ModelID:      Agreement.Agreement_model_id,
+		ModelVersion: Agreement.Agreement_model_version,
+		Issuer:       Agreement.Agreement_issuer,
+		Participant:  Agreement.Agreement_participant,
+	}
+}
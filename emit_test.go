@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/imineev/cc1/events"
+)
+
+// lastEvent decodes the single event of the last invocation
+func lastEvent(t *testing.T, stub *callerStub, eventType string) *events.Event {
+	t.Helper()
+	if len(stub.events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(stub.events))
+	}
+	if stub.events[0].EventName != eventType {
+		t.Fatalf("Expected event %s, got %s", eventType, stub.events[0].EventName)
+	}
+	event, err := events.Decode(stub.events[0].Payload)
+	if err != nil {
+		t.Fatalf("Invalid event %s: %v", stub.events[0].Payload, err)
+	}
+	if event.Type != eventType || event.TxID == "" || event.Timestamp == "" {
+		t.Fatalf("Incomplete event %s", stub.events[0].Payload)
+	}
+	return event
+}
+
+func TestEventsOfAgreementLifecycle(t *testing.T) {
+	stub := setupAgreement(t)
+	event := lastEvent(t, stub, events.AgreementCreated)
+	if event.AssetID != "Agreement-tx2" || event.ActorMSP != "Org1MSP" || event.NewStatus != StatusProposed || event.TxID != "tx2" {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	details := &events.AgreementDetails{}
+	if err := event.DecodeDetails(details); err != nil || details.ModelID != "Model-tx1" || details.Participant != "Org2MSP" {
+		t.Fatalf("Unexpected details %+v %v", details, err)
+	}
+
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke("tx3", "approveAgreement", "Agreement-tx2"))
+	event = lastEvent(t, stub, events.AgreementStatusChanged)
+	if event.ActorMSP != "Org2MSP" || event.PreviousStatus != StatusProposed || event.NewStatus != StatusApproved {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("tx4", "activateAgreement", "Agreement-tx2"))
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke("tx5", "queryModelByAgreementID", "Agreement-tx2"))
+	usage := &events.UsageDetails{}
+	lastEvent(t, stub, events.ModelUsed).DecodeDetails(usage)
+	if usage.Units != 1 || usage.Consumer != "Org2MSP" || usage.ModelID != "Model-tx1" {
+		t.Fatalf("Unexpected usage details %+v", usage)
+	}
+
+	expectOK(t, stub.invoke("tx6", "compactUsage", "Agreement-tx2"))
+	lastEvent(t, stub, events.UsageCompacted)
+
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("tx7", "del", "Model-tx1", "retired", "suspend"))
+	deletion := &events.DeletionDetails{}
+	lastEvent(t, stub, events.ModelDeleted).DecodeDetails(deletion)
+	if deletion.Reason != "retired" || len(deletion.SuspendedAgreements) != 1 || deletion.SuspendedAgreements[0] != "Agreement-tx2" {
+		t.Fatalf("Unexpected deletion details %+v", deletion)
+	}
+}
+
+func TestEventsOfModels(t *testing.T) {
+	stub := newCallerStub(t).as(t, "Org1MSP", nil)
+
+	expectOK(t, stub.invoke("tx1", "initmodel", "Resnet", "Org1MSP", "1.0", "", "pytorch", digest("aa"), "s3://resnet/1.0", "MIT", ""))
+	details := &events.ModelDetails{}
+	lastEvent(t, stub, events.ModelCreated).DecodeDetails(details)
+	if details.UploadOrg != "Org1MSP" || details.ModelVersion != "1.0" {
+		t.Fatalf("Unexpected details %+v", details)
+	}
+
+	expectOK(t, stub.invoke("tx2", "publishModelVersion", "Model-tx1", "2.0", digest("bb"), "s3://resnet/2.0"))
+	lastEvent(t, stub, events.ModelVersionPublished).DecodeDetails(details)
+	if details.ModelVersion != "2.0" || details.ArtifactHash != digest("bb") {
+		t.Fatalf("Unexpected details %+v", details)
+	}
+
+	stub.as(t, "Org1MSP", adminAttrs)
+	expectOK(t, stub.invoke("tx3", "rebuildIndexes"))
+	lastEvent(t, stub, events.IndexesRebuilt)
+	expectOK(t, stub.invoke("tx4", "migrateModels"))
+	lastEvent(t, stub, events.ModelsMigrated)
+
+	// reads emit nothing
+	expectOK(t, stub.invoke("q1", "queryByModel_id", "Model-tx1"))
+	if len(stub.events) != 0 {
+		t.Fatalf("Query emitted %d events", len(stub.events))
+	}
+}
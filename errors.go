@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Stable error codes, see errorCodes for their statuses. The refused
+// consumptions have the codes of usage.go.
+const (
+	codeInvalidArgument = "INVALID_ARGUMENT"
+	codeInvalidField    = "INVALID_FIELD"
+	codeUnauthenticated = "UNAUTHENTICATED"
+	codeAccessDenied    = "ACCESS_DENIED"
+	codeNotFound        = "NOT_FOUND"
+	codeUnknownFunction = "UNKNOWN_FUNCTION"
+	codeAlreadyExists   = "ALREADY_EXISTS"
+	codeInvalidState    = "INVALID_STATE"
+	codeNotSupported    = "NOT_SUPPORTED"
+	codeUnavailable     = "UNAVAILABLE"
+	codeInternal        = "INTERNAL"
+)
+
+// ErrorCode documents an error code with the HTTP status a gateway should
+// answer with
+type ErrorCode struct {
+	Code        string `json:"code"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+}
+
+// errorCodes lists every code a function can fail with, describe returns it
+var errorCodes = []ErrorCode{
+	{codeInvalidArgument, 400, "the arguments do not match the function, see describe"},
+	{codeInvalidField, 400, "input fields are missing or invalid, field names the first and details.violations lists all"},
+	{codeUnauthenticated, 401, "the submitter's certificate cannot be read"},
+	{codeAccessDenied, 403, "the access policy of the function refuses the submitter"},
+	{codeNotFound, 404, "the Agreement, model, amendment or record does not exist"},
+	{codeUnknownFunction, 404, "no function of this name is registered"},
+	{codeAlreadyExists, 409, "the record to create already exists"},
+	{codeInvalidState, 409, "the status of the record does not allow the operation"},
+	{codeAgreementNotActive, 409, "the Agreement is not active"},
+	{codeAgreementNotYetValid, 409, "the validity of the Agreement has not started"},
+	{codeAgreementExpired, 409, "the validity of the Agreement has ended"},
+	{codeQuotaExhausted, 429, "the quota of the Agreement is exhausted"},
+	{codePeriodQuotaExhausted, 429, "a period quota of the Agreement is exhausted until resets_at"},
+	{codeGrantRedeemed, 409, "the access grant was already redeemed"},
+	{codeGrantExpired, 410, "the access grant is past its expires_at"},
+	{codeNotSupported, 501, "the state database does not support the query"},
+	{codeUnavailable, 503, "the data is not available on this peer, e.g. private data of other organizations"},
+	{codeInternal, 500, "the ledger failed or holds an invalid record"},
+}
+
+// ChaincodeError is the one error of every function, its JSON is the message
+// of the failed response and the response status is its status
+type ChaincodeError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Field   string      `json:"field,omitempty"`   // the offending input field
+	Status  int         `json:"status"`            // HTTP-like
+	Details interface{} `json:"details,omitempty"` // code specific, e.g. a UsageError
+}
+
+func (e *ChaincodeError) Error() string {
+	return e.Message
+}
+
+// newError returns an error with the status of its code
+func newError(code string, message string) *ChaincodeError {
+	status := 500
+	for _, errorCode := range errorCodes {
+		if errorCode.Code == code {
+			status = errorCode.Status
+		}
+	}
+	return &ChaincodeError{Code: code, Message: message, Status: status}
+}
+
+// argumentError reports arguments that do not match the function
+func argumentError(message string) error {
+	return newError(codeInvalidArgument, message)
+}
+
+// notFoundError reports a record that does not exist
+func notFoundError(message string) error {
+	return newError(codeNotFound, message)
+}
+
+// stateError reports a record whose status does not allow the operation
+func stateError(message string) error {
+	return newError(codeInvalidState, message)
+}
+
+// asChaincodeError converts any error to a ChaincodeError, errors without a
+// code are internal
+func asChaincodeError(err error) *ChaincodeError {
+	switch e := err.(type) {
+	case *ChaincodeError:
+		return e
+	case *FieldError:
+		return asChaincodeError(&ValidationError{[]*FieldError{e}})
+	case *ValidationError:
+		// field names the first violation, details list all of them
+		chaincodeErr := newError(codeInvalidField, e.Error())
+		chaincodeErr.Field = e.Violations[0].Field
+		chaincodeErr.Details = e
+		return chaincodeErr
+	case *UsageError:
+		chaincodeErr := newError(e.Code, e.Message)
+		chaincodeErr.Details = e
+		return chaincodeErr
+	}
+	return newError(codeInternal, err.Error())
+}
+
+// errorResponse builds the failed response of err
+func errorResponse(err error) peer.Response {
+	chaincodeErr := asChaincodeError(err)
+	errAsBytes, marshalErr := json.Marshal(chaincodeErr)
+	if marshalErr != nil {
+		errAsBytes = []byte(fmt.Sprintf(`{"code":%q,"message":%q,"status":500}`, codeInternal, marshalErr.Error()))
+	}
+	return peer.Response{Status: int32(chaincodeErr.Status), Message: string(errAsBytes)}
+}
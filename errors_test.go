@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// expectError checks that res failed with code and the status of the code
+func expectError(t *testing.T, res peer.Response, code string) *ChaincodeError {
+	t.Helper()
+	chaincodeErr := &ChaincodeError{}
+	if err := json.Unmarshal([]byte(res.Message), chaincodeErr); err != nil {
+		t.Fatalf("Failure is not a structured error: %s", res.Message)
+	}
+	if chaincodeErr.Code != code {
+		t.Fatalf("Expected code %s, got %s", code, res.Message)
+	}
+	if chaincodeErr.Status != newError(code, "").Status || res.Status != int32(chaincodeErr.Status) {
+		t.Fatalf("Unexpected status %d of %s", res.Status, res.Message)
+	}
+	return chaincodeErr
+}
+
+// errorDetails decodes the details of an error into details
+func errorDetails(t *testing.T, chaincodeErr *ChaincodeError, details interface{}) {
+	t.Helper()
+	detailsAsBytes, _ := json.Marshal(chaincodeErr.Details)
+	if err := json.Unmarshal(detailsAsBytes, details); err != nil {
+		t.Fatalf("Invalid details %s", detailsAsBytes)
+	}
+}
+
+func TestErrorStatuses(t *testing.T) {
+	stub := setupAgreement(t)
+
+	res := stub.invoke("q1", "queryByModel_id", "Model-missing")
+	expectError(t, res, codeNotFound)
+	if res.Status != 404 {
+		t.Fatalf("Expected status 404, got %d", res.Status)
+	}
+	if chaincodeErr := expectError(t, errorResponse(fmt.Errorf("ledger failure")), codeInternal); chaincodeErr.Message != "ledger failure" {
+		t.Fatalf("Unexpected internal error %+v", chaincodeErr)
+	}
+
+	res = stub.invoke("q2", "describe")
+	expectOK(t, res)
+	api := APIDescription{}
+	json.Unmarshal(res.Payload, &api)
+	codes := map[string]int{}
+	for _, errorCode := range api.Errors {
+		codes[errorCode.Code] = errorCode.Status
+	}
+	if len(codes) != len(errorCodes) || codes[codeAccessDenied] != 403 || codes[codeQuotaExhausted] != 429 {
+		t.Fatalf("Expected describe to list the error codes: %+v", api.Errors)
+	}
+}
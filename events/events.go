@@ -0,0 +1,158 @@
+// Package events defines the chaincode events emitted by MAGNIT_CC, so
+// off-chain listeners can decode them without parsing free-form text.
+//
+// Every state-changing transaction emits exactly one event. Its chaincode
+// event name is the event type and its payload is an Event encoded as JSON.
+// Fabric keeps only the last event of a transaction, so a transaction that
+// changes several assets reports the others in the details of its event.
+//
+// Event catalogue, with the details type of each event:
+//
+//	ModelCreated            ModelDetails
+//	ModelVersionPublished   ModelDetails
+//	ModelDeleted            DeletionDetails
+//	ModelsMigrated          MaintenanceDetails
+//	AgreementCreated        AgreementDetails
+//	AgreementStatusChanged  AgreementDetails
+//	AgreementDeleted        DeletionDetails
+//	AgreementSigned         SignatureDetails
+//	AmendmentProposed       AmendmentDetails
+//	AmendmentAccepted       AmendmentDetails
+//	AmendmentRejected       AmendmentDetails
+//	AgreementsExpired       ExpiryDetails
+//	ModelUsed               UsageDetails
+//	UsageCompacted          UsageDetails
+//	AccessGrantRedeemed     GrantDetails
+//	IndexesRebuilt          MaintenanceDetails
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Version is the payload version of the events in this package. It changes
+// whenever a field is removed or changes meaning.
+const Version = 1
+
+// Event types, also used as chaincode event names
+const (
+	ModelCreated           = "ModelCreated"
+	ModelVersionPublished  = "ModelVersionPublished"
+	ModelDeleted           = "ModelDeleted"
+	ModelsMigrated         = "ModelsMigrated"
+	AgreementCreated       = "AgreementCreated"
+	AgreementStatusChanged = "AgreementStatusChanged"
+	AgreementDeleted       = "AgreementDeleted"
+	AgreementsExpired      = "AgreementsExpired"
+	AgreementSigned        = "AgreementSigned"
+	AmendmentProposed      = "AmendmentProposed"
+	AmendmentAccepted      = "AmendmentAccepted"
+	AmendmentRejected      = "AmendmentRejected"
+	ModelUsed              = "ModelUsed"
+	UsageCompacted         = "UsageCompacted"
+	AccessGrantRedeemed    = "AccessGrantRedeemed"
+	IndexesRebuilt         = "IndexesRebuilt"
+)
+
+// Event is the payload of every chaincode event
+type Event struct {
+	Version        int             `json:"version"`
+	Type           string          `json:"type"`
+	AssetID        string          `json:"asset_id,omitempty"` // Model or Agreement ID
+	ActorMSP       string          `json:"actor_msp"`          // MSP ID of the submitter
+	PreviousStatus string          `json:"previous_status,omitempty"`
+	NewStatus      string          `json:"new_status,omitempty"`
+	Timestamp      string          `json:"timestamp"` // tx timestamp, RFC3339 in UTC
+	TxID           string          `json:"tx_id"`
+	Details        json.RawMessage `json:"details,omitempty"`
+}
+
+// ModelDetails describes a created model or a published model version
+type ModelDetails struct {
+	UploadOrg    string `json:"upload_org"`
+	ModelVersion string `json:"model_version,omitempty"`
+	ArtifactHash string `json:"artifact_hash,omitempty"`
+}
+
+// AgreementDetails describes a created Agreement or a status change
+type AgreementDetails struct {
+	ModelID      string `json:"model_id"`
+	ModelVersion string `json:"model_version"`
+	Issuer       string `json:"issuer"`
+	Participant  string `json:"participant"`
+}
+
+// DeletionDetails describes a soft deletion
+type DeletionDetails struct {
+	Reason string `json:"reason"`
+	// Agreements suspended because their model was deleted
+	SuspendedAgreements []string `json:"suspended_agreements,omitempty"`
+}
+
+// SignatureDetails describes a signature of an Agreement. The event sets
+// previous_status and new_status when the signature activated the Agreement.
+type SignatureDetails struct {
+	Signer     string `json:"signer"` // MSP ID of the signing party
+	Signatures int    `json:"signatures"`
+	Threshold  int    `json:"threshold"`
+}
+
+// AmendmentDetails describes an Amendment, the event asset is the AmendmentID
+type AmendmentDetails struct {
+	AgreementID string   `json:"agreement_id"`
+	Revision    int      `json:"revision"`
+	Fields      []string `json:"fields"` // changed fields
+}
+
+// ExpiryDetails lists the Agreements expired by a sweep. Transitions holds
+// the AgreementStatusChanged event of every expired Agreement, in the order
+// of Agreements.
+type ExpiryDetails struct {
+	Agreements  []string `json:"agreements"`
+	Transitions []Event  `json:"transitions"`
+	More        bool     `json:"more"` // the sweep stopped at its limit
+}
+
+// UsageDetails describes consumption of an Agreement
+type UsageDetails struct {
+	ModelID      string `json:"model_id,omitempty"`
+	ModelVersion string `json:"model_version,omitempty"`
+	Units        int    `json:"units"` // units consumed or compacted by the transaction
+	Consumer     string `json:"consumer,omitempty"`
+}
+
+// GrantDetails describes a redeemed access grant, the event asset is the
+// AgreementID
+type GrantDetails struct {
+	Nonce        string `json:"nonce"`
+	Consumer     string `json:"consumer"` // MSP ID the grant was issued to
+	ModelID      string `json:"model_id"`
+	ModelVersion string `json:"model_version"`
+}
+
+// MaintenanceDetails describes an administrative bulk operation
+type MaintenanceDetails struct {
+	Records int    `json:"records"`
+	Next    string `json:"next,omitempty"` // key to continue from
+}
+
+// Decode parses an event payload and checks its version
+func Decode(payload []byte) (*Event, error) {
+	event := &Event{}
+	if err := json.Unmarshal(payload, event); err != nil {
+		return nil, err
+	}
+	if event.Version != Version {
+		return nil, fmt.Errorf("unsupported event version %d", event.Version)
+	}
+	return event, nil
+}
+
+// DecodeDetails parses the details of an event into the type listed in the catalogue
+func (e *Event) DecodeDetails(details interface{}) error {
+	if len(e.Details) == 0 {
+		return fmt.Errorf("event %s has no details", e.Type)
+	}
+	return json.Unmarshal(e.Details, details)
+}
@@ -0,0 +1,25 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	payload, _ := json.Marshal(Event{Version: Version, Type: ModelUsed, Details: json.RawMessage(`{"units":2}`)})
+	event, err := Decode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	details := &UsageDetails{}
+	if err := event.DecodeDetails(details); err != nil || details.Units != 2 {
+		t.Fatalf("Unexpected details %+v %v", details, err)
+	}
+
+	if _, err := Decode([]byte(`{"version":2,"type":"ModelUsed"}`)); err == nil {
+		t.Fatalf("Expected an unknown version to be refused")
+	}
+	if err := (&Event{Type: ModelCreated}).DecodeDetails(details); err == nil {
+		t.Fatalf("Expected missing details to be reported")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/imineev/cc1/events"
+)
+
+// accessGrantObjectType prefixes the keys of the access grants
+const accessGrantObjectType = "AccessGrant"
+
+// accessGrantTTL is how long a grant can be redeemed after the consumption
+// that minted it. Both ends are proposal timestamps set by the submitting
+// clients, endorsers only check them within their clock skew window, so the
+// TTL bounds replays of a grant but is no exact expiry.
+const accessGrantTTL = 5 * time.Minute
+
+// Stable codes of refused redemptions
+const (
+	codeGrantRedeemed = "GRANT_ALREADY_REDEEMED"
+	codeGrantExpired  = "GRANT_EXPIRED"
+)
+
+// AccessGrant is a single-use permission to fetch the model of an Agreement
+// from the off-chain model server. Every consumption mints one and the model
+// server redeems it with redeemAccessGrant.
+//
+// The nonce is derived from the transaction ID and the consuming identity,
+// so every endorser mints the same grant. It is readable on the channel like
+// the transaction itself and is no secret. The grant records the public key
+// of the consuming identity instead: the requester signs the nonce with its
+// private key and the model server redeems the grant with that signature.
+type AccessGrant struct {
+	ObjectType          string `json:"docType"`
+	Nonce               string `json:"nonce"`
+	AgreementID         string `json:"AgreementID"`
+	Consumer            string `json:"consumer"`     // MSP ID the grant is bound to
+	Consumer_id         string `json:"consumer_id"`  // identity within the MSP that consumed
+	Consumer_key        string `json:"consumer_key"` // PEM public key verifying the signed nonce
+	Model_id            string `json:"model_id"`
+	Model_version       string `json:"model_version"`
+	Model_artifact_hash string `json:"model_artifact_hash"`
+	Issue_time          string `json:"issue_time"`
+	Issue_txid          string `json:"issue_txid"`
+	Expires_at          string `json:"expires_at"`            // RFC3339 in UTC
+	Redeemed_by         string `json:"redeemed_by,omitempty"` // MSP ID of the model server
+	Redeem_time         string `json:"redeem_time,omitempty"`
+	Redeem_txid         string `json:"redeem_txid,omitempty"`
+}
+
+// readAccessGrant returns the grant of nonce, or nil if none was minted
+func readAccessGrant(APIstub shim.ChaincodeStubInterface, nonce string) (*AccessGrant, error) {
+	grantKey, err := APIstub.CreateCompositeKey(accessGrantObjectType, []string{nonce})
+	if err != nil {
+		return nil, err
+	}
+	grantAsBytes, err := APIstub.GetState(grantKey)
+	if err != nil {
+		return nil, err
+	} else if grantAsBytes == nil {
+		return nil, nil
+	}
+
+	grant := &AccessGrant{}
+	err = json.Unmarshal(grantAsBytes, grant)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid access grant %s: %s", nonce, err.Error())
+	}
+	return grant, nil
+}
+
+// putAccessGrant stores a grant under its nonce
+func putAccessGrant(APIstub shim.ChaincodeStubInterface, grant *AccessGrant) error {
+	grantKey, err := APIstub.CreateCompositeKey(accessGrantObjectType, []string{grant.Nonce})
+	if err != nil {
+		return err
+	}
+	grantAsBytes, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(grantKey, grantAsBytes)
+}
+
+// mintAccessGrant stores a grant of the model of a consumption for the
+// identity that consumed
+func mintAccessGrant(APIstub shim.ChaincodeStubInterface, consumption *Consumption, consumer *Caller) (*AccessGrant, error) {
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := cid.GetX509Certificate(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	keyAsBytes, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	txID := APIstub.GetTxID()
+	digest := sha256.Sum256([]byte(txID + ":" + consumption.AgreementID + ":" + consumer.ID))
+
+	grant := &AccessGrant{
+		ObjectType:          "accessGrant",
+		Nonce:               hex.EncodeToString(digest[:]),
+		AgreementID:         consumption.AgreementID,
+		Consumer:            consumer.MSPID,
+		Consumer_id:         consumer.ID,
+		Consumer_key:        string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: keyAsBytes})),
+		Model_id:            consumption.Model.Model_id,
+		Model_version:       consumption.Model.Model_version,
+		Model_artifact_hash: consumption.Model.Model_artifact_hash,
+		Issue_time:          now.UTC().Format(time.RFC3339),
+		Issue_txid:          txID,
+		Expires_at:          now.Add(accessGrantTTL).UTC().Format(time.RFC3339),
+	}
+	return grant, putAccessGrant(APIstub, grant)
+}
+
+// verifyNonceSignature checks a base64 ASN.1 ECDSA signature of the sha256
+// digest of nonce against the PEM public key of a grant
+func verifyNonceSignature(publicKeyPEM string, nonce string, signature string) error {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return errors.New("no public key")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	ecdsaKey, ok := publicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("the consuming identity has no ECDSA key")
+	}
+
+	signatureAsBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.New("signature is not base64")
+	}
+	rs := struct{ R, S *big.Int }{}
+	if rest, err := asn1.Unmarshal(signatureAsBytes, &rs); err != nil || len(rest) > 0 {
+		return errors.New("signature is not an ASN.1 ECDSA signature")
+	}
+	digest := sha256.Sum256([]byte(nonce))
+	if !ecdsa.Verify(ecdsaKey, digest[:], rs.R, rs.S) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// ===========================================================================
+// redeemAccessGrant - redeem an access grant for the model server
+//
+// nonce - of the grant returned by consumeModelUsage
+// signature - the requester's base64 ASN.1 ECDSA signature of the sha256
+// digest of the nonce, made with the key of the identity that consumed
+//
+// Returns the grant with the model version to serve. A grant is redeemed
+// once, a replay fails with GRANT_ALREADY_REDEEMED and a grant past its
+// expiry, by the proposal time of the redemption, with GRANT_EXPIRED.
+// ===========================================================================
+func (t *MAGNIT_CC) redeemAccessGrant(ctx *TransactionContext, nonce string, signature string) (*AccessGrant, error) {
+
+	APIstub := ctx.GetStub()
+	grant, err := readAccessGrant(APIstub, nonce)
+	if err != nil {
+		return nil, err
+	} else if grant == nil {
+		return nil, notFoundError("Access grant does not exist: " + nonce)
+	}
+
+	if err := verifyNonceSignature(grant.Consumer_key, nonce, signature); err != nil {
+		return nil, newError(codeAccessDenied, "Access grant "+nonce+" is not signed by the identity it was issued to: "+err.Error())
+	}
+	if grant.Redeemed_by != "" {
+		return nil, newError(codeGrantRedeemed, "Access grant "+nonce+" was redeemed at "+grant.Redeem_time)
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	expires, err := time.Parse(time.RFC3339, grant.Expires_at)
+	if err != nil {
+		return nil, fmt.Errorf("Access grant %s has an invalid expires_at %q", nonce, grant.Expires_at)
+	}
+	if !now.Before(expires) {
+		return nil, newError(codeGrantExpired, "Access grant "+nonce+" expired at "+grant.Expires_at)
+	}
+
+	grant.Redeemed_by = ctx.GetCaller().MSPID
+	grant.Redeem_time = now.UTC().Format(time.RFC3339)
+	grant.Redeem_txid = APIstub.GetTxID()
+	err = putAccessGrant(APIstub, grant)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(APIstub, &events.Event{Type: events.AccessGrantRedeemed, AssetID: grant.AgreementID}, &events.GrantDetails{
+		Nonce:        grant.Nonce,
+		Consumer:     grant.Consumer,
+		ModelID:      grant.Model_id,
+		ModelVersion: grant.Model_version,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/imineev/cc1/events"
+)
+
+// mintGrant consumes a unit of the active Agreement as Org2MSP and returns
+// its grant with the key of the consuming identity
+func mintGrant(t *testing.T, stub *callerStub, txID string) (*AccessGrant, *ecdsa.PrivateKey) {
+	t.Helper()
+	res := stub.as(t, "Org2MSP", nil).invoke(txID, "consumeModelUsage", "Agreement-tx2", "1")
+	expectOK(t, res)
+	consumption := Consumption{}
+	json.Unmarshal(res.Payload, &consumption)
+	if consumption.Access_grant == nil || consumption.Access_grant.Nonce == "" {
+		t.Fatalf("Expected an access grant: %s", res.Payload)
+	}
+	return consumption.Access_grant, stub.key
+}
+
+// signNonce signs the nonce of a grant the way a consumer presents it
+func signNonce(t *testing.T, key *ecdsa.PrivateKey, nonce string) string {
+	t.Helper()
+	digest := sha256.Sum256([]byte(nonce))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+func TestRedeemAccessGrant(t *testing.T) {
+	stub := setupActiveAgreement(t)
+
+	grant, key := mintGrant(t, stub, "tx3")
+	if grant.Consumer != "Org2MSP" || grant.Consumer_key == "" || grant.Model_id != "Model-tx1" || grant.Redeemed_by != "" {
+		t.Fatalf("Unexpected grant %+v", grant)
+	}
+	other, otherKey := mintGrant(t, stub, "tx4")
+	if other.Nonce == grant.Nonce {
+		t.Fatalf("Expected every consumption to mint its own grant")
+	}
+	signature := signNonce(t, key, grant.Nonce)
+
+	// only the model's uploading organization redeems grants
+	expectDenied(t, stub.as(t, "Org2MSP", nil).invoke("tx5", "redeemAccessGrant", grant.Nonce, signature))
+	expectError(t, stub.as(t, "Org1MSP", nil).invoke("tx6", "redeemAccessGrant", "unknown", signature), codeNotFound)
+
+	// the nonce is public, only the consuming identity can sign it
+	expectError(t, stub.invoke("tx7", "redeemAccessGrant", grant.Nonce, signNonce(t, otherKey, grant.Nonce)), codeAccessDenied)
+	expectError(t, stub.invoke("tx8", "redeemAccessGrant", grant.Nonce, signNonce(t, key, other.Nonce)), codeAccessDenied)
+	expectError(t, stub.invoke("tx9", "redeemAccessGrant", grant.Nonce, "not base64"), codeAccessDenied)
+
+	res := stub.invoke("tx10", "redeemAccessGrant", grant.Nonce, signature)
+	expectOK(t, res)
+	redeemed := AccessGrant{}
+	json.Unmarshal(res.Payload, &redeemed)
+	if redeemed.Redeemed_by != "Org1MSP" || redeemed.Redeem_txid != "tx10" || redeemed.Model_version != grant.Model_version {
+		t.Fatalf("Unexpected redeemed grant %s", res.Payload)
+	}
+	details := events.GrantDetails{}
+	lastEvent(t, stub, events.AccessGrantRedeemed).DecodeDetails(&details)
+	if details.Nonce != grant.Nonce || details.Consumer != "Org2MSP" {
+		t.Fatalf("Unexpected event details %+v", details)
+	}
+
+	// a replay is refused
+	expectError(t, stub.invoke("tx11", "redeemAccessGrant", grant.Nonce, signature), codeGrantRedeemed)
+}
+
+func TestExpiredAccessGrant(t *testing.T) {
+	stub := setupActiveAgreement(t)
+	grant, key := mintGrant(t, stub, "tx3")
+
+	grant.Expires_at = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	stub.MockTransactionStart("rewind")
+	putAccessGrant(stub, grant)
+	stub.MockTransactionEnd("rewind")
+
+	expectError(t, stub.as(t, "Org1MSP", nil).invoke("tx4", "redeemAccessGrant", grant.Nonce, signNonce(t, key, grant.Nonce)), codeGrantExpired)
+}
+
+func TestLegacyConsumptionMintsGrant(t *testing.T) {
+	stub := setupActiveAgreement(t)
+
+	res := stub.as(t, "Org2MSP", nil).invoke("tx3", "queryModelByAgreementID", "Agreement-tx2")
+	expectOK(t, res)
+	legacy := struct {
+		Model_id     string       `json:"model_id"`
+		Access_grant *AccessGrant `json:"access_grant"`
+	}{}
+	json.Unmarshal(res.Payload, &legacy)
+	if legacy.Model_id != "Model-tx1" || legacy.Access_grant == nil {
+		t.Fatalf("Expected the model version with an access grant: %s", res.Payload)
+	}
+	key := stub.key
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("tx4", "redeemAccessGrant", legacy.Access_grant.Nonce, signNonce(t, key, legacy.Access_grant.Nonce)))
+}
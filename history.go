@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// HistoryEntry is one version of an Agreement or Model
+type HistoryEntry struct {
+	TxID      string      `json:"tx_id"`
+	Timestamp string      `json:"timestamp"` // commit tx timestamp, RFC3339 in UTC
+	IsDelete  bool        `json:"is_delete"`
+	Record    interface{} `json:"record"` // decoded record, null for a deletion
+	// fields changed since the previous version, when requested
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// HistoryFilter is the optional JSON filter of the history queries
+type HistoryFilter struct {
+	From  string `json:"from"`  // RFC3339, inclusive
+	To    string `json:"to"`    // RFC3339, exclusive
+	TxID  string `json:"tx_id"` // a single version
+	Diffs bool   `json:"diffs"` // add the changed fields of every version
+}
+
+// readHistoryFilter decodes the optional filter argument of the history queries
+func readHistoryFilter(args []string) (*HistoryFilter, time.Time, time.Time, error) {
+	filter := &HistoryFilter{}
+	var from, to time.Time
+	if len(args) < 2 || args[1] == "" {
+		return filter, from, to, nil
+	}
+
+	err := decodeInput([]byte(args[1]), filter)
+	if err != nil {
+		return nil, from, to, err
+	}
+	if filter.From != "" {
+		if from, err = time.Parse(time.RFC3339, filter.From); err != nil {
+			return nil, from, to, &FieldError{"from", "must be an RFC3339 timestamp, got " + filter.From}
+		}
+	}
+	if filter.To != "" {
+		if to, err = time.Parse(time.RFC3339, filter.To); err != nil {
+			return nil, from, to, &FieldError{"to", "must be an RFC3339 timestamp, got " + filter.To}
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return nil, from, to, &FieldError{"to", "must be after from"}
+	}
+	return filter, from, to, nil
+}
+
+// diffRecords lists the top-level fields that differ between two JSON
+// documents, in field order. A nil document has no fields.
+func diffRecords(previous []byte, current []byte) ([]FieldChange, error) {
+	before := map[string]json.RawMessage{}
+	after := map[string]json.RawMessage{}
+	if previous != nil {
+		if err := json.Unmarshal(previous, &before); err != nil {
+			return nil, err
+		}
+	}
+	if current != nil {
+		if err := json.Unmarshal(current, &after); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := []string{}
+	for field := range before {
+		fields = append(fields, field)
+	}
+	for field := range after {
+		if _, ok := before[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := []FieldChange{}
+	for _, field := range fields {
+		from, to := before[field], after[field]
+		if string(from) == string(to) {
+			continue
+		}
+		// a missing field is reported as null
+		if from == nil {
+			from = json.RawMessage("null")
+		}
+		if to == nil {
+			to = json.RawMessage("null")
+		}
+		changes = append(changes, FieldChange{field, from, to})
+	}
+	return changes, nil
+}
+
+// recordHistory returns the typed versions of an Agreement or Model. decode
+// turns a stored value into its record, it is never called for deletions.
+func recordHistory(APIstub shim.ChaincodeStubInterface, key string, filter *HistoryFilter, from time.Time, to time.Time, decode func(value []byte) (interface{}, error)) ([]HistoryEntry, error) {
+	resultsIterator, err := APIstub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	history := []HistoryEntry{}
+	var previous []byte
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := HistoryEntry{TxID: modification.TxId, IsDelete: modification.IsDelete}
+		var timestamp time.Time
+		if modification.Timestamp != nil {
+			timestamp = time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC()
+			entry.Timestamp = timestamp.Format(time.RFC3339Nano)
+		}
+
+		// the diff compares decoded records, so legacy shapes do not show up as changes
+		var current []byte
+		if !modification.IsDelete {
+			entry.Record, err = decode(modification.Value)
+			if _, ok := err.(*ChaincodeError); ok {
+				return nil, argumentError(key + " is " + err.Error())
+			} else if err != nil {
+				return nil, fmt.Errorf("Invalid version %s of %s: %s", modification.TxId, key, err.Error())
+			}
+			current, err = json.Marshal(entry.Record)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if filter.Diffs {
+			entry.Changes, err = diffRecords(previous, current)
+			if err != nil {
+				return nil, err
+			}
+		}
+		previous = current
+
+		if filter.TxID != "" && entry.TxID != filter.TxID {
+			continue
+		}
+		if (!from.IsZero() && timestamp.Before(from)) || (!to.IsZero() && !timestamp.Before(to)) {
+			continue
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+// historyResponse builds the response of a history query
+func historyResponse(history []HistoryEntry, err error) peer.Response {
+	if err != nil {
+		return errorResponse(err)
+	}
+	historyAsBytes, err := json.Marshal(history)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(historyAsBytes)
+}
+
+// decodeAgreementVersion decodes a stored version of an Agreement
+func decodeAgreementVersion(value []byte) (interface{}, error) {
+	Agreement := &Agreement{}
+	err := json.Unmarshal(value, Agreement)
+	if err != nil {
+		return nil, err
+	}
+	if Agreement.ObjectType != "Agreement" {
+		return nil, argumentError("not an Agreement")
+	}
+	return Agreement, nil
+}
+
+// decodeModelVersion decodes a stored version of a Model in its current shape
+func decodeModelVersion(key string) func(value []byte) (interface{}, error) {
+	return func(value []byte) (interface{}, error) {
+		Model := &Model{}
+		err := json.Unmarshal(value, Model)
+		if err != nil {
+			return nil, err
+		}
+		if Model.ObjectType != "model" {
+			return nil, argumentError("not a Model")
+		}
+		upgradeModel(Model, key)
+		return Model, nil
+	}
+}
+
+// ===========================================================================
+// queryAgreementHistory - every version of an Agreement, oldest first
+//
+// args[0] AgreementID
+// args[1] optional JSON filter {"from","to","tx_id","diffs"}
+//
+// from and to are RFC3339 timestamps, diffs adds the fields every version
+// changed.
+// ===========================================================================
+func (t *MAGNIT_CC) queryAgreementHistory(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting AgreementID and an optional filter"))
+	}
+	filter, from, to, err := readHistoryFilter(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return historyResponse(recordHistory(APIstub, args[0], filter, from, to, decodeAgreementVersion))
+}
+
+// ===========================================================================
+// queryModelHistory - every version of a Model, oldest first
+//
+// args[0] model_id
+// args[1] optional JSON filter, see queryAgreementHistory
+// ===========================================================================
+func (t *MAGNIT_CC) queryModelHistory(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting model_id and an optional filter"))
+	}
+	filter, from, to, err := readHistoryFilter(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return historyResponse(recordHistory(APIstub, args[0], filter, from, to, decodeModelVersion(args[0])))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// historyEntries decodes a history response, keeping the records as raw JSON
+func historyEntries(t *testing.T, payload []byte) []map[string]json.RawMessage {
+	t.Helper()
+	entries := []map[string]json.RawMessage{}
+	if err := json.Unmarshal(payload, &entries); err != nil {
+		t.Fatalf("Invalid history %s: %s", payload, err)
+	}
+	return entries
+}
+
+func TestAgreementHistory(t *testing.T) {
+	stub := setupActiveAgreement(t).as(t, "Org2MSP", nil)
+
+	res := stub.invoke("q1", "queryAgreementHistory", "Agreement-tx2")
+	expectOK(t, res)
+	history := []HistoryEntry{}
+	json.Unmarshal(res.Payload, &history)
+	if len(history) != 3 || history[0].TxID != "tx2" || history[1].TxID != "approve" || history[2].TxID != "activate" {
+		t.Fatalf("Unexpected history %s", res.Payload)
+	}
+	for _, entry := range history {
+		if _, err := time.Parse(time.RFC3339Nano, entry.Timestamp); err != nil || entry.IsDelete || entry.Changes != nil {
+			t.Fatalf("Unexpected entry %+v", entry)
+		}
+	}
+	if entries := historyEntries(t, res.Payload); string(entries[0]["is_delete"]) != "false" {
+		t.Fatalf("Expected is_delete to be a boolean: %s", entries[0]["is_delete"])
+	}
+
+	// the diff of the approval is the status change
+	res = stub.invoke("q2", "queryAgreementHistory", "Agreement-tx2", `{"tx_id":"approve","diffs":true}`)
+	expectOK(t, res)
+	history = []HistoryEntry{}
+	json.Unmarshal(res.Payload, &history)
+	if len(history) != 1 || history[0].TxID != "approve" {
+		t.Fatalf("Expected the approval only: %s", res.Payload)
+	}
+	status := false
+	for _, change := range history[0].Changes {
+		if change.Field == "Agreement_status" {
+			status = string(change.From) == `"proposed"` && string(change.To) == `"approved"`
+		}
+	}
+	if !status {
+		t.Fatalf("Expected the status change in %s", res.Payload)
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	if res = stub.invoke("q3", "queryAgreementHistory", "Agreement-tx2", `{"from":"`+future+`"}`); len(historyEntries(t, res.Payload)) != 0 {
+		t.Fatalf("Expected no versions after %s: %s", future, res.Payload)
+	}
+	if res = stub.invoke("q4", "queryAgreementHistory", "Agreement-tx2", `{"from":"`+past+`","to":"`+future+`"}`); len(historyEntries(t, res.Payload)) != 3 {
+		t.Fatalf("Expected every version within the last hour: %s", res.Payload)
+	}
+
+	expectFieldError(t, stub.invoke("q5", "queryAgreementHistory", "Agreement-tx2", `{"from":"yesterday"}`), "from")
+	expectFieldError(t, stub.invoke("q6", "queryAgreementHistory", "Agreement-tx2", `{"from":"`+future+`","to":"`+past+`"}`), "to")
+	expectFieldError(t, stub.invoke("q7", "queryAgreementHistory", "Agreement-tx2", `{"since":"`+past+`"}`), "since")
+	expectFailure(t, stub.invoke("q8", "queryAgreementHistory", "Model-tx1"))
+	expectDenied(t, stub.as(t, "Org3MSP", nil).invoke("q9", "queryAgreementHistory", "Agreement-tx2"))
+}
+
+func TestModelHistory(t *testing.T) {
+	stub := setupAgreement(t)
+	expectOK(t, stub.invoke("tx3", "publishModelVersion", "Model-tx1", "2.0", digest("bb"), "s3://resnet/2.0"))
+
+	res := stub.as(t, "Org3MSP", nil).invoke("q1", "queryModelHistory", "Model-tx1", `{"diffs":true}`)
+	expectOK(t, res)
+	history := []struct {
+		TxID    string        `json:"tx_id"`
+		Record  Model         `json:"record"`
+		Changes []FieldChange `json:"changes"`
+	}{}
+	json.Unmarshal(res.Payload, &history)
+	if len(history) != 2 || history[0].Record.Model_id != "Model-tx1" || history[1].Record.Model_latest_version != "2.0" {
+		t.Fatalf("Unexpected model history %s", res.Payload)
+	}
+	// the first version is diffed against nothing
+	if len(history[0].Changes) == 0 || string(history[0].Changes[0].From) != "null" {
+		t.Fatalf("Expected the first version to add every field: %+v", history[0].Changes)
+	}
+	expectFailure(t, stub.invoke("q2", "queryModelHistory", "Agreement-tx2"))
+}
+
+func TestGetHistoryForRecord(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", adminAttrs)
+
+	res := stub.invoke("q1", "getHistoryForRecord", "Agreement-tx2")
+	expectOK(t, res)
+	if history := historyEntries(t, res.Payload); len(history) != 1 || string(history[0]["tx_id"]) != `"tx2"` {
+		t.Fatalf("Unexpected history %s", res.Payload)
+	}
+	if res = stub.invoke("q2", "getHistoryForRecord", "Model-tx1"); len(historyEntries(t, res.Payload)) != 1 {
+		t.Fatalf("Unexpected model history %s", res.Payload)
+	}
+	expectFailure(t, stub.invoke("q3", "getHistoryForRecord", "Missing"))
+
+	// a key deleted from the world state keeps its history
+	stub.MockTransactionStart("purge")
+	stub.DelState("Agreement-tx2")
+	stub.MockTransactionEnd("purge")
+	res = stub.invoke("q4", "getHistoryForRecord", "Agreement-tx2")
+	expectOK(t, res)
+	if history := historyEntries(t, res.Payload); len(history) != 2 || string(history[1]["is_delete"]) != "true" {
+		t.Fatalf("Unexpected history of the deleted agreement %s", res.Payload)
+	}
+}
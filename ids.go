@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Asset ID prefixes. Records created before IDs were derived from the
+// transaction ID use the prefix followed by a sequence number, e.g. Model1,
+// and stay readable under those keys.
+const (
+	modelIDPrefix     = "Model"
+	agreementIDPrefix = "Agreement"
+	amendmentIDPrefix = "Amendment"
+)
+
+// legacyCounterKeys are the global counters the sequential IDs were drawn from
+var legacyCounterKeys = []string{"AgreementCounterNO", "ModelCounterNO"}
+
+// ===========================================================================
+// newAssetID - derive the ID of an asset created by the current transaction
+//
+// The transaction ID is unique on the channel, so concurrent creates in the
+// same block never read or write a shared key. A transaction creates at most
+// one asset per prefix.
+// ===========================================================================
+func newAssetID(APIstub shim.ChaincodeStubInterface, prefix string) (string, error) {
+	txID := APIstub.GetTxID()
+	if txID == "" {
+		return "", errors.New("Transaction ID is empty")
+	}
+
+	assetID := prefix + "-" + txID
+
+	// a collision means the transaction already created an asset of this type
+	valAsbytes, err := APIstub.GetState(assetID)
+	if err != nil {
+		return "", err
+	} else if valAsbytes != nil {
+		return "", newError(codeAlreadyExists, "Asset already exists: "+assetID)
+	}
+	return assetID, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// rwsetStub endorses a transaction against a fixed snapshot of the world
+// state and records its read and write sets, the way a peer does before the
+// transactions of a block are validated.
+type rwsetStub struct {
+	*callerStub
+	reads  map[string]bool
+	ranges [][2]string // [start, end) of the range reads
+	writes map[string][]byte
+}
+
+func (s *rwsetStub) GetState(key string) ([]byte, error) {
+	s.reads[key] = true
+	if value, ok := s.writes[key]; ok {
+		return value, nil
+	}
+	return s.callerStub.GetState(key)
+}
+
+// GetStateByRange records the range, a key written into it by an earlier
+// transaction of the block is a phantom read
+func (s *rwsetStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	s.ranges = append(s.ranges, [2]string{startKey, endKey})
+	return s.callerStub.GetStateByRange(startKey, endKey)
+}
+
+func (s *rwsetStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	s.ranges = append(s.ranges, [2]string{startKey, startKey + string(utf8.MaxRune)})
+	return s.callerStub.GetStateByPartialCompositeKey(objectType, attributes)
+}
+
+func (s *rwsetStub) PutState(key string, value []byte) error {
+	if s.paginated {
+		return errPaginatedWrite
+	}
+	s.writes[key] = value
+	return nil
+}
+
+// endorse simulates one transaction without touching the snapshot
+func (s *callerStub) endorse(txID string, args ...string) (*rwsetStub, peer.Response) {
+	rwset := &rwsetStub{callerStub: s, reads: map[string]bool{}, writes: map[string][]byte{}}
+
+	s.args = make([][]byte, 0, len(args))
+	for _, arg := range args {
+		s.args = append(s.args, []byte(arg))
+	}
+	s.MockTransactionStart(txID)
+	response := s.cc.Invoke(rwset)
+	s.MockTransactionEnd(txID)
+	s.paginated = false
+	return rwset, response
+}
+
+// commitBlock applies MVCC validation in block order and returns the number
+// of valid transactions. A transaction is invalidated when it read a key, or
+// a range containing a key, that an earlier valid transaction of the same
+// block wrote.
+func commitBlock(stub *callerStub, block []*rwsetStub) int {
+	written := map[string]bool{}
+	valid := 0
+
+	stub.MockTransactionStart("commit")
+	for _, tx := range block {
+		conflict := false
+		for key := range tx.reads {
+			if written[key] {
+				conflict = true
+				break
+			}
+		}
+		for _, keyRange := range tx.ranges {
+			for key := range written {
+				if key >= keyRange[0] && (keyRange[1] == "" || key < keyRange[1]) {
+					conflict = true
+				}
+			}
+		}
+		if conflict {
+			continue
+		}
+		for key, value := range tx.writes {
+			stub.PutState(key, value)
+			written[key] = true
+		}
+		valid++
+	}
+	stub.MockTransactionEnd("commit")
+	return valid
+}
+
+func TestConcurrentInsertsInOneBlock(t *testing.T) {
+	stub := setupAgreement(t)
+	stub.as(t, "Org1MSP", nil)
+
+	const blockSize = 50
+
+	models := []*rwsetStub{}
+	agreements := []*rwsetStub{}
+	for i := 0; i < blockSize; i++ {
+		rwset, res := stub.endorse(fmt.Sprintf("model%d", i), "initmodel", "Model", "Org1MSP")
+		expectOK(t, res)
+		models = append(models, rwset)
+
+		rwset, res = stub.endorse(fmt.Sprintf("agreement%d", i), "insertAgreementinfo", "Agreement", "Model-tx1", "10", "Org1MSP", "Org2MSP", "", "", "", docHash)
+		expectOK(t, res)
+		agreements = append(agreements, rwset)
+	}
+
+	if valid := commitBlock(stub, append(models, agreements...)); valid != 2*blockSize {
+		t.Fatalf("Expected all %d inserts to commit, %d did", 2*blockSize, valid)
+	}
+
+	for i := 0; i < blockSize; i++ {
+		if stub.State[fmt.Sprintf("Model-model%d", i)] == nil || stub.State[fmt.Sprintf("Agreement-agreement%d", i)] == nil {
+			t.Fatalf("Insert %d is missing from the world state", i)
+		}
+	}
+}
+
+func TestConflictingUpdatesInOneBlock(t *testing.T) {
+	stub := setupAgreement(t)
+
+	// two approvals of the same agreement must not both commit,
+	// otherwise the simulation would prove nothing
+	first, res := stub.as(t, "Org2MSP", nil).endorse("approve1", "approveAgreement", "Agreement-tx2")
+	expectOK(t, res)
+	second, res := stub.endorse("approve2", "approveAgreement", "Agreement-tx2")
+	expectOK(t, res)
+
+	if valid := commitBlock(stub, []*rwsetStub{first, second}); valid != 1 {
+		t.Fatalf("Expected 1 of 2 conflicting updates to commit, %d did", valid)
+	}
+}
+
+func BenchmarkInsertAgreementinfo(b *testing.B) {
+	stub := setupAgreement(b).as(b, "Org1MSP", nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res := stub.invoke(fmt.Sprintf("bench%d", i), "insertAgreementinfo", "Agreement", "Model-tx1", "10", "Org1MSP", "Org2MSP", "", "", "", docHash)
+		if res.Status != shim.OK {
+			b.Fatalf("insertAgreementinfo failed: %s", res.Message)
+		}
+	}
+}
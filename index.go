@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/imineev/cc1/events"
+)
+
+// Composite-key secondary indexes. They work on LevelDB and CouchDB alike,
+// unlike rich queries which LevelDB peers cannot serve.
+const (
+	issuerIndex      = "issuer~AgreementID"
+	participantIndex = "participant~AgreementID"
+	modelIndex       = "model~AgreementID"
+	statusIndex      = "status~AgreementID"
+	orgModelIndex    = "org~modelID"
+)
+
+// indexValue is stored under every index key, the key itself carries the data
+var indexValue = []byte{0x00}
+
+// indexesBuiltObjectType keys the marker that every Agreement and Model is
+// indexed. Init sets it on an empty ledger and rebuildIndexes on the page
+// that ends the range, until then lookups that must be complete scan.
+const indexesBuiltObjectType = "IndexesBuilt"
+
+// indexesBuilt reports whether the indexes hold every Agreement and Model
+func indexesBuilt(APIstub shim.ChaincodeStubInterface) (bool, error) {
+	markerKey, err := APIstub.CreateCompositeKey(indexesBuiltObjectType, []string{})
+	if err != nil {
+		return false, err
+	}
+	markerAsBytes, err := APIstub.GetState(markerKey)
+	return markerAsBytes != nil, err
+}
+
+// markIndexesBuilt records that the indexes hold every Agreement and Model
+func markIndexesBuilt(APIstub shim.ChaincodeStubInterface) error {
+	markerKey, err := APIstub.CreateCompositeKey(indexesBuiltObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(markerKey, indexValue)
+}
+
+// markIndexesOfEmptyLedger sets the marker when no Agreement or Model is stored yet
+func markIndexesOfEmptyLedger(APIstub shim.ChaincodeStubInterface) error {
+	resultsIterator, err := APIstub.GetStateByRange(agreementIDPrefix, modelIDPrefix+"~")
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+	if resultsIterator.HasNext() {
+		return nil
+	}
+	return markIndexesBuilt(APIstub)
+}
+
+// indexEntry is one index key as index name and attributes
+type indexEntry struct {
+	Name       string
+	Attributes []string
+}
+
+// agreementIndexEntries lists the index keys of an Agreement. Every party
+// other than the issuer finds the Agreement in the participant index.
+func agreementIndexEntries(Agreement *Agreement) []indexEntry {
+	entries := []indexEntry{
+		{issuerIndex, []string{Agreement.Agreement_issuer, Agreement.AgreementID}},
+		{modelIndex, []string{Agreement.Agreement_model_id, Agreement.AgreementID}},
+		{statusIndex, []string{Agreement.Agreement_status, Agreement.AgreementID}},
+	}
+	for _, party := range agreementParties(Agreement) {
+		if party.Role != partyIssuer {
+			entries = append(entries, indexEntry{participantIndex, []string{party.MSPID, Agreement.AgreementID}})
+		}
+	}
+	return entries
+}
+
+// modelIndexEntries lists the index keys of a Model
+func modelIndexEntries(Model *Model) []indexEntry {
+	return []indexEntry{
+		{orgModelIndex, []string{Model.Upload_org, Model.Model_id}},
+	}
+}
+
+// updateIndexes moves index keys from the old entries to the new ones.
+// A nil old list adds every entry, a nil new list removes every entry.
+func updateIndexes(APIstub shim.ChaincodeStubInterface, old []indexEntry, new []indexEntry) error {
+	oldKeys, err := indexKeys(APIstub, old)
+	if err != nil {
+		return err
+	}
+	newKeys, err := indexKeys(APIstub, new)
+	if err != nil {
+		return err
+	}
+
+	for _, indexKey := range oldKeys {
+		if containsKey(newKeys, indexKey) {
+			continue
+		}
+		err = APIstub.DelState(indexKey)
+		if err != nil {
+			return err
+		}
+	}
+	for _, indexKey := range newKeys {
+		if containsKey(oldKeys, indexKey) {
+			continue
+		}
+		err = APIstub.PutState(indexKey, indexValue)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexKeys builds the composite keys of index entries
+func indexKeys(APIstub shim.ChaincodeStubInterface, entries []indexEntry) ([]string, error) {
+	keys := []string{}
+	for _, entry := range entries {
+		indexKey, err := APIstub.CreateCompositeKey(entry.Name, entry.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, indexKey)
+	}
+	return keys, nil
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// indexAgreement updates the indexes of an Agreement, old is nil for a new Agreement
+func indexAgreement(APIstub shim.ChaincodeStubInterface, old *Agreement, new *Agreement) error {
+	var oldEntries, newEntries []indexEntry
+	if old != nil {
+		oldEntries = agreementIndexEntries(old)
+	}
+	if new != nil {
+		newEntries = agreementIndexEntries(new)
+	}
+	return updateIndexes(APIstub, oldEntries, newEntries)
+}
+
+// indexModel updates the indexes of a Model, old is nil for a new Model
+func indexModel(APIstub shim.ChaincodeStubInterface, old *Model, new *Model) error {
+	var oldEntries, newEntries []indexEntry
+	if old != nil {
+		oldEntries = modelIndexEntries(old)
+	}
+	if new != nil {
+		newEntries = modelIndexEntries(new)
+	}
+	return updateIndexes(APIstub, oldEntries, newEntries)
+}
+
+// queryByIndex returns the records an index points to for the leading attribute value
+func queryByIndex(APIstub shim.ChaincodeStubInterface, indexName string, value string) ([]byte, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(indexName, []string{value})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	// buffer is a JSON array containing the indexed records
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		valAsbytes, err := APIstub.GetState(attributes[len(attributes)-1])
+		if err != nil {
+			return nil, err
+		} else if valAsbytes == nil {
+			// the record is gone, skip the stale index entry
+			continue
+		}
+
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString(string(valAsbytes))
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	return buffer.Bytes(), nil
+}
+
+// ===========================================================================
+// queryAgreementsByIssuer - Agreements issued by an organization
+// ===========================================================================
+func (t *MAGNIT_CC) queryAgreementsByIssuer(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	return t.queryIndex(APIstub, issuerIndex, args)
+}
+
+// ===========================================================================
+// queryAgreementsByParticipant - Agreements an organization participates in
+// ===========================================================================
+func (t *MAGNIT_CC) queryAgreementsByParticipant(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	return t.queryIndex(APIstub, participantIndex, args)
+}
+
+// ===========================================================================
+// queryAgreementsByModel - Agreements bound to a model
+// ===========================================================================
+func (t *MAGNIT_CC) queryAgreementsByModel(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	return t.queryIndex(APIstub, modelIndex, args)
+}
+
+// ===========================================================================
+// queryModelsByOrg - Models uploaded by an organization
+// ===========================================================================
+func (t *MAGNIT_CC) queryModelsByOrg(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	return t.queryIndex(APIstub, orgModelIndex, args)
+}
+
+func (t *MAGNIT_CC) queryIndex(APIstub shim.ChaincodeStubInterface, indexName string, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting 1"))
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(argumentError("Query argument must be a non-empty string"))
+	}
+
+	queryResults, err := queryByIndex(APIstub, indexName, args[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// ===========================================================================
+// rebuildIndexes - create the index keys of Agreements and Models stored
+// before the indexes existed
+//
+// pageSize - optional, records read per call, defaults to 100
+// bookmark - optional, the next key returned by the previous call
+// Deleted records are not indexed, stale index keys of them are removed.
+// The page ending the range marks the indexes built, see indexesBuilt.
+// The range is read without pagination, Fabric refuses writes after a
+// paginated query, so the page ends at the next key instead.
+// ===========================================================================
+func (t *MAGNIT_CC) rebuildIndexes(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) > 2 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting pageSize, bookmark"))
+	}
+	fields := make([]string, 2)
+	copy(fields, args)
+
+	pageSize, err := parsePageSize(fields[0])
+	if err != nil {
+		return errorResponse(err)
+	}
+	// Agreement and Model keys share one range, other records in it are skipped
+	startKey, endKey := agreementIDPrefix, modelIDPrefix+"~"
+	if fields[1] != "" {
+		if fields[1] < startKey || fields[1] >= endKey {
+			return errorResponse(&FieldError{"bookmark", "must be a key returned as next"})
+		}
+		startKey = fields[1]
+	}
+
+	resultsIterator, err := APIstub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	read := 0
+	next := ""
+	indexed := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		if read == int(pageSize) {
+			next = queryResponse.Key
+			break
+		}
+		read++
+
+		record := struct {
+			ObjectType string `json:"docType"`
+		}{}
+		if json.Unmarshal(queryResponse.Value, &record) != nil {
+			continue
+		}
+
+		switch record.ObjectType {
+		case "Agreement":
+			Agreement := &Agreement{}
+			if json.Unmarshal(queryResponse.Value, Agreement) != nil {
+				continue
+			}
+			if Agreement.Agreement_tombstone != nil {
+				err = indexAgreement(APIstub, Agreement, nil)
+			} else {
+				err = indexAgreement(APIstub, nil, Agreement)
+				indexed++
+			}
+		case "model":
+			Model := &Model{}
+			if json.Unmarshal(queryResponse.Value, Model) != nil {
+				continue
+			}
+			upgradeModel(Model, queryResponse.Key)
+			if Model.Model_tombstone != nil {
+				err = indexModel(APIstub, Model, nil)
+			} else {
+				err = indexModel(APIstub, nil, Model)
+				indexed++
+			}
+		}
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	if next == "" {
+		err = markIndexesBuilt(APIstub)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	err = emitEvent(APIstub, &events.Event{Type: events.IndexesRebuilt}, &events.MaintenanceDetails{Records: indexed, Next: next})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Printf("- rebuildIndexes indexed %d records\n", indexed)
+
+	result := struct {
+		Indexed int    `json:"indexed"`
+		Next    string `json:"next,omitempty"`
+	}{indexed, next}
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(resultAsBytes)
+}
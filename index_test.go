@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const indexDir = "META-INF/statedb/couchdb/indexes"
+
+// indexDefinition is the format of a packaged CouchDB index file
+type indexDefinition struct {
+	Index struct {
+		Fields []string `json:"fields"`
+	} `json:"index"`
+	DesignDoc string `json:"ddoc"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+}
+
+func loadIndexes(t *testing.T) map[string]indexDefinition {
+	files, err := filepath.Glob(filepath.Join(indexDir, "*.json"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No index definitions found in %s", indexDir)
+	}
+
+	indexes := map[string]indexDefinition{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := indexDefinition{}
+		if err := json.Unmarshal(content, &index); err != nil {
+			t.Fatalf("Invalid index definition %s: %v", file, err)
+		}
+		if index.Type != "json" || len(index.Index.Fields) == 0 {
+			t.Fatalf("Index definition %s must be a json index with fields", file)
+		}
+		indexes["_design/"+index.DesignDoc+"/"+index.Name] = index
+	}
+	return indexes
+}
+
+func TestIndexDefinitionsMatchCode(t *testing.T) {
+	indexes := loadIndexes(t)
+
+	declared := append([]couchIndex{indexAgreementDocType}, agreementSelectorIndexes...)
+	for _, index := range agreementSortIndexes {
+		declared = append(declared, index)
+	}
+	for _, index := range declared {
+		definition, ok := indexes["_design/"+index.DesignDoc+"/"+index.Name]
+		if !ok {
+			t.Fatalf("Index %s is not packaged", index.Name)
+		}
+		if len(definition.Index.Fields) != len(index.Fields) {
+			t.Fatalf("Index %s fields differ from the packaged definition", index.Name)
+		}
+		for i := range index.Fields {
+			if definition.Index.Fields[i] != index.Fields[i] {
+				t.Fatalf("Index %s fields differ from the packaged definition", index.Name)
+			}
+		}
+	}
+}
+
+func TestQueriesAreCoveredByIndexes(t *testing.T) {
+	indexes := loadIndexes(t)
+	stub := setupAgreement(t)
+
+	// every shape of query the chaincode issues
+	expectOK(t, stub.invoke("q1", "queryAllAgreements"))
+	selectors := []string{
+		`{}`,
+		`{"Agreement_issuer":"Org1MSP"}`,
+		`{"Agreement_participant":"Org2MSP"}`,
+		`{"Agreement_model_id":"Model-tx1"}`,
+		`{"Agreement_status":"proposed"}`,
+		`{"Agreement_status":"proposed","Agreement_issuer":"Org1MSP"}`,
+		`{"$or":[{"Agreement_status":"active"},{"Agreement_status":"suspended"}]}`,
+	}
+	for _, selector := range selectors {
+		expectOK(t, stub.invoke("q", "queryAgreements", selector))
+	}
+	for field := range agreementSortIndexes {
+		expectOK(t, stub.invoke("q", "queryAgreements", `{}`, `[{"`+field+`":"desc"}]`))
+	}
+
+	if len(stub.queries) != 1+len(selectors)+len(agreementSortIndexes) {
+		t.Fatalf("Expected every query to reach the state database, got %d", len(stub.queries))
+	}
+	for _, query := range stub.queries {
+		parsed := struct {
+			Selector map[string]interface{} `json:"selector"`
+			Sort     []map[string]string    `json:"sort"`
+			UseIndex []string               `json:"use_index"`
+		}{}
+		if err := json.Unmarshal([]byte(query), &parsed); err != nil {
+			t.Fatalf("Invalid query %s: %v", query, err)
+		}
+		if len(parsed.UseIndex) != 2 {
+			t.Fatalf("Query does not name an index: %s", query)
+		}
+		index, ok := indexes[parsed.UseIndex[0]+"/"+parsed.UseIndex[1]]
+		if !ok {
+			t.Fatalf("Query uses an undeclared index: %s", query)
+		}
+		// CouchDB only uses an index when the selector references all of its fields
+		for _, field := range index.Index.Fields {
+			if _, ok := parsed.Selector[field]; !ok {
+				t.Fatalf("Index %s does not cover query %s", index.Name, query)
+			}
+		}
+		for i, sort := range parsed.Sort {
+			if _, ok := sort[index.Index.Fields[i]]; !ok {
+				t.Fatalf("Index %s does not serve the sort of %s", index.Name, query)
+			}
+		}
+	}
+}
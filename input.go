@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// inputTransientKey is the transient map entry carrying the JSON input of a function.
+// Unlike arguments, transient data is not recorded in the transaction.
+const inputTransientKey = "input"
+
+// FieldError reports an input field that failed validation
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (e *FieldError) Error() string {
+	return "Invalid field " + e.Field + ": " + e.Reason
+}
+
+// readInput decodes the JSON input document of a function into input. The
+// document is taken from the transient map, or from the only argument when
+// that is a JSON object. It returns false for the positional form.
+func readInput(APIstub shim.ChaincodeStubInterface, args []string, input interface{}) (bool, error) {
+	transientMap, err := APIstub.GetTransient()
+	if err != nil {
+		return false, err
+	}
+
+	document, ok := transientMap[inputTransientKey]
+	if ok {
+		if len(args) != 0 {
+			return false, argumentError("Arguments must be empty when the input is passed in the transient map")
+		}
+	} else if len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "{") {
+		document = []byte(args[0])
+	} else {
+		return false, nil
+	}
+
+	err = decodeInput(document, input)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// decodeInput strictly decodes a JSON input document, unknown and mistyped
+// fields are reported as a FieldError
+func decodeInput(document []byte, input interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(input)
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		return &FieldError{typeErr.Field, "must be a " + typeErr.Type.String()}
+	} else if err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return &FieldError{strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), "\""), "is not a known field"}
+	} else if err != nil {
+		return argumentError("Input must be a JSON object: " + err.Error())
+	}
+	if decoder.More() {
+		return argumentError("Input must be a single JSON object")
+	}
+	return nil
+}
+
+// requireField fails when a mandatory field is empty
+func requireField(field string, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return &FieldError{field, "must be a non-empty string"}
+	}
+	return nil
+}
+
+// ModelInput is the input of initmodel
+type ModelInput struct {
+	Model_name          string   `json:"model_name"`
+	Upload_org          string   `json:"upload_org"`
+	Model_version       string   `json:"model_version"`
+	Model_description   string   `json:"model_description"`
+	Model_framework     string   `json:"model_framework"`
+	Model_artifact_hash string   `json:"model_artifact_hash"`
+	Model_artifact_uri  string   `json:"model_artifact_uri"`
+	Model_license       string   `json:"model_license"`
+	Model_tags          []string `json:"model_tags"`
+}
+
+// readModelInput reads the input of initmodel from a JSON document, or from
+// the deprecated positional form of 2 or 9 arguments
+func readModelInput(APIstub shim.ChaincodeStubInterface, args []string) (*ModelInput, error) {
+	input := &ModelInput{}
+	isJSON, err := readInput(APIstub, args, input)
+	if err != nil {
+		return nil, err
+	}
+	if !isJSON {
+		if len(args) != 2 && len(args) != 9 {
+			return nil, argumentError("Incorrect number of arguments. Expecting a JSON model or 2 or 9 arguments")
+		}
+		// pad the legacy form so the metadata fields read as empty
+		fields := make([]string, 9)
+		copy(fields, args)
+		input = &ModelInput{fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6], fields[7], parseTags(fields[8])}
+	}
+	if input.Model_tags == nil {
+		input.Model_tags = []string{}
+	}
+
+	v := &validator{}
+	if v.required("model_name", input.Model_name) {
+		v.maxLength("model_name", input.Model_name, maxNameLength)
+	}
+	if v.required("upload_org", input.Upload_org) {
+		v.mspID("upload_org", input.Upload_org)
+	}
+	validVersion(v, "model_version", input.Model_version)
+	v.maxLength("model_description", input.Model_description, maxTextLength)
+	v.maxLength("model_framework", input.Model_framework, maxNameLength)
+	v.hash("model_artifact_hash", &input.Model_artifact_hash)
+	v.url("model_artifact_uri", input.Model_artifact_uri)
+	v.maxLength("model_license", input.Model_license, maxNameLength)
+	v.tags("model_tags", input.Model_tags)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+// validVersion checks an optional model version, latest is reserved for the
+// newest published version
+func validVersion(v *validator, field string, version string) {
+	if version == latestVersion {
+		v.fail(field, "must not be "+latestVersion)
+	} else {
+		v.maxLength(field, version, maxVersionLength)
+	}
+}
+
+// ModelVersionInput is the input of publishModelVersion
+type ModelVersionInput struct {
+	Model_id            string `json:"model_id"`
+	Model_version       string `json:"model_version"`
+	Model_artifact_hash string `json:"model_artifact_hash"`
+	Model_artifact_uri  string `json:"model_artifact_uri"`
+	Model_description   string `json:"model_description"`
+}
+
+// readModelVersionInput reads the input of publishModelVersion from a JSON
+// document, or from the deprecated positional form of 4 or 5 arguments
+func readModelVersionInput(APIstub shim.ChaincodeStubInterface, args []string) (*ModelVersionInput, error) {
+	input := &ModelVersionInput{}
+	isJSON, err := readInput(APIstub, args, input)
+	if err != nil {
+		return nil, err
+	}
+	if !isJSON {
+		if len(args) != 4 && len(args) != 5 {
+			return nil, argumentError("Incorrect number of arguments. Expecting a JSON model version or 4 or 5 arguments")
+		}
+		fields := make([]string, 5)
+		copy(fields, args)
+		input = &ModelVersionInput{fields[0], fields[1], fields[2], fields[3], fields[4]}
+	}
+
+	v := &validator{}
+	if v.required("model_id", input.Model_id) {
+		v.assetID("model_id", input.Model_id, modelIDPrefix)
+	}
+	if v.required("model_version", input.Model_version) {
+		validVersion(v, "model_version", input.Model_version)
+	}
+	if v.required("model_artifact_hash", input.Model_artifact_hash) {
+		v.hash("model_artifact_hash", &input.Model_artifact_hash)
+	}
+	v.url("model_artifact_uri", input.Model_artifact_uri)
+	v.maxLength("model_description", input.Model_description, maxTextLength)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+// AgreementInput is the input of insertAgreementinfo. Confidential terms are
+// never part of it, they are passed in the transient map under agreement_terms.
+type AgreementInput struct {
+	Agreement_name            string        `json:"Agreement_name"`
+	Agreement_model_id        string        `json:"Agreement_model_id"`
+	Agreement_model_count_use *Count        `json:"Agreement_model_account_use"`
+	Agreement_issuer          string        `json:"Agreement_issuer"`
+	Agreement_participant     string        `json:"Agreement_participant"`
+	Agreement_remark          string        `json:"Agreement_remark"`
+	Agreement_url_image       string        `json:"Agreement_url_image"`
+	Agreement_hash            string        `json:"Agreement_hash"`
+	Agreement_model_version   string        `json:"Agreement_model_version"`
+	Agreement_valid_from      string        `json:"Agreement_valid_from"`    // RFC3339, JSON input only
+	Agreement_valid_until     string        `json:"Agreement_valid_until"`   // RFC3339, JSON input only
+	Agreement_period_quotas   []PeriodQuota `json:"Agreement_period_quotas"` // JSON input only
+	// all parties of a multi-party Agreement, JSON input only
+	Agreement_parties             []Party `json:"Agreement_parties"`
+	Agreement_signature_threshold int     `json:"Agreement_signature_threshold"`
+}
+
+// readAgreementInput reads the input of insertAgreementinfo from a JSON
+// document, or from the deprecated positional form of 9 or 10 arguments
+func readAgreementInput(APIstub shim.ChaincodeStubInterface, args []string) (*AgreementInput, error) {
+	input := &AgreementInput{}
+	isJSON, err := readInput(APIstub, args, input)
+	if err != nil {
+		return nil, err
+	}
+	if !isJSON {
+		if len(args) != 9 && len(args) != 10 {
+			return nil, argumentError("Incorrect number of arguments. Expecting a JSON agreement or 9 or 10 arguments")
+		}
+		// the initial status is always proposed, the argument is kept for compatibility
+		if args[7] != "" && args[7] != StatusProposed {
+			return nil, &FieldError{"Agreement_status", "new agreements start as " + StatusProposed + ", got " + args[7]}
+		}
+		fields := make([]string, 10)
+		copy(fields, args)
+		var quota *Count
+		if fields[2] != "" {
+			value, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, &FieldError{"Agreement_model_account_use", fmt.Sprintf("must be an integer, got %q", fields[2])}
+			}
+			quota = (*Count)(&value)
+		}
+		input = &AgreementInput{fields[0], fields[1], quota, fields[3], fields[4], fields[5], fields[6], fields[8], fields[9], "", "", nil, nil, 0}
+	}
+	if input.Agreement_model_version == "" {
+		input.Agreement_model_version = latestVersion
+	}
+
+	// the first participant of a multi-party Agreement is its primary participant
+	for _, party := range input.Agreement_parties {
+		if input.Agreement_participant == "" && party.Role == partyParticipant {
+			input.Agreement_participant = party.MSPID
+		}
+	}
+
+	v := &validator{}
+	v.maxLength("Agreement_name", input.Agreement_name, maxNameLength)
+	if v.required("Agreement_model_id", input.Agreement_model_id) {
+		v.assetID("Agreement_model_id", input.Agreement_model_id, modelIDPrefix)
+	}
+	v.maxLength("Agreement_model_version", input.Agreement_model_version, maxVersionLength)
+	// an empty quota is only valid with private terms or period quotas, see insertAgreementinfo
+	if input.Agreement_model_count_use != nil {
+		v.check(validQuota("Agreement_model_account_use", *input.Agreement_model_count_use))
+	}
+	v.check(validPeriodQuotas("Agreement_period_quotas", input.Agreement_period_quotas))
+	parties := v.required("Agreement_issuer", input.Agreement_issuer) && v.mspID("Agreement_issuer", input.Agreement_issuer)
+	parties = v.required("Agreement_participant", input.Agreement_participant) && v.mspID("Agreement_participant", input.Agreement_participant) && parties
+	if parties && input.Agreement_issuer == input.Agreement_participant {
+		v.fail("Agreement_participant", "must differ from Agreement_issuer")
+	} else if parties {
+		v.check(validParties(input.Agreement_issuer, input.Agreement_participant, input.Agreement_parties, input.Agreement_signature_threshold))
+	}
+	for i, party := range input.Agreement_parties {
+		v.mspID(fmt.Sprintf("Agreement_parties[%d].msp_id", i), party.MSPID)
+	}
+	v.maxLength("Agreement_remark", input.Agreement_remark, maxTextLength)
+	v.url("Agreement_url_image", input.Agreement_url_image)
+	v.hash("Agreement_hash", &input.Agreement_hash)
+	if input.Agreement_valid_from, err = parseValidityTime("Agreement_valid_from", input.Agreement_valid_from); !v.check(err) {
+		input.Agreement_valid_from = ""
+	}
+	if input.Agreement_valid_until, err = parseValidityTime("Agreement_valid_until", input.Agreement_valid_until); !v.check(err) {
+		input.Agreement_valid_until = ""
+	}
+	if input.Agreement_valid_from != "" && input.Agreement_valid_until != "" && input.Agreement_valid_until <= input.Agreement_valid_from {
+		v.fail("Agreement_valid_until", "must be after Agreement_valid_from")
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
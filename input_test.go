@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// expectFieldError checks that res failed on the named input field
+func expectFieldError(t *testing.T, res peer.Response, field string) {
+	t.Helper()
+	expectFailure(t, res)
+	if chaincodeErr := expectError(t, res, codeInvalidField); chaincodeErr.Field != field {
+		t.Fatalf("Expected an error naming %s, got: %s", field, res.Message)
+	}
+}
+
+func TestJSONInputAsArgument(t *testing.T) {
+	stub := newCallerStub(t).as(t, "Org1MSP", nil)
+
+	res := stub.invoke("tx1", "initmodel", `{"model_name":"Resnet","upload_org":"Org1MSP","model_version":"1.0","model_artifact_hash":"`+digest("aa")+`","model_tags":["vision"]}`)
+	expectOK(t, res)
+	Model := &Model{}
+	json.Unmarshal(res.Payload, Model)
+	if Model.Model_name != "Resnet" || Model.Model_latest_version != "1.0" || len(Model.Model_tags) != 1 {
+		t.Fatalf("Unexpected model %s", res.Payload)
+	}
+
+	expectOK(t, stub.invoke("tx2", "publishModelVersion", `{"model_id":"Model-tx1","model_version":"2.0","model_artifact_hash":"`+digest("bb")+`"}`))
+
+	res = stub.invoke("tx3", "insertAgreementinfo", `{"Agreement_name":"Deal","Agreement_model_id":"Model-tx1","Agreement_model_account_use":10,"Agreement_issuer":"Org1MSP","Agreement_participant":"Org2MSP","Agreement_model_version":"1.0"}`)
+	expectOK(t, res)
+	Agreement := &Agreement{}
+	json.Unmarshal(res.Payload, Agreement)
+	if Agreement.Agreement_model_count_use != 10 || Agreement.Agreement_model_version != "1.0" || Agreement.Agreement_status != StatusProposed {
+		t.Fatalf("Unexpected agreement %s", res.Payload)
+	}
+}
+
+func TestJSONInputInTransientMap(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", nil)
+
+	input := `{"Agreement_model_id":"Model-tx1","Agreement_model_account_use":"5","Agreement_issuer":"Org1MSP","Agreement_participant":"Org2MSP"}`
+	expectOK(t, stub.withTransient(map[string][]byte{inputTransientKey: []byte(input)}).invoke("tx3", "insertAgreementinfo"))
+
+	// arguments next to a transient input are ambiguous
+	expectFailure(t, stub.withTransient(map[string][]byte{inputTransientKey: []byte(input)}).invoke("tx4", "insertAgreementinfo", input))
+
+	// the submitter's org is checked against the decoded input
+	input = `{"model_name":"Resnet","upload_org":"Org1MSP"}`
+	expectDenied(t, stub.as(t, "Org2MSP", nil).withTransient(map[string][]byte{inputTransientKey: []byte(input)}).invoke("tx5", "initmodel"))
+}
+
+func TestJSONInputValidation(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", nil)
+
+	expectFieldError(t, stub.invoke("tx3", "initmodel", `{"model_name":"","upload_org":"Org1MSP"}`), "model_name")
+	expectFieldError(t, stub.invoke("tx4", "initmodel", `{"model_name":"Resnet","upload_org":"Org1MSP","model_tags":"vision"}`), "model_tags")
+	expectFieldError(t, stub.invoke("tx5", "initmodel", `{"model_name":"Resnet","upload_org":"Org1MSP","modelVersion":"1.0"}`), "modelVersion")
+	expectFieldError(t, stub.invoke("tx6", "initmodel", `{"model_name":"Resnet","upload_org":"Org1MSP","model_version":"latest"}`), "model_version")
+	expectFieldError(t, stub.invoke("tx7", "publishModelVersion", `{"model_id":"Model-tx1","model_version":"2.0"}`), "model_artifact_hash")
+
+	agreement := func(fields string) string {
+		return `{"Agreement_model_id":"Model-tx1","Agreement_issuer":"Org1MSP",` + fields + `}`
+	}
+	expectFieldError(t, stub.invoke("tx8", "insertAgreementinfo", agreement(`"Agreement_participant":"Org2MSP","Agreement_model_account_use":-1`)), "Agreement_model_account_use")
+	expectFieldError(t, stub.invoke("tx9", "insertAgreementinfo", agreement(`"Agreement_participant":"Org2MSP"`)), "Agreement_model_account_use")
+	expectFieldError(t, stub.invoke("tx10", "insertAgreementinfo", agreement(`"Agreement_model_account_use":1`)), "Agreement_participant")
+	expectFieldError(t, stub.invoke("tx11", "insertAgreementinfo", agreement(`"Agreement_participant":"Org1MSP","Agreement_model_account_use":1`)), "Agreement_participant")
+	expectFieldError(t, stub.invoke("tx12", "insertAgreementinfo", "Agreement", "Model-tx1", "ten", "Org1MSP", "Org2MSP", "", "", "", docHash), "Agreement_model_account_use")
+
+	expectFailure(t, stub.invoke("tx13", "insertAgreementinfo", `{"Agreement_name":`))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/imineev/cc1/events"
+)
+
+// Agreement lifecycle statuses
+const (
+	StatusProposed   = "proposed"
+	StatusApproved   = "approved"
+	StatusRejected   = "rejected"
+	StatusActive     = "active"
+	StatusSuspended  = "suspended"
+	StatusExpired    = "expired"
+	StatusTerminated = "terminated"
+	StatusRevoked    = "revoked"
+)
+
+// Parties allowed to perform a transition
+const (
+	partyIssuer      = "issuer"
+	partyParticipant = "participant"
+	partyEither      = "issuer or participant"
+)
+
+// transition is one edge of the agreement state machine
+type transition struct {
+	From  []string
+	To    string
+	Party string
+}
+
+// agreementTransitions maps each lifecycle invoke function to its transition
+var agreementTransitions = map[string]transition{
+	"approveAgreement":   {From: []string{StatusProposed}, To: StatusApproved, Party: partyParticipant},
+	"rejectAgreement":    {From: []string{StatusProposed}, To: StatusRejected, Party: partyParticipant},
+	"activateAgreement":  {From: []string{StatusApproved}, To: StatusActive, Party: partyIssuer},
+	"suspendAgreement":   {From: []string{StatusActive}, To: StatusSuspended, Party: partyIssuer},
+	"resumeAgreement":    {From: []string{StatusSuspended}, To: StatusActive, Party: partyIssuer},
+	"expireAgreement":    {From: []string{StatusActive, StatusSuspended}, To: StatusExpired, Party: partyIssuer},
+	"terminateAgreement": {From: []string{StatusActive, StatusSuspended}, To: StatusTerminated, Party: partyEither},
+	"revokeAgreement":    {From: []string{StatusApproved, StatusActive, StatusSuspended}, To: StatusRevoked, Party: partyIssuer},
+}
+
+// allowedFrom reports whether the transition may start from status
+func (tr transition) allowedFrom(status string) bool {
+	for _, from := range tr.From {
+		if from == status {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedParty reports whether mspID is a party that may perform the transition
+func (tr transition) allowedParty(Agreement *Agreement, mspID string) bool {
+	switch tr.Party {
+	case partyIssuer:
+		return Agreement.Agreement_issuer == mspID
+	case partyParticipant:
+		return hasRole(Agreement, mspID, partyParticipant)
+	case partyEither:
+		return hasRole(Agreement, mspID, partyIssuer, partyParticipant)
+	}
+	return false
+}
+
+// ==========================================================================
+// transitionAgreement - move an Agreement along the lifecycle
+//
+// args[0] AgreementID
+// ==========================================================================
+func (t *MAGNIT_CC) transitionAgreement(APIstub shim.ChaincodeStubInterface, function string, args []string) peer.Response {
+
+	tr, ok := agreementTransitions[function]
+	if !ok {
+		return errorResponse(newError(codeUnknownFunction, "Unknown lifecycle function "+function))
+	}
+	if len(args) != 1 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting AgreementID"))
+	}
+	if len(args[0]) <= 0 {
+		return errorResponse(&FieldError{"AgreementID", "must be a non-empty string"})
+	}
+
+	AgreementID := args[0]
+
+	Agreement, err := readAgreement(APIstub, AgreementID)
+	if err != nil {
+		return errorResponse(err)
+	} else if Agreement == nil {
+		return errorResponse(notFoundError("Agreement not exist"))
+	}
+
+	if Agreement.Agreement_tombstone != nil {
+		return errorResponse(stateError("Agreement " + AgreementID + " is deleted"))
+	}
+	if !tr.allowedFrom(Agreement.Agreement_status) {
+		return errorResponse(stateError(fmt.Sprintf("Illegal transition of %s from %s to %s", AgreementID, Agreement.Agreement_status, tr.To)))
+	}
+
+	// agreements listing their parties are approved by signAgreement only
+	if len(Agreement.Agreement_parties) > 0 && (tr.To == StatusApproved || Agreement.Agreement_status == StatusApproved) {
+		return errorResponse(stateError("Agreement " + AgreementID + " is approved by the signatures of its parties, use signAgreement"))
+	}
+
+	// a deleted model cannot be put back into use
+	if tr.To == StatusActive {
+		if err := checkModelUsable(APIstub, Agreement); err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	update_time, errTx := t.GetTxTimestampChannel(APIstub)
+	if errTx != nil {
+		return errorResponse(errTx)
+	}
+
+	previousStatus := Agreement.Agreement_status
+	err = setAgreementStatus(APIstub, Agreement, tr.To, update_time)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(APIstub, &events.Event{
+		Type:           events.AgreementStatusChanged,
+		AssetID:        AgreementID,
+		PreviousStatus: previousStatus,
+		NewStatus:      tr.To,
+	}, agreementDetails(Agreement))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Printf("- %s moved to %s\n", AgreementID, tr.To)
+	return shim.Success(nil)
+}
+
+// setAgreementStatus stores a new status of an Agreement and moves its status index entry
+func setAgreementStatus(APIstub shim.ChaincodeStubInterface, Agreement *Agreement, status string, update_time string) error {
+	previous := *Agreement
+	Agreement.Agreement_status = status
+	Agreement.Agreement_update_time = update_time
+
+	valAsbytes, err := json.Marshal(Agreement)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(Agreement.AgreementID, valAsbytes)
+	if err != nil {
+		return err
+	}
+	return indexAgreement(APIstub, &previous, Agreement)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func expectStatus(t *testing.T, stub *callerStub, AgreementID string, status string) {
+	t.Helper()
+	Agreement, err := readAgreement(stub, AgreementID)
+	if err != nil || Agreement == nil {
+		t.Fatalf("Failed to read %s: %v", AgreementID, err)
+	}
+	if Agreement.Agreement_status != status {
+		t.Fatalf("Expected status %s, got %s", status, Agreement.Agreement_status)
+	}
+}
+
+func TestLifecycleInitialStatus(t *testing.T) {
+	stub := setupAgreement(t)
+	expectStatus(t, stub, "Agreement-tx2", StatusProposed)
+
+	res := stub.as(t, "Org1MSP", nil).invoke("tx3", "insertAgreementinfo", "Agreement", "Model-tx1", "10", "Org1MSP", "Org2MSP", "remark", "http://image", "active", docHash)
+	if res.Status == shim.OK {
+		t.Fatalf("Expected insert with a non-initial status to fail")
+	}
+}
+
+func TestLifecycleTransitions(t *testing.T) {
+	stub := setupAgreement(t)
+
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke("tx3", "approveAgreement", "Agreement-tx2"))
+	expectStatus(t, stub, "Agreement-tx2", StatusApproved)
+
+	stub.as(t, "Org1MSP", nil)
+	expectOK(t, stub.invoke("tx4", "activateAgreement", "Agreement-tx2"))
+	expectOK(t, stub.invoke("tx5", "suspendAgreement", "Agreement-tx2"))
+	expectStatus(t, stub, "Agreement-tx2", StatusSuspended)
+	expectOK(t, stub.invoke("tx6", "resumeAgreement", "Agreement-tx2"))
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke("tx7", "terminateAgreement", "Agreement-tx2"))
+	expectStatus(t, stub, "Agreement-tx2", StatusTerminated)
+}
+
+func TestLifecycleIllegalTransitions(t *testing.T) {
+	stub := setupAgreement(t)
+
+	stub.as(t, "Org1MSP", nil)
+	if res := stub.invoke("tx3", "activateAgreement", "Agreement-tx2"); res.Status == shim.OK {
+		t.Fatalf("Expected proposed -> active to be refused")
+	}
+	if res := stub.as(t, "Org2MSP", nil).invoke("tx4", "approveAgreement", "Agreement-tx2", "active"); res.Status == shim.OK {
+		t.Fatalf("Expected approveAgreement to refuse a free-form status")
+	}
+
+	expectOK(t, stub.invoke("tx5", "rejectAgreement", "Agreement-tx2"))
+	if res := stub.invoke("tx6", "approveAgreement", "Agreement-tx2"); res.Status == shim.OK {
+		t.Fatalf("Expected rejected -> approved to be refused")
+	}
+	if res := stub.invoke("tx7", "queryModelByAgreementID", "Agreement-tx2"); res.Status == shim.OK {
+		t.Fatalf("Expected usage of a rejected agreement to be refused")
+	}
+}
@@ -4,53 +4,46 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"strconv"
 	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/imineev/cc1/events"
 )
 
 //  Chaincode implementation
 type MAGNIT_CC struct {
 }
 
-//  model's struct
-type Model struct {
-	ObjectType string `json:"docType"` //docType is used to distinguish the various types of objects in state database
-	model_id   string `json:"model_id"`
-	model_name string `json:"model_name"`
-	Upload_org string `json:"upload_org"`
-}
-
-type AgreementCounterNO struct {
-	Counter int `json:"counter"`
-}
-
-type ModelCounterNO struct {
-	Counter int `json:"counter"`
-}
-
-type CounterNO struct {
-	Counter int `json:"counter"`
-}
-
 //  Agreement data struct
 type Agreement struct {
-	ObjectType                    string `json:"docType"` //docType is used to distinguish the various types of objects in state database
-	AgreementID                   string `json:"AgreementID"`
-	Agreement_name                string `json:"Agreement_name"`
-	Agreement_model_id            string `json:"Agreement_model_id"`            // model id
-	Agreement_model_count_use     string `json:"Agreement_model_account_use"`   // model Agreement_model_account_use
-	Agreement_model_current_count string `json:"Agreement_model_current_count"` // model Agreement_model_current_count
-	Agreement_issuer              string `json:"Agreement_issuer"`              // org name issuer
-	Agreement_participant         string `json:"Agreement_participant"`         // org name participant
-	Agreement_create_time         string `json:"Agreement_create_time"`
-	Agreement_update_time         string `json:"Agreement_update_time"`
-	Agreement_remark              string `json:"Agreement_remark"`
-	Agreement_url_image           string `json:"Agreement_url_image"`
-	Agreement_status              string `json:"Agreement_status"`
-	Agreement_hash                string `json:"Agreement_hash"`
+	ObjectType                    string        `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	AgreementID                   string        `json:"AgreementID"`
+	Agreement_name                string        `json:"Agreement_name"`
+	Agreement_model_id            string        `json:"Agreement_model_id"`            // model id
+	Agreement_model_count_use     Count         `json:"Agreement_model_account_use"`   // model Agreement_model_account_use
+	Agreement_model_current_count Count         `json:"Agreement_model_current_count"` // model Agreement_model_current_count
+	Agreement_issuer              string        `json:"Agreement_issuer"`              // org name issuer
+	Agreement_participant         string        `json:"Agreement_participant"`         // org name participant
+	Agreement_create_time         string        `json:"Agreement_create_time"`
+	Agreement_update_time         string        `json:"Agreement_update_time"`
+	Agreement_remark              string        `json:"Agreement_remark"`
+	Agreement_url_image           string        `json:"Agreement_url_image"`
+	Agreement_status              string        `json:"Agreement_status"`
+	Agreement_hash                string        `json:"Agreement_hash"`
+	Agreement_model_version       string        `json:"Agreement_model_version"`                 // pinned model version or "latest"
+	Agreement_collection          string        `json:"Agreement_collection,omitempty"`          // private data collection holding the terms
+	Agreement_terms_hash          string        `json:"Agreement_terms_hash,omitempty"`          // salted hash of the private terms
+	Agreement_valid_from          string        `json:"Agreement_valid_from,omitempty"`          // RFC3339 in UTC, open when empty
+	Agreement_valid_until         string        `json:"Agreement_valid_until,omitempty"`         // RFC3339 in UTC, open when empty
+	Agreement_period_quotas       []PeriodQuota `json:"Agreement_period_quotas,omitempty"`       // limits per day, week or month
+	Agreement_parties             []Party       `json:"Agreement_parties,omitempty"`             // every party of a multi-party Agreement
+	Agreement_signature_threshold int           `json:"Agreement_signature_threshold,omitempty"` // signatures activating it, all parties when 0
+	Agreement_signatures          []Signature   `json:"Agreement_signatures,omitempty"`
+	Agreement_revision            int           `json:"Agreement_revision,omitempty"`          // number of accepted amendments
+	Agreement_pending_amendment   string        `json:"Agreement_pending_amendment,omitempty"` // AmendmentID awaiting a decision
+	Agreement_document_revision   int           `json:"Agreement_document_revision,omitempty"` // revisions of the document hash
+	Agreement_tombstone           *Tombstone    `json:"Agreement_tombstone,omitempty"`         // set once the Agreement is deleted
 }
 
 // ===================================================================================
@@ -65,226 +58,203 @@ func main() {
 }
 
 // Init Function Executes only on initializing or on updating the chain code
+//
+// Asset IDs are derived from transaction IDs, so there are no counters to set
+// up. The AgreementCounterNO and ModelCounterNO keys of earlier deployments are
+// left untouched.
 func (t *MAGNIT_CC) Init(APIstub shim.ChaincodeStubInterface) peer.Response {
 
-	// Initializing AgreementCounterNOAssetAsBytes Number
-
-	AgreementCounterNOAssetAsBytes, _ := APIstub.GetState("AgreementCounterNO")
-
-	if AgreementCounterNOAssetAsBytes == nil {
-		var AgreementCounter = AgreementCounterNO{Counter: 0}
-
-		AgreementCounterAsBytes, _ := json.Marshal(AgreementCounter)
-
-		err := APIstub.PutState("AgreementCounterNO", AgreementCounterAsBytes)
-
-		if err != nil {
-
-			return shim.Error(fmt.Sprintf("Failed to Intitate AgreementCounterNO"))
-
-		}
-
-	} else {
-		AgreementCounter := getCounter(APIstub, "AgreementCounterNO")
-		fmt.Printf("AgreementCounterNO is %d", AgreementCounter)
-
+	// args[0] the MSP ID of the channel operator
+	// args[1:] the MSP IDs of the channel organizations, collections_config.json
+	// defines the collection of every pair of them
+	// Upgrades without arguments keep the stored ones.
+	// a new deployment indexes every record from the start
+	if err := markIndexesOfEmptyLedger(APIstub); err != nil {
+		return errorResponse(err)
 	}
 
-	// Initializing ModelCounterNOAssetAsBytes Number
-
-	ModelCounterNOAssetAsBytes, _ := APIstub.GetState("ModelCounterNO")
-
-	if ModelCounterNOAssetAsBytes == nil {
-		var ModelCounter = ModelCounterNO{Counter: 0}
-
-		ModelCounterAsBytes, _ := json.Marshal(ModelCounter)
-
-		err := APIstub.PutState("ModelCounterNO", ModelCounterAsBytes)
-
-		if err != nil {
-
-			return shim.Error(fmt.Sprintf("Failed to Intitate ModelCounterNO"))
-
+	_, args := APIstub.GetFunctionAndParameters()
+	if len(args) == 0 {
+		return shim.Success(nil)
+	}
+	v := &validator{}
+	if v.required("operator", args[0]) {
+		v.mspID("operator", args[0])
+	}
+	for i, org := range args[1:] {
+		field := fmt.Sprintf("organizations[%d]", i)
+		if v.required(field, org) {
+			v.mspID(field, org)
 		}
-
-	} else {
-		ModelCounter := getCounter(APIstub, "ModelCounterNO")
-		fmt.Printf("ModelCounterNO is %d", ModelCounter)
 	}
-
-	return shim.Success(nil)
-}
-
-//getCounter to the latest value of the counter based on the Asset Type provided as input parameter
-func getCounter(APIstub shim.ChaincodeStubInterface, AssetType string) int {
-	counterAsBytes, _ := APIstub.GetState(AssetType)
-	counterAsset := CounterNO{}
-
-	json.Unmarshal(counterAsBytes, &counterAsset)
-	fmt.Sprintf("Counter Current Value %d of Asset Type %s", counterAsset.Counter, AssetType)
-
-	return counterAsset.Counter
-}
-
-//incrementCounter to the increase value of the counter based on the Asset Type provided as input parameter by 1
-func incrementCounter(APIstub shim.ChaincodeStubInterface, AssetType string) int {
-	counterAsBytes, _ := APIstub.GetState(AssetType)
-	counterAsset := CounterNO{}
-
-	json.Unmarshal(counterAsBytes, &counterAsset)
-	counterAsset.Counter++
-	counterAsBytes, _ = json.Marshal(counterAsset)
-
-	err := APIstub.PutState(AssetType, counterAsBytes)
-	if err != nil {
-
-		fmt.Sprintf("Failed to Increment Counter")
-
+	if err := v.err(); err != nil {
+		return errorResponse(err)
 	}
-	return counterAsset.Counter
-}
-
-//updateCounter to the increase value of the counter based on the Asset Type provided as input parameter by NewCount value provided as input
-func updateCounter(APIstub shim.ChaincodeStubInterface, AssetType string, NewCount int) int {
-	counterAsBytes, _ := APIstub.GetState(AssetType)
-	counterAsset := CounterNO{}
-
-	json.Unmarshal(counterAsBytes, &counterAsset)
-	counterAsset.Counter = NewCount
-	counterAsBytes, _ = json.Marshal(counterAsset)
-
-	fmt.Sprintf("in updateCounter for asset %v newCount %d", AssetType, NewCount)
-
-	err := APIstub.PutState(AssetType, counterAsBytes)
-	if err != nil {
-
-		fmt.Sprintf("Failed to Increment Counter")
-
-		return -1
-
+	if err := putOperatorMSP(APIstub, args[0]); err != nil {
+		return errorResponse(err)
+	}
+	if len(args) > 1 {
+		if err := putChannelOrgs(APIstub, args[1:]); err != nil {
+			return errorResponse(err)
+		}
 	}
-	return counterAsset.Counter
+	return shim.Success(nil)
 }
 
 // GetTxTimestampChannel Function gets the Transaction time when the chain code was executed it remains same on all the peers where chaincode executes
 func (t *MAGNIT_CC) GetTxTimestampChannel(APIstub shim.ChaincodeStubInterface) (string, error) {
-	txTimeAsPtr, err := APIstub.GetTxTimestamp()
+	txTime, err := getTxTime(APIstub)
 	if err != nil {
 		fmt.Printf("Returning error in TimeStamp \n")
 		return "Error", err
 	}
-	fmt.Printf("\t returned value from APIstub: %v\n", txTimeAsPtr)
-	timeStr := time.Unix(txTimeAsPtr.Seconds, int64(txTimeAsPtr.Nanos)).String()
+	fmt.Printf("\t returned value from APIstub: %v\n", txTime)
+	timeStr := txTime.String()
 
 	return timeStr, nil
 }
 
+// getTxTime returns the transaction timestamp set by the submitting client,
+// the same on every endorsing peer
+func getTxTime(APIstub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTimeAsPtr, err := APIstub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(txTimeAsPtr.Seconds, int64(txTimeAsPtr.Nanos)), nil
+}
+
 // Invoke - Our entry point for Invocations
+//
+// The function registry names the handler, access policy and mode of every
+// function, describe lists them. Every function runs between the hooks of
+// beforeTransaction and afterTransaction.
 // ========================================
 func (t *MAGNIT_CC) Invoke(APIstub shim.ChaincodeStubInterface) peer.Response {
 	function, args := APIstub.GetFunctionAndParameters()
 	fmt.Println("invoke is running " + function)
 
-	// Handle different functions
-	if function == "initmodel" { //create a new model or student
-		return t.initmodel(APIstub, args)
-	} else if function == "queryByModel_id" { // query a model by id, stupid name - -!
-		return t.queryByModel_id(APIstub, args)
-	} else if function == "insertAgreementinfo" { //insert a Agreement
-		return t.insertAgreementinfo(APIstub, args)
-	} else if function == "queryByAgreementID" { // query a Agreement
-		return t.queryByAgreementID(APIstub, args)
-	} else if function == "queryModelByAgreementID" { // delete Model or Agreement
-		return t.queryModelByAgreementID(APIstub, args)
-	} else if function == "getHistoryForRecord" { //query hisitory of one key for the record
-		return t.getHistoryForRecord(APIstub, args)
-	} else if function == "queryAllAgreements" { // query all of all agrtements
-		return t.queryAllAgreements(APIstub, args)
-	} else if function == "queryAllAsset" { // query all of all agrtements
-		return t.queryAllAsset(APIstub, args)
-	} else if function == "approveAgreement" { // change status to approved
-		return t.approveAgreement(APIstub, args)
-	} else if function == "del" { // delete Model or Agreement
-		return t.del(APIstub, args)
-	}
-
-	fmt.Println("invoke did not find func: " + function) //error
-	return shim.Error("Received unknown function invocation")
-}
-
-// ===========================================================
-// del
-// ===========================================================
-func (t *MAGNIT_CC) del(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	registered, ok := functionIndex[function]
+	if !ok {
+		fmt.Println("invoke did not find func: " + function) //error
+		return errorResponse(newError(codeUnknownFunction, "Received unknown function invocation "+function+", query describe for the supported functions"))
 	}
 
-	id := args[0]
-	err := APIstub.DelState(id)
-	if err != nil {
-		return shim.Error(err.Error())
+	// the before hooks check who is calling before any state is touched
+	ctx := &TransactionContext{stub: APIstub, function: registered}
+	for _, before := range beforeTransaction {
+		if err := before(t, ctx, args); err != nil {
+			return transactionRefused(ctx, err)
+		}
 	}
 
-	return shim.Success(nil)
+	response := registered.invoke(t, ctx, args)
+	for _, after := range afterTransaction {
+		response = after(ctx, response)
+	}
+	return response
 }
 
 // ============================================================
 // initmodel - create a new model, store into chaincode state
+//
+//model_name
+//upload_org
+//model_version
+//model_description
+//model_framework
+//model_artifact_hash
+//model_artifact_uri
+//model_license
+//model_tags - comma separated
+//
+// The input is a JSON object with the snake_case fields of a model, passed in
+// the transient map under "input" or as the only argument. The positional
+// form is deprecated, its metadata arguments are optional so the old two
+// argument form still works.
 // ============================================================
 func (t *MAGNIT_CC) initmodel(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	var err error
-
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
-	if len(args[0]) <= 0 {
-		return shim.Error("Model Name argument must be a non-empty string")
-	}
-	if len(args[1]) <= 0 {
-		return shim.Error("Name of organization wich uploaded the model must be a non-empty string")
+	// ==== Input sanitation ====
+	input, err := readModelInput(APIstub, args)
+	if err != nil {
+		return errorResponse(err)
 	}
 
-	// ==== Input sanitation ====
 	fmt.Println("- start init model")
 
-	model_name := args[0]
-	upload_org := args[1]
-
-	ModelCounterNO := getCounter(APIstub, "ModelCounterNO")
-	ModelCounterNO++
-
-	model_id := "Model" + strconv.Itoa(ModelCounterNO)
-
-	// ==== Check if model already exists ====
-	modelAsBytes, err := APIstub.GetState(model_id)
+	// ==== Derive the model ID, this also checks that it is not taken ====
+	model_id, err := newAssetID(APIstub, modelIDPrefix)
 	if err != nil {
-		return shim.Error("Failed to get model: " + err.Error())
-	} else if modelAsBytes != nil {
-		fmt.Println("This model already exists: " + model_id)
-		return shim.Error("This model already exists: " + model_id)
+		return errorResponse(err)
+	}
+
+	create_time, errTx := t.GetTxTimestampChannel(APIstub)
+	if errTx != nil {
+		return errorResponse(errTx)
 	}
 
 	// ==== Create model object and marshal to JSON ====
-	objectType := "model"
-	Model := &Model{objectType, model_id, model_name, upload_org}
+	Model := &Model{
+		ObjectType:          "model",
+		Schema_version:      modelSchemaVersion,
+		Model_id:            model_id,
+		Model_name:          input.Model_name,
+		Upload_org:          input.Upload_org,
+		Model_version:       input.Model_version,
+		Model_description:   input.Model_description,
+		Model_framework:     input.Model_framework,
+		Model_artifact_hash: input.Model_artifact_hash,
+		Model_artifact_uri:  input.Model_artifact_uri,
+		Model_license:       input.Model_license,
+		Model_tags:          input.Model_tags,
+		Model_create_time:   create_time,
+		Model_update_time:   create_time,
+	}
+
+	// ==== A model uploaded with a version becomes its first release ====
+	if Model.Model_version != "" {
+		err = putModelVersion(APIstub, &ModelVersion{
+			Model_id:            model_id,
+			Model_version:       Model.Model_version,
+			Model_description:   Model.Model_description,
+			Model_artifact_hash: Model.Model_artifact_hash,
+			Model_artifact_uri:  Model.Model_artifact_uri,
+			Upload_org:          Model.Upload_org,
+			Publish_time:        create_time,
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		Model.Model_latest_version = Model.Model_version
+	}
+
 	ModelJSONasBytes, err := json.Marshal(Model)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	// === Save model to state ===
 	err = APIstub.PutState(model_id, ModelJSONasBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
+	}
+	err = indexModel(APIstub, nil, Model)
+	if err != nil {
+		return errorResponse(err)
 	}
 
-	incCount := incrementCounter(APIstub, "ModelCounterNO")
+	err = emitEvent(APIstub, &events.Event{Type: events.ModelCreated, AssetID: model_id}, &events.ModelDetails{
+		UploadOrg:    Model.Upload_org,
+		ModelVersion: Model.Model_version,
+		ArtifactHash: Model.Model_artifact_hash,
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
 
 	// ==== model saved and indexed. Return success ====
-	fmt.Printf("- end init model model_id %d:\n", incCount)
-	return shim.Success(nil)
+	fmt.Printf("- end init model model_id %s\n", model_id)
+	return shim.Success(ModelJSONasBytes)
 
 }
 
@@ -292,87 +262,50 @@ func (t *MAGNIT_CC) initmodel(APIstub shim.ChaincodeStubInterface, args []string
 // queryByAgreementID - read a Agreement from chaincode state
 // ===============================================
 func (t *MAGNIT_CC) queryByAgreementID(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	var AgreementID, jsonResp string
+	var AgreementID string
 	var err error
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting AgreementID of the Agreement to query")
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting AgreementID of the Agreement to query"))
 	}
 
 	AgreementID = args[0]
 	valAsbytes, err := APIstub.GetState(AgreementID) //get the Agreement from chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + AgreementID + "\"}"
-		return shim.Error(jsonResp)
+		return errorResponse(err)
 	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Agreement does not exist: " + AgreementID + "\"}"
-		return shim.Error(jsonResp)
+		return errorResponse(notFoundError("Agreement does not exist: " + AgreementID))
 	}
 
 	return shim.Success(valAsbytes)
 }
 
 // =================================================================
-// queryModelByAgreementID - read a Agreement from chaincode state
+// queryModelByAgreementID - count a use of an Agreement and return its model version
+//
+// Deprecated, consumeModelUsage reports the remaining quota as well. The
+// model version carries the access grant of the use like consumeModelUsage.
 // =================================================================
 func (t *MAGNIT_CC) queryModelByAgreementID(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	var AgreementID, jsonResp string
-	var err error
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting AgreementID of the Agreement to query")
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting AgreementID of the Agreement to query"))
 	}
 
-	AgreementID = args[0]
-
-	valAsbytes, err := APIstub.GetState(AgreementID) //get the Agreement from chaincode state
+	consumption, err := t.consume(APIstub, args[0], 1)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + AgreementID + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Agreement does not exist: " + AgreementID + "\"}"
-		return shim.Error(jsonResp)
+		return errorResponse(err)
 	}
 
-	Agreement := &Agreement{}
-	err = json.Unmarshal([]byte(valAsbytes), &Agreement)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	// check of uses
-	countUse, err := strconv.Atoi(Agreement.Agreement_model_count_use)
+	result := struct {
+		*ModelVersion
+		Access_grant *AccessGrant `json:"access_grant"`
+	}{consumption.Model, consumption.Access_grant}
+	ModelVersionAsBytes, err := json.Marshal(result)
 	if err != nil {
-		fmt.Printf("Can't convert to int AgreementAsset.Agreement_model_count_use %s\n", Agreement.Agreement_model_count_use)
+		return errorResponse(err)
 	}
-	currentCount, err := strconv.Atoi(Agreement.Agreement_model_current_count)
-	if err != nil {
-		fmt.Printf("Can't convert to int AgreementAsset.Agreement_model_current_count %s\n", Agreement.Agreement_model_current_count)
-	}
-
-	// return error if  currentCount > countUse
-
-	if currentCount >= countUse {
-		jsonResp = "{\"Error\":\"Failed to get the Model - model_current_count_query: " + " Вы достигли лимита разрешенных запросов: " + Agreement.Agreement_model_count_use + "\"}"
-		return shim.Error(jsonResp)
-	}
-
-	Agreement.Agreement_model_current_count = strconv.Itoa(currentCount)
-
-	eventPayload := "Agreement with ID " + Agreement.AgreementID + " was selected"
-	payloadAsBytes := []byte(eventPayload)
-	eventErr := APIstub.SetEvent("queryEvent", payloadAsBytes)
-	if eventErr != nil {
-		return shim.Error(fmt.Sprintf("Failed to emit event"))
-	}
-	fmt.Println("Event: Agrrement with ID " + Agreement.AgreementID + " was selected")
-	// increase count of uses of Agreement
-	output := t.updateAgreement(APIstub, *Agreement)
-
-	if output != "Success" {
-		return shim.Error(err.Error())
-	}
-
-	return shim.Success(valAsbytes)
+	return shim.Success(ModelVersionAsBytes)
 }
 
 // ===============================================================
@@ -391,48 +324,73 @@ func (t *MAGNIT_CC) queryModelByAgreementID(APIstub shim.ChaincodeStubInterface,
 //Agreement_url_image
 //Agreement_status string
 //Agreement_hash string
+//Agreement_model_version - optional, pinned model version or "latest"
+//
+// The input is a JSON object with the Agreement_ fields above, passed in the
+// transient map under "input" or as the only argument. The positional form
+// of 9 or 10 arguments, where the 8th is the ignored status, is deprecated.
+//
+// Confidential quota, price, remark and hash are passed as JSON in the
+// transient map under agreement_terms and the positional ones left empty.
 // ===============================================================
 func (t *MAGNIT_CC) insertAgreementinfo(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-	if len(args) != 9 {
-		return shim.Error("##Incorrect number of arguments. expecting 9 args")
+	input, err := readAgreementInput(APIstub, args)
+	if err != nil {
+		return errorResponse(err)
 	}
 
-	Agreement_name := args[0]
-	Agreement_model_id := args[1]
-	Agreement_model_count_use := args[2]
-	Agreement_model_current_count := "0"
-	Agreement_issuer := args[3]
-	Agreement_participant := args[4]
-	Agreement_remark := args[5]
-	Agreement_url_image := args[6]
-	Agreement_status := args[7]
-	Agreement_hash := args[8]
-
-	AgreementCounterNO := getCounter(APIstub, "AgreementCounterNO")
-	AgreementCounterNO++
+	Agreement_name := input.Agreement_name
+	Agreement_model_id := input.Agreement_model_id
+	Agreement_model_count_use := Count(0)
+	if input.Agreement_model_count_use != nil {
+		Agreement_model_count_use = *input.Agreement_model_count_use
+	}
+	Agreement_model_current_count := Count(0)
+	Agreement_issuer := input.Agreement_issuer
+	Agreement_participant := input.Agreement_participant
+	Agreement_remark := input.Agreement_remark
+	Agreement_url_image := input.Agreement_url_image
+	Agreement_status := StatusProposed
+	Agreement_hash := input.Agreement_hash
+	Agreement_model_version := input.Agreement_model_version
 
-	AgreementID := "Agreement" + strconv.Itoa(AgreementCounterNO)
+	AgreementID, err := newAssetID(APIstub, agreementIDPrefix)
+	if err != nil {
+		return errorResponse(err)
+	}
 
-	fmt.Println("###start insertAgreementinfo ID:%s", AgreementID)
+	fmt.Printf("###start insertAgreementinfo ID:%s\n", AgreementID)
 
 	Agreement_create_time, errTx := t.GetTxTimestampChannel(APIstub)
 	if errTx != nil {
-		return shim.Error("Returning error")
+		return errorResponse(errTx)
 	}
 
 	Agreement_update_time, errTx := t.GetTxTimestampChannel(APIstub)
 	if errTx != nil {
-		return shim.Error("Returning error")
+		return errorResponse(errTx)
 	}
 
 	// check if model exists
 	valAsBytes, err := APIstub.GetState(Agreement_model_id)
 	if err != nil {
-		return shim.Error("Failed to get model:" + Agreement_model_id + "," + err.Error())
+		return errorResponse(err)
 	} else if valAsBytes == nil {
 		fmt.Println("Model id does not exist:[" + Agreement_model_id + "]")
-		return shim.Error("Model id does not exist" + Agreement_model_id)
+		return errorResponse(notFoundError("Model id does not exist: " + Agreement_model_id))
+	}
+
+	// a pinned version has to be published already
+	if _, err := resolveModelVersion(APIstub, Agreement_model_id, Agreement_model_version); err != nil {
+		return errorResponse(err)
+	}
+
+	// an Agreement that could never be used is refused
+	if input.Agreement_valid_until != "" {
+		if err := requireFutureUntil(APIstub, input.Agreement_valid_until); err != nil {
+			return errorResponse(err)
+		}
 	}
 
 	//check if Agreement exist
@@ -445,316 +403,233 @@ func (t *MAGNIT_CC) insertAgreementinfo(APIstub shim.ChaincodeStubInterface, arg
 	//}
 
 	objectType := "Agreement"
-	Agreement := &Agreement{objectType, AgreementID, Agreement_name, Agreement_model_id, Agreement_model_count_use, Agreement_model_current_count, Agreement_issuer, Agreement_participant, Agreement_create_time, Agreement_update_time, Agreement_remark, Agreement_url_image, Agreement_status, Agreement_hash}
+	Agreement := &Agreement{objectType, AgreementID, Agreement_name, Agreement_model_id, Agreement_model_count_use, Agreement_model_current_count, Agreement_issuer, Agreement_participant, Agreement_create_time, Agreement_update_time, Agreement_remark, Agreement_url_image, Agreement_status, Agreement_hash, Agreement_model_version, "", "", input.Agreement_valid_from, input.Agreement_valid_until, input.Agreement_period_quotas, input.Agreement_parties, input.Agreement_signature_threshold, nil, 0, "", 0, nil}
+
+	// confidential terms go to the collection of the two parties
+	terms, err := transientTerms(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if terms != nil {
+		if len(agreementParties(Agreement)) > 2 {
+			return errorResponse(argumentError("Private terms are only supported between two parties"))
+		}
+		if input.Agreement_model_count_use != nil || len(input.Agreement_period_quotas) > 0 || Agreement_remark != "" || Agreement_hash != "" {
+			return errorResponse(argumentError("Quotas, remark and hash must only be passed in the transient " + agreementTermsTransientKey))
+		}
+		err = putAgreementTerms(APIstub, Agreement, terms)
+		if err != nil {
+			return errorResponse(err)
+		}
+	} else if input.Agreement_model_count_use == nil && len(input.Agreement_period_quotas) > 0 {
+		// period quotas alone do not cap the lifetime usage
+		Agreement.Agreement_model_count_use = maxAgreementQuota
+	} else if input.Agreement_model_count_use == nil {
+		return errorResponse(&FieldError{"Agreement_model_account_use", "must be set unless the terms are private or period quotas are set"})
+	}
+
+	err = recordDocument(APIstub, Agreement, Agreement_create_time)
+	if err != nil {
+		return errorResponse(err)
+	}
+
 	AgreementJSONasBytes, err := json.Marshal(Agreement)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	//AgreementJSONasBytes, _ := json.Marshal(batchToUpdate)
 	err = APIstub.PutState(AgreementID, AgreementJSONasBytes) //insert the Agreement
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
+	}
+	err = indexAgreement(APIstub, nil, Agreement)
+	if err != nil {
+		return errorResponse(err)
 	}
-
-	incCount := incrementCounter(APIstub, "AgreementCounterNO")
 
 	// ==== modelagreement saved and indexed. Return success ====
 
-	eventPayload := "Agreement with ID " + AgreementID + " was issued and ready to confirm"
-	payloadAsBytes := []byte(eventPayload)
-	eventErr := APIstub.SetEvent("newAgreementEvent", payloadAsBytes)
-	if eventErr != nil {
-		return shim.Error(fmt.Sprintf("Failed to emit event"))
+	err = emitEvent(APIstub, &events.Event{Type: events.AgreementCreated, AssetID: AgreementID, NewStatus: Agreement_status}, agreementDetails(Agreement))
+	if err != nil {
+		return errorResponse(err)
 	}
-	fmt.Println("Event: Agrrement with ID " + Agreement.AgreementID + " was selected")
 
-	fmt.Println("------  end insertAgreementinfo  (success) AgreementID: %d \n", incCount)
 	fmt.Println("------  end insertAgreementinfo  (success) AgreementID: " + AgreementID)
-	return shim.Success(nil)
+	return shim.Success(AgreementJSONasBytes)
 }
 
 // ===============================================================
 // queryByModel_id - read data for one model from chaincode state
 // ===============================================================
 func (t *MAGNIT_CC) queryByModel_id(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	var recev_id, jsonResp string
+	var recev_id string
 	var err error
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting model_id to query")
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting model_id to query"))
 	}
 
 	recev_id = args[0]
-	valAsbytes, err := APIstub.GetState(recev_id) //get the model from chaincode state
+	Model, err := readModel(APIstub, recev_id) //get the model from chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + recev_id + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"model does not exist: " + recev_id + "\"}"
-		return shim.Error(jsonResp)
+		return errorResponse(err)
+	} else if Model == nil {
+		return errorResponse(notFoundError("model does not exist: " + recev_id))
 	}
 
+	valAsbytes, err := json.Marshal(Model)
+	if err != nil {
+		return errorResponse(err)
+	}
 	return shim.Success(valAsbytes)
 
 }
 
 // ==========================================================
-// approveAgreement - update status of Agreement to agrg[1]
+// approveAgreement - move a proposed Agreement to approved
+//
+// The second argument of the old free-form status update is still
+// accepted, but it must name the approved status.
 // ==========================================================
 func (t *MAGNIT_CC) approveAgreement(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-	var err error
 	// check args
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
-	if len(args[0]) <= 0 {
-		return shim.Error("AgreementID must be a non-empty string")
-	}
-	if len(args[1]) <= 0 {
-		return shim.Error("Status of agreement must be a non-empty string")
-	}
-
-	AgreementID := args[0]
-	status := args[1]
-
-	update_time, errTx := t.GetTxTimestampChannel(APIstub)
-	if errTx != nil {
-		return shim.Error("Returning error")
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting 1"))
 	}
-
-	valAsbytes, err := APIstub.GetState(AgreementID) //get the Agreement from chaincode state
-	if err != nil {
-		return shim.Error(err.Error())
-	} else if valAsbytes == nil {
-		return shim.Error("Agreement not exist")
+	if len(args) == 2 && args[1] != StatusApproved {
+		return errorResponse(&FieldError{"status", "approveAgreement can only set status " + StatusApproved})
 	}
 
-	Agreement := &Agreement{}
-	err = json.Unmarshal([]byte(valAsbytes), &Agreement)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	Agreement.Agreement_status = status
-	Agreement.Agreement_update_time = update_time
-
-	valAsbytes, err = json.Marshal(Agreement)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	err = APIstub.PutState(AgreementID, valAsbytes)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-
-	fmt.Println("update success")
-	return shim.Success(nil)
+	return t.transitionAgreement(APIstub, "approveAgreement", args[:1])
 
 }
 
-// ==============================================================
-// updateAgreement update count of use in the Agreement count++
-// ==============================================================
-func (t *MAGNIT_CC) updateAgreement(APIstub shim.ChaincodeStubInterface, AgreementAsset Agreement) string {
-
-	fmt.Printf("In update AgreementId %s -- array %v", AgreementAsset.AgreementID, AgreementAsset)
+// ===============================================
+// queryAllAgreements in the channel
+//
+// Only the Agreements of the submitter's organization, administrators read
+// every Agreement.
+// ===============================================
 
-	update_time, errTx := t.GetTxTimestampChannel(APIstub)
-	if errTx != nil {
-		return "GetTxTimestampChannel returning error"
-	}
+func (t *MAGNIT_CC) queryAllAgreements(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-	countUse, err := strconv.Atoi(AgreementAsset.Agreement_model_current_count)
+	caller, err := getCaller(APIstub)
 	if err != nil {
-		fmt.Printf("Can't convert to int AgreementAsset.Agreement_model_count_use %s\n", AgreementAsset.Agreement_model_count_use)
+		return errorResponse(err)
 	}
-
-	countUse++
-
-	AgreementAsset.Agreement_model_current_count = strconv.Itoa(countUse)
-	AgreementAsset.Agreement_update_time = update_time
-
-	fmt.Printf("Increase count:%s for %s", AgreementAsset.Agreement_model_current_count, AgreementAsset.AgreementID)
-
-	AgreementUp := &Agreement{AgreementAsset.ObjectType, AgreementAsset.AgreementID, AgreementAsset.Agreement_name, AgreementAsset.Agreement_model_id, AgreementAsset.Agreement_model_count_use, AgreementAsset.Agreement_model_current_count, AgreementAsset.Agreement_issuer, AgreementAsset.Agreement_participant, AgreementAsset.Agreement_create_time, AgreementAsset.Agreement_update_time, AgreementAsset.Agreement_remark, AgreementAsset.Agreement_url_image, AgreementAsset.Agreement_status, AgreementAsset.Agreement_hash}
-
-	valJSONasBytes, err := json.Marshal(AgreementUp)
+	selector := map[string]interface{}{"docType": "Agreement", "Agreement_tombstone": map[string]interface{}{"$exists": false}}
+	restrictToParty(selector, caller)
+	queryAsBytes, err := json.Marshal(map[string]interface{}{"selector": selector, "use_index": indexAgreementDocType.useIndex()})
 	if err != nil {
-		return "error: Failed to json.Marshal valJSONasBytes"
+		return errorResponse(err)
 	}
 
-	err = APIstub.PutState(AgreementAsset.AgreementID, valJSONasBytes)
+	queryResults, err := getQueryResultForQueryString(APIstub, string(queryAsBytes))
 	if err != nil {
-		return "error: Failed to PutState AgreementID, AgreementAsset"
+		return errorResponse(err)
 	}
-
-	fmt.Println("Succes update Agreement  %v", valJSONasBytes)
-	return "Success"
+	//return shim.Success()
+	return shim.Success(queryResults)
 }
 
 // ===============================================
-// queryAllAgreements in the channel
+// queryAllAsset - page through every key of the chaincode
+//
+//pageSize - optional, defaults to 100
+//bookmark - optional, the bookmark returned with the previous page
 // ===============================================
+func (t *MAGNIT_CC) queryAllAsset(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
-func (t *MAGNIT_CC) queryAllAgreements(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-
-	queryString := "{\"selector\":{\"docType\":\"Agreement\"}}"
+	if len(args) > 2 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting pageSize, bookmark"))
+	}
+	fields := make([]string, 2)
+	copy(fields, args)
 
-	queryResults, err := getQueryResultForQueryString(APIstub, queryString)
+	pageSize, err := parsePageSize(fields[0])
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
-	//return shim.Success()
-	return shim.Success(queryResults)
-}
-
-// query all assets
-func (t *MAGNIT_CC) queryAllAsset(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-
-	startKey := ""
-
-	endKey := ""
-
-	resultsIterator, err := APIstub.GetStateByRange(startKey, endKey)
 
+	resultsIterator, metadata, err := APIstub.GetStateByRangeWithPagination("", "", pageSize, fields[1])
 	if err != nil {
-
-		return shim.Error(err.Error())
-
+		return errorResponse(err)
 	}
 
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing QueryResults
-
-	var buffer bytes.Buffer
-
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-
-	for resultsIterator.HasNext() {
-
-		queryResponse, err := resultsIterator.Next()
-		// respValue := string(queryResponse.Value)
-		if err != nil {
-
-			return shim.Error(err.Error())
-
-		}
-
-		// Add a comma before array members, suppress it for the first array member
-
-		if bArrayMemberAlreadyWritten == true {
-
-			buffer.WriteString(",")
-
-		}
-
-		buffer.WriteString("{\"Key\":")
-
-		buffer.WriteString("\"")
-
-		buffer.WriteString(queryResponse.Key)
-
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-
-		// Record is a JSON object, so we write as-is
-
-		buffer.WriteString(string(queryResponse.Value))
-
-		buffer.WriteString("}")
-
-		bArrayMemberAlreadyWritten = true
-
+	pageAsBytes, err := writePage(resultsIterator, metadata, true)
+	if err != nil {
+		return errorResponse(err)
 	}
 
-	buffer.WriteString("]")
-
-	fmt.Printf("- queryAllAssets:\n%s\n", buffer.String())
+	fmt.Printf("- queryAllAssets:\n%s\n", pageAsBytes)
 
-	return shim.Success(buffer.Bytes())
+	return shim.Success(pageAsBytes)
 
 }
 
-// ===============================================
-// createIndexformodel - build an index for an model to
-// ===============================================
-func (t *MAGNIT_CC) createIndexformodel(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-
-	//return shim.Success()
-	return shim.Success(nil)
-}
-
 // ===========================================================================================
 // getHistoryForRecord returns the historical state transitions for a given key of a record
+//
+// Deprecated, use queryAgreementHistory or queryModelHistory. Only Agreements
+// and Models have a history.
 // ===========================================================================================
 func (t *MAGNIT_CC) getHistoryForRecord(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 
 	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting 1"))
 	}
 
 	recordKey := args[0]
 
 	fmt.Printf("- start getHistoryForRecord: %s\n", recordKey)
 
-	resultsIterator, err := APIstub.GetHistoryForKey(recordKey)
+	valAsbytes, err := APIstub.GetState(recordKey)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
-	defer resultsIterator.Close()
+	if valAsbytes == nil {
+		// a deleted record is typed by its last version
+		valAsbytes, err = lastVersion(APIstub, recordKey)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	record := struct {
+		ObjectType string `json:"docType"`
+	}{}
+	json.Unmarshal(valAsbytes, &record)
 
-	// buffer is a JSON array containing historic values for the key/value pair
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
+	switch record.ObjectType {
+	case "Agreement":
+		return t.queryAgreementHistory(APIstub, args[:1])
+	case "model":
+		return t.queryModelHistory(APIstub, args[:1])
+	}
+	return errorResponse(argumentError("Only Agreements and Models have a history: " + recordKey))
+}
 
-	bArrayMemberAlreadyWritten := false
+// lastVersion returns the last value written to key before it was deleted,
+// nil if it never had one
+func lastVersion(APIstub shim.ChaincodeStubInterface, key string) ([]byte, error) {
+	resultsIterator, err := APIstub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var value []byte
 	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
+		modification, err := resultsIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
+			return nil, err
 		}
-		buffer.WriteString("{\"TxId\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(response.TxId)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Value\":")
-		// if it was a delete operation on given key, then we need to set the
-		//corresponding value null. Else, we will write the response.Value
-		//as-is (as the Value itself a JSON goods)
-		if response.IsDelete {
-			buffer.WriteString("null")
-		} else {
-			buffer.WriteString(string(response.Value))
+		if !modification.IsDelete {
+			value = modification.Value
 		}
-
-		buffer.WriteString(", \"Timestamp\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"IsDelete\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(strconv.FormatBool(response.IsDelete))
-		buffer.WriteString("\"")
-
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
 	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getHistoryForRecord returning:\n%s\n", buffer.String())
-
-	return shim.Success(buffer.Bytes())
+	return value, nil
 }
 
 // ========================================================================================
@@ -28,10 +28,7 @@ func TestInvokeInitMagnit(t *testing.T) {
 	fmt.Println("Entering TestInvokeInitMagnit")
 
 	// Instantiate mockStub using TrashTraceDemo as the target chaincode to unit test
-	stub := shim.NewMockStub("mockStub", new(MAGNIT_CC))
-	if stub == nil {
-		t.Fatalf("MockStub creation failed")
-	}
+	stub := newCallerStub(t)
 
 	var modelName = "Model1234"
 	var uploadOgr = "Org1234"
@@ -41,9 +38,7 @@ func TestInvokeInitMagnit(t *testing.T) {
 	// data model for initial state - create container
 	//      0
 	//   "cnt1234"
-	result := stub.MockInvoke("001",
-		[][]byte{[]byte("initmodel"),
-			[]byte(modelName), []byte(uploadOgr)})
+	result := stub.as(t, uploadOgr, nil).invoke("001", "initmodel", modelName, uploadOgr)
 	fmt.Println("Status: " + fmt.Sprint(result.GetStatus()))
 	// We expect a shim.ok if all goes well
 	if result.Status != shim.OK {
@@ -51,11 +46,11 @@ func TestInvokeInitMagnit(t *testing.T) {
 	}
 
 	// here we validate we can retrieve the object we just committed by modelID
-	valAsbytes, err := stub.GetState("Model1")
+	valAsbytes, err := stub.GetState("Model-001")
 	if err != nil {
-		t.Errorf("Failed to get state for Container " + "Model1")
+		t.Errorf("Failed to get state for Container " + "Model-001")
 	} else if valAsbytes == nil {
-		t.Errorf("Container does not exist:" + "Model1")
+		t.Errorf("Container does not exist:" + "Model-001")
 	}
 
 }
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// attrOID is the certificate extension the fabric CA stores attributes in
+var attrOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// callerStub wraps shim.MockStub so tests can choose the submitting identity.
+// MockStub.GetCreator always returns nil, which cid cannot parse.
+type callerStub struct {
+	*shim.MockStub
+	cc        *MAGNIT_CC
+	args      [][]byte
+	creator   []byte
+	key       *ecdsa.PrivateKey // of creator
+	transient map[string][]byte
+	events    []*peer.ChaincodeEvent
+	queries   []string
+	history   map[string][]*queryresult.KeyModification
+	paginated bool // the invocation ran a paginated query
+}
+
+// channelOrgs are the organizations of the test channel, those of collections_config.json
+var channelOrgs = []string{"Org1MSP", "Org2MSP", "Org3MSP"}
+
+func newCallerStub(t testing.TB) *callerStub {
+	cc := new(MAGNIT_CC)
+	stub := &callerStub{MockStub: shim.NewMockStub("mockstub", cc), cc: cc}
+
+	// Org1MSP operates the channel of Org1MSP, Org2MSP and Org3MSP
+	stub.args = [][]byte{[]byte("init"), []byte("Org1MSP")}
+	for _, org := range channelOrgs {
+		stub.args = append(stub.args, []byte(org))
+	}
+	stub.MockTransactionStart("init")
+	response := cc.Init(stub)
+	stub.MockTransactionEnd("init")
+	if response.Status != shim.OK {
+		t.Fatalf("Init failed: %s", response.Message)
+	}
+	return stub
+}
+
+// as switches the submitting identity to a certificate of mspID with the given attributes
+func (s *callerStub) as(t testing.TB, mspID string, attrs map[string]string) *callerStub {
+	s.creator, s.key = newCreator(t, mspID, attrs)
+	return s
+}
+
+func (s *callerStub) invoke(txID string, args ...string) peer.Response {
+	s.args = make([][]byte, 0, len(args))
+	for _, arg := range args {
+		s.args = append(s.args, []byte(arg))
+	}
+
+	s.events = nil
+	s.MockTransactionStart(txID)
+	response := s.cc.Invoke(s)
+	s.MockTransactionEnd(txID)
+	s.transient = nil
+	s.paginated = false
+	return response
+}
+
+// errPaginatedWrite is the error of the peer simulator for writes after a paginated query
+var errPaginatedWrite = errors.New("Transaction has already performed a paginated query. Writes are not allowed")
+
+// withTransient sets the transient map of the next invocation
+func (s *callerStub) withTransient(transient map[string][]byte) *callerStub {
+	s.transient = transient
+	return s
+}
+
+func (s *callerStub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *callerStub) GetStringArgs() []string {
+	strargs := make([]string, 0, len(s.args))
+	for _, arg := range s.args {
+		strargs = append(strargs, string(arg))
+	}
+	return strargs
+}
+
+func (s *callerStub) GetFunctionAndParameters() (string, []string) {
+	strargs := s.GetStringArgs()
+	if len(strargs) == 0 {
+		return "", []string{}
+	}
+	return strargs[0], strargs[1:]
+}
+
+func (s *callerStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *callerStub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+// SetEvent keeps the events of the last invocation instead of queueing them
+// on ChaincodeEventsChannel, which blocks once its buffer is full
+func (s *callerStub) SetEvent(name string, payload []byte) error {
+	s.events = append(s.events, &peer.ChaincodeEvent{EventName: name, Payload: payload})
+	return nil
+}
+
+// PutState records the write in the key history, MockStub keeps none
+func (s *callerStub) PutState(key string, value []byte) error {
+	if s.paginated {
+		return errPaginatedWrite
+	}
+	if err := s.MockStub.PutState(key, value); err != nil {
+		return err
+	}
+	s.recordHistory(key, value, false)
+	return nil
+}
+
+// DelState records the deletion in the key history
+func (s *callerStub) DelState(key string) error {
+	if s.paginated {
+		return errPaginatedWrite
+	}
+	if err := s.MockStub.DelState(key); err != nil {
+		return err
+	}
+	s.recordHistory(key, nil, true)
+	return nil
+}
+
+// recordHistory keeps the last write of every transaction to a key, like the peer history
+func (s *callerStub) recordHistory(key string, value []byte, isDelete bool) {
+	if s.history == nil {
+		s.history = map[string][]*queryresult.KeyModification{}
+	}
+	modification := &queryresult.KeyModification{TxId: s.TxID, Value: value, Timestamp: s.TxTimestamp, IsDelete: isDelete}
+	versions := s.history[key]
+	if len(versions) > 0 && versions[len(versions)-1].TxId == s.TxID {
+		versions[len(versions)-1] = modification
+		return
+	}
+	s.history[key] = append(versions, modification)
+}
+
+// historyIterator iterates over the recorded history of a key, oldest first
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (it *historyIterator) HasNext() bool {
+	return len(it.modifications) > 0
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if len(it.modifications) == 0 {
+		return nil, errors.New("no more results")
+	}
+	modification := it.modifications[0]
+	it.modifications = it.modifications[1:]
+	return modification, nil
+}
+
+func (it *historyIterator) Close() error {
+	return nil
+}
+
+// GetHistoryForKey emulates the peer history database, MockStub does not implement it
+func (s *callerStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{append([]*queryresult.KeyModification{}, s.history[key]...)}, nil
+}
+
+// newCreator returns a serialized identity with a freshly signed certificate
+// and its private key
+func newCreator(t testing.TB, mspID string, attrs map[string]string) ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "user@" + mspID, Organization: []string{mspID}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if attrs != nil {
+		attrsAsBytes, err := json.Marshal(map[string]map[string]string{"attrs": attrs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attrOID, Value: attrsAsBytes}}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creator, key
+}
+
+// kvIterator iterates over query results collected by the emulated queries below
+type kvIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *kvIterator) HasNext() bool {
+	return len(it.kvs) > 0
+}
+
+func (it *kvIterator) Next() (*queryresult.KV, error) {
+	if len(it.kvs) == 0 {
+		return nil, errors.New("no more results")
+	}
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+func (it *kvIterator) Close() error {
+	return nil
+}
+
+// page collects up to pageSize simple keys starting at bookmark that match.
+// The bookmark of the next page is the first key that did not fit.
+func (s *callerStub) page(bookmark string, pageSize int32, match func(value []byte) bool) (*kvIterator, *peer.QueryResponseMetadata) {
+	it := &kvIterator{}
+	next := ""
+	for elem := s.Keys.Front(); elem != nil; elem = elem.Next() {
+		key := elem.Value.(string)
+		// composite keys are not returned by range or rich queries on a peer
+		if strings.HasPrefix(key, "\x00") || key < bookmark || !match(s.State[key]) {
+			continue
+		}
+		if pageSize > 0 && int32(len(it.kvs)) == pageSize {
+			next = key
+			break
+		}
+		it.kvs = append(it.kvs, &queryresult.KV{Key: key, Value: s.State[key]})
+	}
+	return it, &peer.QueryResponseMetadata{FetchedRecordsCount: int32(len(it.kvs)), Bookmark: next}
+}
+
+// GetStateByRangeWithPagination emulates the peer, MockStub returns nil.
+// Like the peer it refuses the writes of the transaction from then on.
+func (s *callerStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	s.paginated = true
+	if bookmark == "" {
+		bookmark = startKey
+	}
+	it, metadata := s.page(bookmark, pageSize, func([]byte) bool { return true })
+	if endKey != "" {
+		kvs := it.kvs[:0]
+		for _, kv := range it.kvs {
+			if kv.Key < endKey {
+				kvs = append(kvs, kv)
+			}
+		}
+		it.kvs = kvs
+	}
+	return it, metadata, nil
+}
+
+// GetQueryResult emulates CouchDB for selectors of plain field equality
+func (s *callerStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	paginated := s.paginated
+	it, _, err := s.GetQueryResultWithPagination(query, 0, "")
+	s.paginated = paginated
+	return it, err
+}
+
+// GetQueryResultWithPagination emulates CouchDB for selectors of field equality,
+// field presence, $and, $or and $elemMatch
+func (s *callerStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	s.paginated = true
+	s.queries = append(s.queries, query)
+
+	parsed := struct {
+		Selector map[string]interface{} `json:"selector"`
+	}{}
+	if err := json.Unmarshal([]byte(query), &parsed); err != nil {
+		return nil, nil, err
+	}
+	// check the selector once, so unsupported operators fail the query
+	if _, err := matchSelector(map[string]interface{}{}, parsed.Selector); err != nil {
+		return nil, nil, err
+	}
+
+	it, metadata := s.page(bookmark, pageSize, func(value []byte) bool {
+		record := map[string]interface{}{}
+		if json.Unmarshal(value, &record) != nil {
+			return false
+		}
+		matched, _ := matchSelector(record, parsed.Selector)
+		return matched
+	})
+	return it, metadata, nil
+}
+
+// matchSelector reports whether record matches selector
+func matchSelector(record map[string]interface{}, selector map[string]interface{}) (bool, error) {
+	matched := true
+	for field, want := range selector {
+		var ok bool
+		var err error
+		switch field {
+		case "$and", "$or":
+			clauses, _ := want.([]interface{})
+			ok = field == "$and"
+			for _, clause := range clauses {
+				nested, _ := clause.(map[string]interface{})
+				clauseMatched, clauseErr := matchSelector(record, nested)
+				if clauseErr != nil {
+					return false, clauseErr
+				}
+				if field == "$and" {
+					ok = ok && clauseMatched
+				} else {
+					ok = ok || clauseMatched
+				}
+			}
+		default:
+			ok, err = matchField(record, field, want)
+		}
+		if err != nil {
+			return false, err
+		}
+		matched = matched && ok
+	}
+	return matched, nil
+}
+
+// matchField matches one field of a record against a value or an operator
+func matchField(record map[string]interface{}, field string, want interface{}) (bool, error) {
+	value, present := record[field]
+	operators, ok := want.(map[string]interface{})
+	if !ok {
+		return present && fmt.Sprint(value) == fmt.Sprint(want), nil
+	}
+	if len(operators) != 1 {
+		return false, fmt.Errorf("mock query engine supports one operator per field, got %s", field)
+	}
+	for operator, operand := range operators {
+		switch {
+		// {"$gt": null} matches every document that has the field
+		case operator == "$gt" && operand == nil:
+			return present, nil
+		case operator == "$exists":
+			return present == operand, nil
+		case operator == "$eq":
+			return present && fmt.Sprint(value) == fmt.Sprint(operand), nil
+		case operator == "$elemMatch":
+			elements, _ := value.([]interface{})
+			nested, _ := operand.(map[string]interface{})
+			for _, element := range elements {
+				object, _ := element.(map[string]interface{})
+				if matched, err := matchSelector(object, nested); err != nil || matched {
+					return matched, err
+				}
+			}
+			_, err := matchSelector(map[string]interface{}{}, nested)
+			return false, err
+		}
+		return false, fmt.Errorf("mock query engine does not support %s on %s", operator, field)
+	}
+	return false, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/imineev/cc1/events"
+)
+
+// modelSchemaVersion is the shape written by the current chaincode.
+// Records stored before the field existed decode with Schema_version 0.
+const modelSchemaVersion = 1
+
+// Model is the stored record of an uploaded model
+type Model struct {
+	ObjectType           string     `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Schema_version       int        `json:"schema_version"`
+	Model_id             string     `json:"model_id"`
+	Model_name           string     `json:"model_name"`
+	Model_version        string     `json:"model_version"`
+	Model_latest_version string     `json:"model_latest_version"` // newest published ModelVersion
+	Model_description    string     `json:"model_description"`
+	Model_framework      string     `json:"model_framework"`     // e.g. pytorch, tensorflow, onnx
+	Model_artifact_hash  string     `json:"model_artifact_hash"` // content hash of the model artifact
+	Model_artifact_uri   string     `json:"model_artifact_uri"`  // where the artifact is served off-chain
+	Model_license        string     `json:"model_license"`       // license terms
+	Model_tags           []string   `json:"model_tags"`
+	Upload_org           string     `json:"upload_org"` // owner org
+	Model_create_time    string     `json:"model_create_time"`
+	Model_update_time    string     `json:"model_update_time"`
+	Model_tombstone      *Tombstone `json:"model_tombstone,omitempty"` // set once the model is deleted
+}
+
+// parseTags splits a comma separated tag list, dropping empty entries
+func parseTags(tags string) []string {
+	result := []string{}
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// readModel returns the stored Model for model_id, or nil if none exists.
+// Models in the legacy shape are upgraded in memory.
+func readModel(APIstub shim.ChaincodeStubInterface, model_id string) (*Model, error) {
+	valAsbytes, err := APIstub.GetState(model_id)
+	if err != nil {
+		return nil, err
+	} else if valAsbytes == nil {
+		return nil, nil
+	}
+
+	Model := &Model{}
+	err = json.Unmarshal(valAsbytes, Model)
+	if err != nil {
+		return nil, err
+	}
+	upgradeModel(Model, model_id)
+	return Model, nil
+}
+
+// upgradeModel fills the fields a legacy record lost and reports whether it changed anything.
+// The old struct had unexported id and name fields, so json.Marshal never stored them.
+func upgradeModel(Model *Model, key string) bool {
+	if Model.Schema_version >= modelSchemaVersion {
+		return false
+	}
+
+	Model.Schema_version = modelSchemaVersion
+	if Model.Model_id == "" {
+		Model.Model_id = key
+	}
+	if Model.Model_tags == nil {
+		Model.Model_tags = []string{}
+	}
+	return true
+}
+
+// ===================================================================
+// migrateModels - rewrite models stored in the legacy shape
+//
+// args[0] optional maximum number of models to rewrite in this call
+// args[1] optional bookmark, the next key of the previous call
+// The response carries the number of migrated models and the key to
+// continue from when the limit was reached.
+// ===================================================================
+func (t *MAGNIT_CC) migrateModels(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) > 2 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting optional limit, bookmark"))
+	}
+	fields := make([]string, 2)
+	copy(fields, args)
+	limit := 0
+	if fields[0] != "" {
+		var err error
+		limit, err = strconv.Atoi(fields[0])
+		if err != nil || limit <= 0 {
+			return errorResponse(&FieldError{"limit", "must be a positive integer"})
+		}
+	}
+	// model keys are Model1, Model2, ... so they share the prefix
+	startKey := modelIDPrefix
+	if fields[1] != "" {
+		if !strings.HasPrefix(fields[1], modelIDPrefix) {
+			return errorResponse(&FieldError{"bookmark", "must be a model key returned as next"})
+		}
+		startKey = fields[1]
+	}
+
+	update_time, errTx := t.GetTxTimestampChannel(APIstub)
+	if errTx != nil {
+		return errorResponse(errTx)
+	}
+
+	resultsIterator, err := APIstub.GetStateByRange(startKey, modelIDPrefix+"~")
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	next := ""
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+
+		Model := &Model{}
+		if json.Unmarshal(queryResponse.Value, Model) != nil || Model.ObjectType != "model" {
+			continue
+		}
+		if !upgradeModel(Model, queryResponse.Key) {
+			continue
+		}
+		if limit > 0 && migrated == limit {
+			next = queryResponse.Key
+			break
+		}
+
+		Model.Model_update_time = update_time
+		ModelJSONasBytes, err := json.Marshal(Model)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = APIstub.PutState(queryResponse.Key, ModelJSONasBytes)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = indexModel(APIstub, nil, Model)
+		if err != nil {
+			return errorResponse(err)
+		}
+		migrated++
+	}
+
+	err = emitEvent(APIstub, &events.Event{Type: events.ModelsMigrated}, &events.MaintenanceDetails{Records: migrated, Next: next})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Printf("- migrateModels rewrote %d models\n", migrated)
+
+	result := struct {
+		Migrated int    `json:"migrated"`
+		Next     string `json:"next,omitempty"`
+	}{migrated, next}
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestInitModelMetadata(t *testing.T) {
+	stub := newCallerStub(t).as(t, "Org1MSP", nil)
+
+	expectOK(t, stub.invoke("tx1", "initmodel", "Resnet", "Org1MSP", "1.0.0", "image classifier", "pytorch", digest("abcd"), "s3://models/resnet", "MIT", "vision, cnn"))
+
+	res := stub.invoke("tx2", "queryByModel_id", "Model-tx1")
+	expectOK(t, res)
+
+	Model := Model{}
+	if err := json.Unmarshal(res.Payload, &Model); err != nil {
+		t.Fatal(err)
+	}
+	if Model.Model_id != "Model-tx1" || Model.Model_name != "Resnet" || Model.Model_framework != "pytorch" {
+		t.Fatalf("Unexpected model %+v", Model)
+	}
+	if Model.Schema_version != modelSchemaVersion || len(Model.Model_tags) != 2 || Model.Model_create_time == "" {
+		t.Fatalf("Unexpected model metadata %+v", Model)
+	}
+}
+
+func TestMigrateModels(t *testing.T) {
+	stub := newCallerStub(t)
+
+	// the shape json.Marshal produced for the old struct
+	stub.MockTransactionStart("legacy")
+	stub.PutState("Model1", []byte(`{"docType":"model","upload_org":"Org1MSP"}`))
+	stub.PutState("Model2", []byte(`{"docType":"model","upload_org":"Org2MSP"}`))
+	stub.PutState("Model3", []byte(`{"docType":"model","upload_org":"Org2MSP"}`))
+	stub.MockTransactionEnd("legacy")
+
+	expectDenied(t, stub.as(t, "Org1MSP", nil).invoke("tx1", "migrateModels"))
+
+	stub.as(t, "Org1MSP", adminAttrs)
+	res := stub.invoke("tx2", "migrateModels", "1")
+	expectOK(t, res)
+	if string(res.Payload) != `{"migrated":1,"next":"Model2"}` {
+		t.Fatalf("Unexpected migration result %s", res.Payload)
+	}
+	// the second page resumes at the bookmark
+	res = stub.invoke("tx3", "migrateModels", "1", "Model2")
+	expectOK(t, res)
+	if string(res.Payload) != `{"migrated":1,"next":"Model3"}` {
+		t.Fatalf("Unexpected migration result %s", res.Payload)
+	}
+	if strings.Contains(string(stub.State["Model3"]), "schema_version") {
+		t.Fatalf("Model3 was migrated before its page: %s", stub.State["Model3"])
+	}
+	res = stub.invoke("tx4", "migrateModels", "", "Model3")
+	expectOK(t, res)
+	if string(res.Payload) != `{"migrated":1}` {
+		t.Fatalf("Unexpected migration result %s", res.Payload)
+	}
+	expectFieldError(t, stub.invoke("tx5", "migrateModels", "1", "Agreement-tx1"), "bookmark")
+
+	Model := Model{}
+	if err := json.Unmarshal(stub.State["Model2"], &Model); err != nil {
+		t.Fatal(err)
+	}
+	if Model.Model_id != "Model2" || Model.Schema_version != modelSchemaVersion || Model.Upload_org != "Org2MSP" {
+		t.Fatalf("Model was not migrated %+v", Model)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/imineev/cc1/events"
+)
+
+// modelVersionObjectType prefixes the composite keys of version records
+const modelVersionObjectType = "ModelVersion"
+
+// latestVersion binds an Agreement to whatever version was published last
+const latestVersion = "latest"
+
+// ModelVersion is an immutable release of a Model's artifact
+type ModelVersion struct {
+	ObjectType          string `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Model_id            string `json:"model_id"`
+	Model_version       string `json:"model_version"`
+	Model_description   string `json:"model_description"`
+	Model_artifact_hash string `json:"model_artifact_hash"`
+	Model_artifact_uri  string `json:"model_artifact_uri"`
+	Upload_org          string `json:"upload_org"`
+	Publish_time        string `json:"publish_time"`
+	Publish_txid        string `json:"publish_txid"`
+}
+
+// readModelVersion returns the version record of model_id, or nil if it was never published
+func readModelVersion(APIstub shim.ChaincodeStubInterface, model_id string, version string) (*ModelVersion, error) {
+	versionKey, err := APIstub.CreateCompositeKey(modelVersionObjectType, []string{model_id, version})
+	if err != nil {
+		return nil, err
+	}
+	valAsbytes, err := APIstub.GetState(versionKey)
+	if err != nil {
+		return nil, err
+	} else if valAsbytes == nil {
+		return nil, nil
+	}
+
+	ModelVersion := &ModelVersion{}
+	err = json.Unmarshal(valAsbytes, ModelVersion)
+	if err != nil {
+		return nil, err
+	}
+	return ModelVersion, nil
+}
+
+// putModelVersion stores a new version record and refuses to overwrite an existing one
+func putModelVersion(APIstub shim.ChaincodeStubInterface, ModelVersion *ModelVersion) error {
+	versionKey, err := APIstub.CreateCompositeKey(modelVersionObjectType, []string{ModelVersion.Model_id, ModelVersion.Model_version})
+	if err != nil {
+		return err
+	}
+	valAsbytes, err := APIstub.GetState(versionKey)
+	if err != nil {
+		return err
+	} else if valAsbytes != nil {
+		return newError(codeAlreadyExists, fmt.Sprintf("Version %s of %s is already published", ModelVersion.Model_version, ModelVersion.Model_id))
+	}
+
+	ModelVersion.ObjectType = "modelVersion"
+	ModelVersion.Publish_txid = APIstub.GetTxID()
+	valAsbytes, err = json.Marshal(ModelVersion)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(versionKey, valAsbytes)
+}
+
+// resolveModelVersion returns the version record an Agreement binding points to.
+// A model without published versions resolves "latest" to the metadata on the model itself.
+func resolveModelVersion(APIstub shim.ChaincodeStubInterface, model_id string, binding string) (*ModelVersion, error) {
+	Model, err := readModel(APIstub, model_id)
+	if err != nil {
+		return nil, err
+	} else if Model == nil {
+		return nil, notFoundError("Model id does not exist: " + model_id)
+	} else if Model.Model_tombstone != nil {
+		return nil, stateError("Model " + model_id + " is deleted")
+	}
+
+	version := binding
+	if binding == "" || binding == latestVersion {
+		if Model.Model_latest_version == "" {
+			return &ModelVersion{
+				ObjectType:          "modelVersion",
+				Model_id:            Model.Model_id,
+				Model_version:       Model.Model_version,
+				Model_description:   Model.Model_description,
+				Model_artifact_hash: Model.Model_artifact_hash,
+				Model_artifact_uri:  Model.Model_artifact_uri,
+				Upload_org:          Model.Upload_org,
+				Publish_time:        Model.Model_create_time,
+			}, nil
+		}
+		version = Model.Model_latest_version
+	}
+
+	ModelVersion, err := readModelVersion(APIstub, model_id, version)
+	if err != nil {
+		return nil, err
+	} else if ModelVersion == nil {
+		return nil, notFoundError(fmt.Sprintf("Version %s of %s does not exist", version, model_id))
+	}
+	return ModelVersion, nil
+}
+
+// =====================================================================
+// publishModelVersion - add an immutable version to an existing model
+//
+// model_id
+// model_version
+// model_artifact_hash
+// model_artifact_uri
+// model_description - optional
+//
+// The fields can also be passed as a JSON object, see ModelVersionInput.
+// =====================================================================
+func (t *MAGNIT_CC) publishModelVersion(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	input, err := readModelVersionInput(APIstub, args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	model_id := input.Model_id
+
+	Model, err := readModel(APIstub, model_id)
+	if err != nil {
+		return errorResponse(err)
+	} else if Model == nil {
+		return errorResponse(notFoundError("Model id does not exist: " + model_id))
+	} else if Model.Model_tombstone != nil {
+		return errorResponse(stateError("Model " + model_id + " is deleted"))
+	}
+
+	publish_time, errTx := t.GetTxTimestampChannel(APIstub)
+	if errTx != nil {
+		return errorResponse(errTx)
+	}
+
+	err = putModelVersion(APIstub, &ModelVersion{
+		Model_id:            model_id,
+		Model_version:       input.Model_version,
+		Model_description:   input.Model_description,
+		Model_artifact_hash: input.Model_artifact_hash,
+		Model_artifact_uri:  input.Model_artifact_uri,
+		Upload_org:          Model.Upload_org,
+		Publish_time:        publish_time,
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// the model record tracks the newest release
+	Model.Model_version = input.Model_version
+	Model.Model_latest_version = input.Model_version
+	Model.Model_artifact_hash = input.Model_artifact_hash
+	Model.Model_artifact_uri = input.Model_artifact_uri
+	Model.Model_update_time = publish_time
+	ModelJSONasBytes, err := json.Marshal(Model)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = APIstub.PutState(model_id, ModelJSONasBytes)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(APIstub, &events.Event{Type: events.ModelVersionPublished, AssetID: model_id}, &events.ModelDetails{
+		UploadOrg:    Model.Upload_org,
+		ModelVersion: input.Model_version,
+		ArtifactHash: input.Model_artifact_hash,
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Printf("- published version %s of %s\n", input.Model_version, model_id)
+	return shim.Success(nil)
+}
+
+// =====================================================================
+// queryModelVersions - list every published version of a model
+// =====================================================================
+func (t *MAGNIT_CC) queryModelVersions(ctx *TransactionContext, model_id string) ([]ModelVersion, error) {
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(modelVersionObjectType, []string{model_id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	versions := []ModelVersion{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		version := ModelVersion{}
+		err = json.Unmarshal(queryResponse.Value, &version)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid model version %s: %s", queryResponse.Key, err.Error())
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
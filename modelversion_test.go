@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func expectModelVersion(t *testing.T, stub *callerStub, txID string, AgreementID string, version string) {
+	t.Helper()
+	res := stub.invoke(txID, "queryModelByAgreementID", AgreementID)
+	expectOK(t, res)
+
+	ModelVersion := ModelVersion{}
+	if err := json.Unmarshal(res.Payload, &ModelVersion); err != nil {
+		t.Fatal(err)
+	}
+	if ModelVersion.Model_version != version {
+		t.Fatalf("Expected version %s, got %s", version, ModelVersion.Model_version)
+	}
+}
+
+func TestModelVersionBinding(t *testing.T) {
+	stub := newCallerStub(t).as(t, "Org1MSP", nil)
+
+	expectOK(t, stub.invoke("tx1", "initmodel", "Resnet", "Org1MSP", "1.0", "", "pytorch", digest("aa"), "s3://resnet/1.0", "MIT", ""))
+	expectOK(t, stub.invoke("tx2", "insertAgreementinfo", "Pinned", "Model-tx1", "10", "Org1MSP", "Org2MSP", "", "", "", docHash, "1.0"))
+	expectOK(t, stub.invoke("tx3", "insertAgreementinfo", "Latest", "Model-tx1", "10", "Org1MSP", "Org2MSP", "", "", "", docHash))
+	if res := stub.invoke("tx4", "insertAgreementinfo", "Missing", "Model-tx1", "10", "Org1MSP", "Org2MSP", "", "", "", docHash, "9.9"); res.Status == shim.OK {
+		t.Fatalf("Expected binding to an unpublished version to fail")
+	}
+
+	expectOK(t, stub.invoke("tx5", "publishModelVersion", "Model-tx1", "2.0", digest("bb"), "s3://resnet/2.0"))
+	if res := stub.invoke("tx6", "publishModelVersion", "Model-tx1", "2.0", digest("cc"), "s3://resnet/2.0b"); res.Status == shim.OK {
+		t.Fatalf("Expected republishing a version to fail")
+	}
+	expectDenied(t, stub.as(t, "Org2MSP", nil).invoke("tx7", "publishModelVersion", "Model-tx1", "3.0", digest("dd"), "s3://resnet/3.0"))
+
+	for _, AgreementID := range []string{"Agreement-tx2", "Agreement-tx3"} {
+		expectOK(t, stub.as(t, "Org2MSP", nil).invoke("approve"+AgreementID, "approveAgreement", AgreementID))
+		expectOK(t, stub.as(t, "Org1MSP", nil).invoke("activate"+AgreementID, "activateAgreement", AgreementID))
+	}
+
+	stub.as(t, "Org2MSP", nil)
+	expectModelVersion(t, stub, "tx8", "Agreement-tx2", "1.0")
+	expectModelVersion(t, stub, "tx9", "Agreement-tx3", "2.0")
+
+	res := stub.invoke("tx10", "queryModelVersions", "Model-tx1")
+	expectOK(t, res)
+	versions := []ModelVersion{}
+	if err := json.Unmarshal(res.Payload, &versions); err != nil || len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %s", res.Payload)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/imineev/cc1/events"
+)
+
+// partyApprover is the role of a party that signs an Agreement without using the model
+const partyApprover = "approver"
+
+// Party is an organization taking part in an Agreement with one of the
+// roles issuer, participant or approver. Participants may use the model.
+type Party struct {
+	MSPID string `json:"msp_id"`
+	Role  string `json:"role"`
+}
+
+// Signature records the approval of an Agreement by one of its parties
+type Signature struct {
+	MSPID     string `json:"msp_id"`
+	Signer    string `json:"signer"` // identity of the submitter within the org
+	Sign_time string `json:"sign_time"`
+	TxID      string `json:"txid"`
+}
+
+// agreementParties returns the parties of an Agreement. Agreements without a
+// list of parties are between their issuer and participant.
+func agreementParties(Agreement *Agreement) []Party {
+	if len(Agreement.Agreement_parties) > 0 {
+		return Agreement.Agreement_parties
+	}
+	return []Party{{Agreement.Agreement_issuer, partyIssuer}, {Agreement.Agreement_participant, partyParticipant}}
+}
+
+// hasRole reports whether mspID is a party of the Agreement in one of the roles
+func hasRole(Agreement *Agreement, mspID string, roles ...string) bool {
+	for _, party := range agreementParties(Agreement) {
+		if party.MSPID != mspID {
+			continue
+		}
+		for _, role := range roles {
+			if party.Role == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isParty reports whether mspID is a party of the Agreement in any role
+func isParty(Agreement *Agreement, mspID string) bool {
+	return hasRole(Agreement, mspID, partyIssuer, partyParticipant, partyApprover)
+}
+
+// signatureThreshold is the number of signatures activating an Agreement,
+// all parties have to sign unless the Agreement sets a lower threshold
+func signatureThreshold(Agreement *Agreement) int {
+	if Agreement.Agreement_signature_threshold > 0 {
+		return Agreement.Agreement_signature_threshold
+	}
+	return len(agreementParties(Agreement))
+}
+
+// validParties checks the parties and signature threshold of a new Agreement
+func validParties(issuer string, participant string, parties []Party, threshold int) error {
+	if len(parties) == 0 {
+		if threshold != 0 {
+			return &FieldError{"Agreement_signature_threshold", "requires Agreement_parties"}
+		}
+		return nil
+	}
+
+	listed := map[string]bool{}
+	issuers := 0
+	for _, party := range parties {
+		if party.MSPID == "" {
+			return &FieldError{"Agreement_parties", "msp_id must not be empty"}
+		}
+		if listed[party.MSPID] {
+			return &FieldError{"Agreement_parties", party.MSPID + " is listed twice"}
+		}
+		listed[party.MSPID] = true
+
+		switch party.Role {
+		case partyIssuer:
+			if party.MSPID != issuer {
+				return &FieldError{"Agreement_parties", "the issuer party must be Agreement_issuer " + issuer}
+			}
+			issuers++
+		case partyParticipant, partyApprover:
+		default:
+			return &FieldError{"Agreement_parties", "role must be issuer, participant or approver, got " + party.Role}
+		}
+	}
+	if issuers != 1 {
+		return &FieldError{"Agreement_parties", "must list Agreement_issuer as the one issuer"}
+	}
+	isParticipant := false
+	for _, party := range parties {
+		isParticipant = isParticipant || (party.MSPID == participant && party.Role == partyParticipant)
+	}
+	if !isParticipant {
+		return &FieldError{"Agreement_parties", "must list Agreement_participant " + participant + " as a participant"}
+	}
+	if threshold < 0 || threshold > len(parties) {
+		return &FieldError{"Agreement_signature_threshold", fmt.Sprintf("must be between 1 and the %d parties", len(parties))}
+	}
+	return nil
+}
+
+// checkModelUsable refuses to put an Agreement of a deleted model into use
+func checkModelUsable(APIstub shim.ChaincodeStubInterface, Agreement *Agreement) error {
+	Model, err := readModel(APIstub, Agreement.Agreement_model_id)
+	if err != nil {
+		return err
+	} else if Model == nil || Model.Model_tombstone != nil {
+		return stateError("Model " + Agreement.Agreement_model_id + " of " + Agreement.AgreementID + " is deleted")
+	}
+	return nil
+}
+
+// ===========================================================================
+// signAgreement - record the signature of the submitter's organization on a
+// proposed Agreement
+//
+// args[0] AgreementID
+//
+// The Agreement becomes active with the signature that reaches its threshold.
+// ===========================================================================
+func (t *MAGNIT_CC) signAgreement(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting AgreementID"))
+	}
+	AgreementID := args[0]
+
+	Agreement, err := readAgreement(APIstub, AgreementID)
+	if err != nil {
+		return errorResponse(err)
+	} else if Agreement == nil {
+		return errorResponse(notFoundError("Agreement not exist"))
+	}
+	if Agreement.Agreement_tombstone != nil {
+		return errorResponse(stateError("Agreement " + AgreementID + " is deleted"))
+	}
+	if Agreement.Agreement_status != StatusProposed {
+		return errorResponse(stateError("Agreement " + AgreementID + " is " + Agreement.Agreement_status + ", only proposed agreements can be signed"))
+	}
+
+	caller, err := getCaller(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	for _, signature := range Agreement.Agreement_signatures {
+		if signature.MSPID == caller.MSPID {
+			return errorResponse(newError(codeAlreadyExists, "Agreement "+AgreementID+" is already signed by "+caller.MSPID))
+		}
+	}
+
+	sign_time, errTx := t.GetTxTimestampChannel(APIstub)
+	if errTx != nil {
+		return errorResponse(errTx)
+	}
+
+	Agreement.Agreement_signatures = append(Agreement.Agreement_signatures, Signature{caller.MSPID, caller.ID, sign_time, APIstub.GetTxID()})
+	threshold := signatureThreshold(Agreement)
+	event := &events.Event{Type: events.AgreementSigned, AssetID: AgreementID}
+
+	if len(Agreement.Agreement_signatures) >= threshold {
+		err = checkModelUsable(APIstub, Agreement)
+		if err != nil {
+			return errorResponse(err)
+		}
+		event.PreviousStatus = Agreement.Agreement_status
+		event.NewStatus = StatusActive
+		err = setAgreementStatus(APIstub, Agreement, StatusActive, sign_time)
+	} else {
+		Agreement.Agreement_update_time = sign_time
+		var AgreementJSONasBytes []byte
+		AgreementJSONasBytes, err = json.Marshal(Agreement)
+		if err == nil {
+			err = APIstub.PutState(AgreementID, AgreementJSONasBytes)
+		}
+	}
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(APIstub, event, &events.SignatureDetails{
+		Signer:     caller.MSPID,
+		Signatures: len(Agreement.Agreement_signatures),
+		Threshold:  threshold,
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Printf("- %s signed %s, %d of %d signatures\n", caller.MSPID, AgreementID, len(Agreement.Agreement_signatures), threshold)
+
+	AgreementJSONasBytes, err := json.Marshal(Agreement)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(AgreementJSONasBytes)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/imineev/cc1/events"
+)
+
+const consortiumAgreement = `{"Agreement_model_id":"Model-tx1","Agreement_model_account_use":10,"Agreement_issuer":"Org1MSP",` +
+	`"Agreement_parties":[{"msp_id":"Org1MSP","role":"issuer"},{"msp_id":"Org2MSP","role":"participant"},{"msp_id":"Org3MSP","role":"approver"}],` +
+	`"Agreement_signature_threshold":2}`
+
+func TestMultiPartySigning(t *testing.T) {
+	stub := setupAgreement(t)
+
+	res := stub.as(t, "Org1MSP", nil).invoke("tx3", "insertAgreementinfo", consortiumAgreement)
+	expectOK(t, res)
+	Agreement := &Agreement{}
+	json.Unmarshal(res.Payload, Agreement)
+	if Agreement.Agreement_participant != "Org2MSP" || len(Agreement.Agreement_parties) != 3 {
+		t.Fatalf("Unexpected agreement %s", res.Payload)
+	}
+
+	expectOK(t, stub.as(t, "Org3MSP", nil).invoke("sign1", "signAgreement", "Agreement-tx3"))
+	details := events.SignatureDetails{}
+	event := lastEvent(t, stub, events.AgreementSigned)
+	if err := event.DecodeDetails(&details); err != nil || details.Signer != "Org3MSP" || details.Signatures != 1 || details.Threshold != 2 || event.NewStatus != "" {
+		t.Fatalf("Unexpected signature event %+v %+v: %v", event, details, err)
+	}
+
+	expectFailure(t, stub.invoke("sign2", "signAgreement", "Agreement-tx3"))
+	expectDenied(t, stub.as(t, "Org4MSP", nil).invoke("sign3", "signAgreement", "Agreement-tx3"))
+	expectFailure(t, stub.as(t, "Org2MSP", nil).invoke("approve", "approveAgreement", "Agreement-tx3"))
+
+	res = stub.invoke("sign4", "signAgreement", "Agreement-tx3")
+	expectOK(t, res)
+	json.Unmarshal(res.Payload, Agreement)
+	if Agreement.Agreement_status != StatusActive || len(Agreement.Agreement_signatures) != 2 {
+		t.Fatalf("Threshold did not activate the agreement: %s", res.Payload)
+	}
+	if event = lastEvent(t, stub, events.AgreementSigned); event.PreviousStatus != StatusProposed || event.NewStatus != StatusActive {
+		t.Fatalf("Activation is missing from the event %+v", event)
+	}
+	expectFailure(t, stub.as(t, "Org1MSP", nil).invoke("sign5", "signAgreement", "Agreement-tx3"))
+
+	// approvers sign and read, only participants use the model
+	expectDenied(t, stub.as(t, "Org3MSP", nil).invoke("use1", "consumeModelUsage", "Agreement-tx3", "1"))
+	expectOK(t, stub.invoke("q1", "queryUsage", "Agreement-tx3"))
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke("use2", "consumeModelUsage", "Agreement-tx3", "1"))
+
+	res = stub.as(t, "Org3MSP", nil).invoke("q2", "queryAgreementsByParticipant", "Org3MSP")
+	expectOK(t, res)
+	if !strings.Contains(string(res.Payload), "Agreement-tx3") {
+		t.Fatalf("Approver cannot find the agreement: %s", res.Payload)
+	}
+}
+
+func TestTwoPartySigning(t *testing.T) {
+	stub := setupAgreement(t)
+
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("sign1", "signAgreement", "Agreement-tx2"))
+	res := stub.as(t, "Org2MSP", nil).invoke("sign2", "signAgreement", "Agreement-tx2")
+	expectOK(t, res)
+	Agreement := &Agreement{}
+	json.Unmarshal(res.Payload, Agreement)
+	if Agreement.Agreement_status != StatusActive {
+		t.Fatalf("Both signatures did not activate the agreement: %s", res.Payload)
+	}
+}
+
+func TestPartiesValidation(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", nil)
+	agreement := func(parties string, threshold string) string {
+		return `{"Agreement_model_id":"Model-tx1","Agreement_model_account_use":10,"Agreement_issuer":"Org1MSP","Agreement_parties":` + parties + `,"Agreement_signature_threshold":` + threshold + `}`
+	}
+
+	expectFieldError(t, stub.invoke("tx3", "insertAgreementinfo", agreement(`[{"msp_id":"Org2MSP","role":"participant"}]`, "0")), "Agreement_parties")
+	expectFieldError(t, stub.invoke("tx4", "insertAgreementinfo", agreement(`[{"msp_id":"Org1MSP","role":"issuer"},{"msp_id":"Org2MSP","role":"participant"},{"msp_id":"Org2MSP","role":"approver"}]`, "0")), "Agreement_parties")
+	expectFieldError(t, stub.invoke("tx5", "insertAgreementinfo", agreement(`[{"msp_id":"Org1MSP","role":"issuer"},{"msp_id":"Org2MSP","role":"participant"},{"msp_id":"Org3MSP","role":"owner"}]`, "0")), "Agreement_parties")
+	expectFieldError(t, stub.invoke("tx6", "insertAgreementinfo", agreement(`[{"msp_id":"Org1MSP","role":"issuer"},{"msp_id":"Org2MSP","role":"approver"}]`, "0")), "Agreement_participant")
+	expectFieldError(t, stub.invoke("tx7", "insertAgreementinfo", agreement(`[{"msp_id":"Org1MSP","role":"issuer"},{"msp_id":"Org2MSP","role":"participant"}]`, "3")), "Agreement_signature_threshold")
+	expectFieldError(t, stub.invoke("tx8", "insertAgreementinfo", `{"Agreement_model_id":"Model-tx1","Agreement_model_account_use":10,"Agreement_issuer":"Org1MSP","Agreement_participant":"Org2MSP","Agreement_signature_threshold":1}`), "Agreement_signature_threshold")
+
+	// a pair collection cannot hold the terms of a consortium
+	input := strings.Replace(consortiumAgreement, `"Agreement_model_account_use":10,`, "", 1)
+	expectFailure(t, stub.withTransient(map[string][]byte{agreementTermsTransientKey: []byte(privateTerms)}).invoke("tx9", "insertAgreementinfo", input))
+}
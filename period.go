@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Periods of a period quota, every window starts at midnight UTC
+const (
+	periodDay   = "day"
+	periodWeek  = "week" // ISO week, starting on Monday
+	periodMonth = "month"
+)
+
+// usagePeriodObjectType prefixes the compacted usage of an Agreement per window
+const usagePeriodObjectType = "UsagePeriod"
+
+// periodDateLayout formats the start of a window in its key
+const periodDateLayout = "2006-01-02"
+
+// PeriodQuota limits the units of an Agreement consumed in each window of a period
+type PeriodQuota struct {
+	Period string `json:"period"`
+	Limit  Count  `json:"limit"`
+}
+
+// PeriodUsage is the compacted consumption of an Agreement in one window.
+// A new window starts from a new key, so quotas roll over without a reset
+// transaction and past windows stay as history.
+type PeriodUsage struct {
+	ObjectType  string `json:"docType"`
+	AgreementID string `json:"AgreementID"`
+	Period      string `json:"period"`
+	Start       string `json:"start"`
+	Count       Count  `json:"count"`
+}
+
+// PeriodRemaining reports a period quota of an Agreement in the current window
+type PeriodRemaining struct {
+	Period    string `json:"period"`
+	Start     string `json:"start"`
+	Resets_at string `json:"resets_at"`
+	Limit     int    `json:"limit"`
+	Count     int    `json:"count"`
+	Remaining int    `json:"remaining"`
+}
+
+// usageWindow identifies one window of a period
+type usageWindow struct {
+	Period string
+	Start  string
+}
+
+// periodWindow returns the start and end of the window of period holding now
+func periodWindow(period string, now time.Time) (time.Time, time.Time, error) {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case periodDay:
+		return day, day.AddDate(0, 0, 1), nil
+	case periodWeek:
+		start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7), nil
+	case periodMonth:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("Unknown quota period %q", period)
+}
+
+// validPeriodQuotas checks that every period is known and limited once
+func validPeriodQuotas(field string, quotas []PeriodQuota) error {
+	limited := map[string]bool{}
+	for _, quota := range quotas {
+		if _, _, err := periodWindow(quota.Period, time.Time{}); err != nil {
+			return &FieldError{field, "period must be day, week or month, got " + quota.Period}
+		}
+		if limited[quota.Period] {
+			return &FieldError{field, "period " + quota.Period + " is limited twice"}
+		}
+		limited[quota.Period] = true
+		if err := validQuota(field, quota.Limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPeriodUsage returns the compacted usage of an Agreement in a window,
+// a window nothing was compacted into yet is empty
+func readPeriodUsage(APIstub shim.ChaincodeStubInterface, AgreementID string, window usageWindow) (*PeriodUsage, string, error) {
+	periodKey, err := APIstub.CreateCompositeKey(usagePeriodObjectType, []string{AgreementID, window.Period, window.Start})
+	if err != nil {
+		return nil, "", err
+	}
+	periodAsBytes, err := APIstub.GetState(periodKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	periodUsage := &PeriodUsage{"periodUsage", AgreementID, window.Period, window.Start, 0}
+	if periodAsBytes != nil {
+		err = json.Unmarshal(periodAsBytes, periodUsage)
+		if err != nil {
+			return nil, "", fmt.Errorf("Invalid period usage %s: %s", periodKey, err.Error())
+		}
+	}
+	return periodUsage, periodKey, nil
+}
+
+// currentPeriods reports every period quota of an Agreement in the window
+// holding now, counting the compacted usage plus the pending units given
+func currentPeriods(APIstub shim.ChaincodeStubInterface, Agreement *Agreement, now time.Time, pending map[usageWindow]int) ([]PeriodRemaining, error) {
+	periods := []PeriodRemaining{}
+	for _, quota := range Agreement.Agreement_period_quotas {
+		start, end, err := periodWindow(quota.Period, now)
+		if err != nil {
+			return nil, fmt.Errorf("Agreement %s has an invalid period quota: %s", Agreement.AgreementID, err.Error())
+		}
+		window := usageWindow{quota.Period, start.Format(periodDateLayout)}
+		periodUsage, _, err := readPeriodUsage(APIstub, Agreement.AgreementID, window)
+		if err != nil {
+			return nil, err
+		}
+
+		count := int(periodUsage.Count) + pending[window]
+		remaining := int(quota.Limit) - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		periods = append(periods, PeriodRemaining{quota.Period, window.Start, end.Format(time.RFC3339), int(quota.Limit), count, remaining})
+	}
+	return periods, nil
+}
+
+// checkPeriodQuotas refuses units that would exceed a period quota in the
+// current window. Like the lifetime quota it is checked against the
+// compacted usage, see consume.
+func checkPeriodQuotas(AgreementID string, periods []PeriodRemaining, units int) ([]PeriodRemaining, error) {
+	for i, period := range periods {
+		if period.Count+units > period.Limit {
+			return nil, &UsageError{
+				Message:      "Usage quota of " + AgreementID + " per " + period.Period + " is exhausted until " + period.Resets_at,
+				Code:         codePeriodQuotaExhausted,
+				AgreementID:  AgreementID,
+				Quota:        period.Limit,
+				CurrentCount: period.Count,
+				Requested:    units,
+				Period:       period.Period,
+				Resets_at:    period.Resets_at,
+			}
+		}
+		periods[i].Count += units
+		periods[i].Remaining -= units
+	}
+	return periods, nil
+}
+
+// compactPeriods folds the pending units of every window into its period usage
+func compactPeriods(APIstub shim.ChaincodeStubInterface, AgreementID string, pending map[usageWindow]int) error {
+	for window, units := range pending {
+		periodUsage, periodKey, err := readPeriodUsage(APIstub, AgreementID, window)
+		if err != nil {
+			return err
+		}
+		periodUsage.Count += Count(units)
+
+		periodAsBytes, err := json.Marshal(periodUsage)
+		if err != nil {
+			return err
+		}
+		err = APIstub.PutState(periodKey, periodAsBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
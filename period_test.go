@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPeriodWindow(t *testing.T) {
+	now := time.Date(2026, 10, 18, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*3600)) // Monday 01:30 UTC
+	expected := map[string][2]string{
+		periodDay:   {"2026-10-19", "2026-10-20"},
+		periodWeek:  {"2026-10-19", "2026-10-26"},
+		periodMonth: {"2026-10-01", "2026-11-01"},
+	}
+	for period, window := range expected {
+		start, end, err := periodWindow(period, now)
+		if err != nil || start.Format(periodDateLayout) != window[0] || end.Format(periodDateLayout) != window[1] {
+			t.Fatalf("Unexpected %s window %s - %s: %v", period, start, end, err)
+		}
+	}
+
+	start, end, _ := periodWindow(periodWeek, time.Date(2026, 12, 31, 12, 0, 0, 0, time.UTC))
+	if start.Format(periodDateLayout) != "2026-12-28" || end.Format(periodDateLayout) != "2027-01-04" {
+		t.Fatalf("Unexpected week across the year end %s - %s", start, end)
+	}
+	if _, _, err := periodWindow("year", now); err == nil {
+		t.Fatalf("Expected an unknown period to fail")
+	}
+}
+
+func TestPeriodQuotaInput(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", nil)
+	agreement := func(quotas string) string {
+		return `{"Agreement_model_id":"Model-tx1","Agreement_issuer":"Org1MSP","Agreement_participant":"Org2MSP","Agreement_period_quotas":` + quotas + `}`
+	}
+
+	expectFieldError(t, stub.invoke("tx3", "insertAgreementinfo", agreement(`[{"period":"year","limit":1}]`)), "Agreement_period_quotas")
+	expectFieldError(t, stub.invoke("tx4", "insertAgreementinfo", agreement(`[{"period":"day","limit":1},{"period":"day","limit":2}]`)), "Agreement_period_quotas")
+	expectFieldError(t, stub.invoke("tx5", "insertAgreementinfo", agreement(`[{"period":"day","limit":-1}]`)), "Agreement_period_quotas")
+
+	res := stub.invoke("tx6", "insertAgreementinfo", agreement(`[{"period":"month","limit":"100"}]`))
+	expectOK(t, res)
+	Agreement := &Agreement{}
+	json.Unmarshal(res.Payload, Agreement)
+	if Agreement.Agreement_model_count_use != maxAgreementQuota || len(Agreement.Agreement_period_quotas) != 1 || Agreement.Agreement_period_quotas[0].Limit != 100 {
+		t.Fatalf("Unexpected agreement %s", res.Payload)
+	}
+}
+
+func TestPeriodQuotaConsumption(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", nil)
+	input := `{"Agreement_model_id":"Model-tx1","Agreement_model_account_use":100,"Agreement_issuer":"Org1MSP","Agreement_participant":"Org2MSP","Agreement_period_quotas":[{"period":"day","limit":3},{"period":"month","limit":50}]}`
+	expectOK(t, stub.invoke("tx3", "insertAgreementinfo", input))
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke("tx4", "approveAgreement", "Agreement-tx3"))
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("tx5", "activateAgreement", "Agreement-tx3"))
+
+	// usage of a past window does not count against the current one
+	start, _, _ := periodWindow(periodDay, time.Now())
+	yesterday := usageWindow{periodDay, start.AddDate(0, 0, -1).Format(periodDateLayout)}
+	stub.MockTransactionStart("past")
+	compactPeriods(stub, "Agreement-tx3", map[usageWindow]int{yesterday: 3})
+	stub.MockTransactionEnd("past")
+
+	stub.as(t, "Org2MSP", nil)
+	res := stub.invoke("use1", "consumeModelUsage", "Agreement-tx3", "2")
+	expectOK(t, res)
+	consumption := Consumption{}
+	json.Unmarshal(res.Payload, &consumption)
+	if len(consumption.Periods) != 2 || consumption.Periods[0].Remaining != 1 || consumption.Periods[1].Remaining != 48 || consumption.Remaining != 98 {
+		t.Fatalf("Unexpected consumption %s", res.Payload)
+	}
+
+	expectOK(t, stub.invoke("compact", "compactUsage", "Agreement-tx3"))
+	expectUsageError(t, stub.invoke("use2", "consumeModelUsage", "Agreement-tx3", "2"), codePeriodQuotaExhausted)
+	expectOK(t, stub.invoke("use3", "consumeModelUsage", "Agreement-tx3", "1"))
+
+	res = stub.invoke("q1", "queryUsage", "Agreement-tx3")
+	expectOK(t, res)
+	usage := Usage{}
+	json.Unmarshal(res.Payload, &usage)
+	if len(usage.Periods) != 2 || usage.Periods[0].Count != 3 || usage.Periods[0].Remaining != 0 || usage.Periods[1].Count != 3 || usage.Total != 3 {
+		t.Fatalf("Unexpected usage %s", res.Payload)
+	}
+
+	// the unit of use3 counts against the window once compacted
+	expectOK(t, stub.invoke("compact2", "compactUsage", "Agreement-tx3"))
+	res = stub.invoke("use4", "consumeModelUsage", "Agreement-tx3", "1")
+	usageErr := UsageError{}
+	errorDetails(t, expectError(t, res, codePeriodQuotaExhausted), &usageErr)
+	if usageErr.Period != periodDay || usageErr.Resets_at == "" || usageErr.CurrentCount != 3 {
+		t.Fatalf("Unexpected refusal %s", res.Message)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// agreementTermsTransientKey is the transient map entry carrying the private terms
+const agreementTermsTransientKey = "agreement_terms"
+
+// minSaltLength keeps the public terms hash from being brute-forced
+const minSaltLength = 16
+
+// AgreementTerms are the commercially sensitive terms of an Agreement. They
+// are stored in the private data collection of the issuer and participant,
+// the public Agreement only carries Agreement_terms_hash.
+type AgreementTerms struct {
+	ObjectType                string        `json:"docType"`
+	AgreementID               string        `json:"AgreementID"`
+	Agreement_price           string        `json:"Agreement_price"`
+	Agreement_model_count_use Count         `json:"Agreement_model_account_use"` // usage quota
+	Agreement_period_quotas   []PeriodQuota `json:"Agreement_period_quotas,omitempty"`
+	Agreement_remark          string        `json:"Agreement_remark"`
+	Agreement_hash            string        `json:"Agreement_hash"`
+	Salt                      string        `json:"salt"` // chosen by the client, never derived on the peer
+}
+
+// AgreementView is an Agreement with its private terms filled in
+type AgreementView struct {
+	*Agreement
+	Agreement_price string `json:"Agreement_price"`
+}
+
+// agreementCollection names the private data collection of two organizations.
+// The pair is sorted so both sides resolve the same collection.
+func agreementCollection(org1 string, org2 string) string {
+	if org2 < org1 {
+		org1, org2 = org2, org1
+	}
+	return "agreement_" + org1 + "_" + org2
+}
+
+// channelOrgsObjectType keys the MSP IDs of the channel organizations, set by
+// Init. collections_config.json defines the collection of every pair of them,
+// both change together with an upgrade when an organization joins.
+const channelOrgsObjectType = "ChannelOrgs"
+
+// readChannelOrgs returns the MSP IDs of the channel organizations
+func readChannelOrgs(APIstub shim.ChaincodeStubInterface) ([]string, error) {
+	orgsKey, err := APIstub.CreateCompositeKey(channelOrgsObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	orgsAsBytes, err := APIstub.GetState(orgsKey)
+	if err != nil || orgsAsBytes == nil {
+		return nil, err
+	}
+	orgs := []string{}
+	err = json.Unmarshal(orgsAsBytes, &orgs)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid channel organizations: %s", err.Error())
+	}
+	return orgs, nil
+}
+
+// putChannelOrgs configures the MSP IDs of the channel organizations
+func putChannelOrgs(APIstub shim.ChaincodeStubInterface, orgs []string) error {
+	orgsKey, err := APIstub.CreateCompositeKey(channelOrgsObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	orgsAsBytes, err := json.Marshal(orgs)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(orgsKey, orgsAsBytes)
+}
+
+// pairCollection returns the collection of the Agreement parties, refusing
+// parties without one
+func pairCollection(APIstub shim.ChaincodeStubInterface, org1 string, org2 string) (string, error) {
+	orgs, err := readChannelOrgs(APIstub)
+	if err != nil {
+		return "", err
+	}
+	configured := map[string]bool{}
+	for _, org := range orgs {
+		configured[org] = true
+	}
+	collection := agreementCollection(org1, org2)
+	if org1 == org2 || !configured[org1] || !configured[org2] {
+		return "", newError(codeNotSupported, "No private data collection "+collection+" is configured, "+org1+" and "+org2+" must both be channel organizations of Init and collections_config.json")
+	}
+	return collection, nil
+}
+
+// termsHash is the salted digest of the stored terms kept on the public ledger.
+// It covers the stored bytes, so re-encoding the terms never changes it.
+func termsHash(termsAsBytes []byte) string {
+	digest := sha256.Sum256(termsAsBytes)
+	return hex.EncodeToString(digest[:])
+}
+
+// transientTerms reads the private terms of a new Agreement from the transient map.
+// It returns nil when the proposal carries no terms.
+func transientTerms(APIstub shim.ChaincodeStubInterface) (*AgreementTerms, error) {
+	transientMap, err := APIstub.GetTransient()
+	if err != nil {
+		return nil, err
+	}
+	termsAsBytes, ok := transientMap[agreementTermsTransientKey]
+	if !ok {
+		return nil, nil
+	}
+
+	// invalid fields are named by their path in the transient map
+	v := &validator{prefix: agreementTermsTransientKey + "."}
+	terms := &AgreementTerms{}
+	err = decodeInput(termsAsBytes, terms)
+	if _, ok := err.(*FieldError); ok {
+		v.check(err)
+		return nil, v.err()
+	} else if err != nil {
+		return nil, &FieldError{agreementTermsTransientKey, "must be a single JSON object"}
+	}
+	v.maxLength("Agreement_price", terms.Agreement_price, maxTextLength)
+	v.check(validQuota("Agreement_model_account_use", terms.Agreement_model_count_use))
+	v.check(validPeriodQuotas("Agreement_period_quotas", terms.Agreement_period_quotas))
+	v.maxLength("Agreement_remark", terms.Agreement_remark, maxTextLength)
+	v.hash("Agreement_hash", &terms.Agreement_hash)
+	if len(terms.Salt) < minSaltLength {
+		v.fail("salt", fmt.Sprintf("must have at least %d characters", minSaltLength))
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return terms, nil
+}
+
+// putAgreementTerms stores the terms in the collection of the Agreement parties
+// and replaces the public copies of the terms with their salted hash
+func putAgreementTerms(APIstub shim.ChaincodeStubInterface, Agreement *Agreement, terms *AgreementTerms) error {
+	terms.ObjectType = "agreementTerms"
+	terms.AgreementID = Agreement.AgreementID
+
+	termsAsBytes, err := json.Marshal(terms)
+	if err != nil {
+		return err
+	}
+
+	collection, err := pairCollection(APIstub, Agreement.Agreement_issuer, Agreement.Agreement_participant)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutPrivateData(collection, Agreement.AgreementID, termsAsBytes)
+	if err != nil {
+		return err
+	}
+
+	Agreement.Agreement_model_count_use = 0
+	Agreement.Agreement_period_quotas = nil
+	Agreement.Agreement_remark = ""
+	Agreement.Agreement_hash = ""
+	Agreement.Agreement_collection = collection
+	Agreement.Agreement_terms_hash = termsHash(termsAsBytes)
+	return nil
+}
+
+// readAgreementTerms returns the stored private terms of an Agreement after
+// checking them against the public hash. Only peers of the two parties hold them.
+func readAgreementTerms(APIstub shim.ChaincodeStubInterface, Agreement *Agreement) (*AgreementTerms, []byte, error) {
+	if Agreement.Agreement_collection == "" {
+		return nil, nil, notFoundError("Agreement " + Agreement.AgreementID + " has no private terms")
+	}
+
+	termsAsBytes, err := APIstub.GetPrivateData(Agreement.Agreement_collection, Agreement.AgreementID)
+	if err != nil {
+		return nil, nil, err
+	} else if termsAsBytes == nil {
+		return nil, nil, newError(codeUnavailable, "Private terms of "+Agreement.AgreementID+" are not available on this peer")
+	}
+	if termsHash(termsAsBytes) != Agreement.Agreement_terms_hash {
+		return nil, nil, errors.New("Private terms of " + Agreement.AgreementID + " do not match the public hash")
+	}
+
+	terms := &AgreementTerms{}
+	err = json.Unmarshal(termsAsBytes, terms)
+	if err != nil {
+		return nil, nil, err
+	}
+	return terms, termsAsBytes, nil
+}
+
+// withTerms returns a copy of the Agreement with its private terms filled in.
+// Agreements stored before the collections existed are returned unchanged.
+// The copy must never be written back to the world state.
+func withTerms(APIstub shim.ChaincodeStubInterface, Agreement *Agreement) (*AgreementView, error) {
+	if Agreement.Agreement_collection == "" {
+		return &AgreementView{Agreement: Agreement}, nil
+	}
+
+	terms, _, err := readAgreementTerms(APIstub, Agreement)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := *Agreement
+	merged.Agreement_model_count_use = terms.Agreement_model_count_use
+	merged.Agreement_period_quotas = terms.Agreement_period_quotas
+	merged.Agreement_remark = terms.Agreement_remark
+	merged.Agreement_hash = terms.Agreement_hash
+	return &AgreementView{&merged, terms.Agreement_price}, nil
+}
+
+// ===========================================================================
+// queryAgreementTerms - private terms of an Agreement as stored, the sha256
+// of the response is the public Agreement_terms_hash
+// ===========================================================================
+func (t *MAGNIT_CC) queryAgreementTerms(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting AgreementID"))
+	}
+
+	Agreement, err := readAgreement(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	} else if Agreement == nil {
+		return errorResponse(notFoundError("Agreement not exist"))
+	}
+
+	_, termsAsBytes, err := readAgreementTerms(APIstub, Agreement)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(termsAsBytes)
+}
+
+// ===========================================================================
+// queryPrivateAgreement - an Agreement as its parties see it, with the
+// private terms filled in
+// ===========================================================================
+func (t *MAGNIT_CC) queryPrivateAgreement(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting AgreementID"))
+	}
+
+	Agreement, err := readAgreement(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	} else if Agreement == nil {
+		return errorResponse(notFoundError("Agreement not exist"))
+	}
+
+	view, err := withTerms(APIstub, Agreement)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	viewAsBytes, err := json.Marshal(view)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(viewAsBytes)
+}
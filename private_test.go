@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// privateDocDigest is the sha512 document digest in privateTerms
+var privateDocDigest = strings.Repeat("cd", 64)
+
+var privateTerms = `{"Agreement_price":"1200 EUR","Agreement_model_account_use":"2","Agreement_remark":"net 30","Agreement_hash":"sha512:` + privateDocDigest + `","salt":"0123456789abcdef"}`
+
+// setupPrivateAgreement stores Agreement-tx3 of Model-tx1 with private terms
+func setupPrivateAgreement(t *testing.T) *callerStub {
+	stub := setupAgreement(t)
+
+	stub.as(t, "Org1MSP", nil).withTransient(map[string][]byte{agreementTermsTransientKey: []byte(privateTerms)})
+	expectOK(t, stub.invoke("tx3", "insertAgreementinfo", "Private", "Model-tx1", "", "Org1MSP", "Org2MSP", "", "http://image", "", ""))
+	return stub
+}
+
+func TestPrivateTermsStayOffLedger(t *testing.T) {
+	stub := setupPrivateAgreement(t)
+
+	public := string(stub.State["Agreement-tx3"])
+	for _, secret := range []string{"1200 EUR", "net 30", privateDocDigest, "0123456789abcdef"} {
+		if strings.Contains(public, secret) {
+			t.Fatalf("Public state leaks %q: %s", secret, public)
+		}
+	}
+
+	Agreement, err := readAgreement(stub, "Agreement-tx3")
+	if err != nil || Agreement == nil {
+		t.Fatalf("Failed to read Agreement-tx3: %v", err)
+	}
+	if Agreement.Agreement_collection != "agreement_Org1MSP_Org2MSP" || Agreement.Agreement_terms_hash == "" {
+		t.Fatalf("Unexpected private binding %s %s", Agreement.Agreement_collection, Agreement.Agreement_terms_hash)
+	}
+	if stub.PvtState[Agreement.Agreement_collection]["Agreement-tx3"] == nil {
+		t.Fatalf("Terms were not written to the collection")
+	}
+}
+
+func TestPrivateTermsQueries(t *testing.T) {
+	stub := setupPrivateAgreement(t)
+
+	res := stub.as(t, "Org2MSP", nil).invoke("q1", "queryPrivateAgreement", "Agreement-tx3")
+	expectOK(t, res)
+	view := AgreementView{}
+	json.Unmarshal(res.Payload, &view)
+	if view.Agreement_price != "1200 EUR" || view.Agreement_model_count_use != 2 || view.Agreement_remark != "net 30" {
+		t.Fatalf("Unexpected private view %s", res.Payload)
+	}
+
+	res = stub.invoke("q2", "queryAgreementTerms", "Agreement-tx3")
+	expectOK(t, res)
+	if termsHash(res.Payload) != view.Agreement_terms_hash {
+		t.Fatalf("Terms do not reproduce the public hash")
+	}
+
+	expectDenied(t, stub.as(t, "Org3MSP", nil).invoke("q3", "queryAgreementTerms", "Agreement-tx3"))
+	expectDenied(t, stub.as(t, "Org3MSP", adminAttrs).invoke("q4", "queryPrivateAgreement", "Agreement-tx3"))
+
+	// the legacy agreement has no private terms
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("q5", "queryPrivateAgreement", "Agreement-tx2"))
+	expectFailure(t, stub.invoke("q6", "queryAgreementTerms", "Agreement-tx2"))
+}
+
+func TestPrivateQuotaIsEnforced(t *testing.T) {
+	stub := setupPrivateAgreement(t)
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke("tx4", "approveAgreement", "Agreement-tx3"))
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("tx5", "activateAgreement", "Agreement-tx3"))
+
+	stub.as(t, "Org2MSP", nil)
+	expectOK(t, stub.invoke("tx6", "queryModelByAgreementID", "Agreement-tx3"))
+	expectOK(t, stub.invoke("tx7", "queryModelByAgreementID", "Agreement-tx3"))
+	expectOK(t, stub.invoke("tx8", "compactUsage", "Agreement-tx3"))
+	expectFailure(t, stub.invoke("tx9", "queryModelByAgreementID", "Agreement-tx3"))
+
+	// tampered terms no longer match the public hash
+	collection := agreementCollection("Org1MSP", "Org2MSP")
+	stub.PvtState[collection]["Agreement-tx3"] = []byte(strings.Replace(string(stub.PvtState[collection]["Agreement-tx3"]), `net 30`, `net 90`, 1))
+	expectFailure(t, stub.invoke("tx10", "queryUsage", "Agreement-tx3"))
+}
+
+func TestPrivateTermsValidation(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", nil)
+
+	insert := func(txID string, terms string, quota string) {
+		t.Helper()
+		stub.withTransient(map[string][]byte{agreementTermsTransientKey: []byte(terms)})
+		expectFailure(t, stub.invoke(txID, "insertAgreementinfo", "Private", "Model-tx1", quota, "Org1MSP", "Org2MSP", "", "", "", ""))
+	}
+	insert("tx3", privateTerms, "10")
+	insert("tx4", `{"Agreement_model_account_use":"many","salt":"0123456789abcdef"}`, "")
+	insert("tx5", `{"Agreement_model_account_use":"2","salt":"short"}`, "")
+	insert("tx6", `not json`, "")
+	insert("tx7", `{"Agreement_model_account_use":"2","salt":"0123456789abcdef"} {}`, "")
+
+	// only the channel organizations of Init have a collection
+	stub.withTransient(map[string][]byte{agreementTermsTransientKey: []byte(privateTerms)})
+	expectError(t, stub.invoke("tx8", "insertAgreementinfo", "Private", "Model-tx1", "", "Org1MSP", "Org4MSP", "", "", "", ""), codeNotSupported)
+
+	// an upgrade adds Org4MSP
+	stub.args = [][]byte{[]byte("init"), []byte("Org1MSP"), []byte("Org1MSP"), []byte("Org2MSP"), []byte("Org3MSP"), []byte("Org4MSP")}
+	stub.MockTransactionStart("upgrade")
+	expectOK(t, stub.cc.Init(stub))
+	stub.MockTransactionEnd("upgrade")
+	stub.withTransient(map[string][]byte{agreementTermsTransientKey: []byte(privateTerms)})
+	expectOK(t, stub.invoke("tx9", "insertAgreementinfo", "Private", "Model-tx1", "", "Org1MSP", "Org4MSP", "", "", "", ""))
+	if stub.PvtState[agreementCollection("Org4MSP", "Org1MSP")]["Agreement-tx9"] == nil {
+		t.Fatalf("Terms were not written to the collection of Org1MSP and Org4MSP")
+	}
+}
+
+func TestCollectionsConfig(t *testing.T) {
+	configAsBytes, err := ioutil.ReadFile("collections_config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collections := []struct {
+		Name              string `json:"name"`
+		Policy            string `json:"policy"`
+		RequiredPeerCount int    `json:"requiredPeerCount"`
+		MemberOnlyRead    bool   `json:"memberOnlyRead"`
+	}{}
+	if err := json.Unmarshal(configAsBytes, &collections); err != nil {
+		t.Fatal(err)
+	}
+
+	// the config defines the collection of every pair of the channel organizations
+	pairs := map[string]bool{}
+	for i, org1 := range channelOrgs {
+		for _, org2 := range channelOrgs[i+1:] {
+			pairs[agreementCollection(org1, org2)] = true
+		}
+	}
+	if len(collections) != len(pairs) {
+		t.Fatalf("Expected a collection for each pair of %v, got %d", channelOrgs, len(collections))
+	}
+	for _, collection := range collections {
+		if !pairs[collection.Name] {
+			t.Fatalf("Collection %s is not a pair of %v", collection.Name, channelOrgs)
+		}
+		orgs := strings.Split(strings.TrimPrefix(collection.Name, "agreement_"), "_")
+		policy := "OR('" + orgs[0] + ".member','" + orgs[1] + ".member')"
+		if collection.Policy != policy || !collection.MemberOnlyRead {
+			t.Fatalf("Collection %s must be readable by its two members only", collection.Name)
+		}
+		// endorsements must not succeed with the terms on a single peer
+		if collection.RequiredPeerCount < 1 {
+			t.Fatalf("Collection %s must be disseminated to at least one other peer", collection.Name)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// defaultPageSize is used when a paginated query does not name a page size
+const defaultPageSize = 100
+
+// maxPageSize bounds the records a single query can return
+const maxPageSize = 1000
+
+// agreementQueryFields are the Agreement fields a caller may select or sort on
+var agreementQueryFields = map[string]bool{
+	"AgreementID":                 true,
+	"Agreement_name":              true,
+	"Agreement_model_id":          true,
+	"Agreement_model_version":     true,
+	"Agreement_issuer":            true,
+	"Agreement_participant":       true,
+	"Agreement_status":            true,
+	"Agreement_create_time":       true,
+	"Agreement_update_time":       true,
+	"Agreement_model_account_use": true,
+}
+
+// selectorOperators are the CouchDB operators a caller may use in a selector
+var selectorOperators = map[string]bool{
+	"$and": true, "$or": true, "$nor": true, "$not": true,
+	"$eq": true, "$ne": true, "$lt": true, "$lte": true, "$gt": true, "$gte": true,
+	"$in": true, "$nin": true, "$exists": true, "$regex": true,
+}
+
+// couchIndex is an index shipped in META-INF/statedb/couchdb/indexes
+type couchIndex struct {
+	DesignDoc string
+	Name      string
+	Fields    []string
+}
+
+// useIndex is the value of use_index in a query on the index
+func (idx couchIndex) useIndex() []string {
+	return []string{"_design/" + idx.DesignDoc, idx.Name}
+}
+
+// Agreement indexes, every one is prefixed with docType
+var (
+	indexAgreementDocType     = couchIndex{"indexAgreementDocTypeDoc", "indexAgreementDocType", []string{"docType"}}
+	indexAgreementIssuer      = couchIndex{"indexAgreementIssuerDoc", "indexAgreementIssuer", []string{"docType", "Agreement_issuer"}}
+	indexAgreementParticipant = couchIndex{"indexAgreementParticipantDoc", "indexAgreementParticipant", []string{"docType", "Agreement_participant"}}
+	indexAgreementStatus      = couchIndex{"indexAgreementStatusDoc", "indexAgreementStatus", []string{"docType", "Agreement_status"}}
+	indexAgreementModel       = couchIndex{"indexAgreementModelDoc", "indexAgreementModel", []string{"docType", "Agreement_model_id"}}
+	indexAgreementCreateTime  = couchIndex{"indexAgreementCreateTimeDoc", "indexAgreementCreateTime", []string{"docType", "Agreement_create_time"}}
+	indexAgreementUpdateTime  = couchIndex{"indexAgreementUpdateTimeDoc", "indexAgreementUpdateTime", []string{"docType", "Agreement_update_time"}}
+)
+
+// agreementSelectorIndexes are tried in order, the first field present in a selector picks the index
+var agreementSelectorIndexes = []couchIndex{
+	indexAgreementIssuer,
+	indexAgreementParticipant,
+	indexAgreementModel,
+	indexAgreementStatus,
+}
+
+// agreementSortIndexes maps each sortable field to its index
+var agreementSortIndexes = map[string]couchIndex{
+	"Agreement_create_time": indexAgreementCreateTime,
+	"Agreement_update_time": indexAgreementUpdateTime,
+}
+
+// agreementIndexFor picks the index serving an Agreement selector
+func agreementIndexFor(selector map[string]interface{}) couchIndex {
+	for _, index := range agreementSelectorIndexes {
+		if _, ok := selector[index.Fields[1]]; ok {
+			return index
+		}
+	}
+	return indexAgreementDocType
+}
+
+// PageMetadata is the response metadata of a paginated query
+type PageMetadata struct {
+	FetchedRecordsCount int32  `json:"fetchedRecordsCount"`
+	Bookmark            string `json:"bookmark"`
+}
+
+// validateSelector checks every field and operator of a selector against the allowlists
+func validateSelector(selector interface{}, fields map[string]bool) error {
+	switch value := selector.(type) {
+	case map[string]interface{}:
+		for key, nested := range value {
+			if strings.HasPrefix(key, "$") {
+				if !selectorOperators[key] {
+					return &FieldError{"selector", "operator " + key + " is not allowed"}
+				}
+			} else if !fields[key] {
+				return &FieldError{"selector", "field " + key + " is not allowed"}
+			}
+			if err := validateSelector(nested, fields); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, nested := range value {
+			if err := validateSelector(nested, fields); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restrictToParty limits an Agreement selector to the Agreements the caller
+// is a party of, administrators see every Agreement. The clause is appended
+// to $and so the top-level fields still pick the index.
+func restrictToParty(selector map[string]interface{}, caller *Caller) {
+	if caller.IsAdmin {
+		return
+	}
+	party := map[string]interface{}{"$or": []interface{}{
+		map[string]interface{}{"Agreement_issuer": caller.MSPID},
+		map[string]interface{}{"Agreement_participant": caller.MSPID},
+		map[string]interface{}{"Agreement_parties": map[string]interface{}{"$elemMatch": map[string]interface{}{"msp_id": caller.MSPID}}},
+	}}
+	and, _ := selector["$and"].([]interface{})
+	selector["$and"] = append(and, party)
+}
+
+// parseSort reads a CouchDB sort array, e.g. [{"Agreement_create_time":"desc"}].
+// Only one field can be sorted on, and it needs a sort index.
+func parseSort(arg string) (string, string, error) {
+	sort := []map[string]string{}
+	err := json.Unmarshal([]byte(arg), &sort)
+	if err != nil {
+		return "", "", &FieldError{"sort", "must be a JSON array: " + err.Error()}
+	}
+	if len(sort) != 1 || len(sort[0]) != 1 {
+		return "", "", &FieldError{"sort", "must name exactly one field"}
+	}
+	for field, direction := range sort[0] {
+		if _, ok := agreementSortIndexes[field]; !ok {
+			return "", "", &FieldError{"sort", "field " + field + " is not allowed"}
+		}
+		if direction != "asc" && direction != "desc" {
+			return "", "", &FieldError{"sort", "direction of " + field + " must be asc or desc"}
+		}
+		return field, direction, nil
+	}
+	return "", "", nil
+}
+
+// parsePageSize reads an optional page size argument
+func parsePageSize(arg string) (int32, error) {
+	if arg == "" {
+		return defaultPageSize, nil
+	}
+	pageSize, err := strconv.Atoi(arg)
+	if err != nil || pageSize <= 0 || pageSize > maxPageSize {
+		return 0, &FieldError{"pageSize", fmt.Sprintf("must be an integer between 1 and %d", maxPageSize)}
+	}
+	return int32(pageSize), nil
+}
+
+// writePage builds {"records":[...],"metadata":{...}} from a paginated iterator.
+// withKeys wraps each record as {"Key":...,"Record":...} like queryAllAsset always did.
+func writePage(resultsIterator shim.StateQueryIteratorInterface, metadata *peer.QueryResponseMetadata, withKeys bool) ([]byte, error) {
+	if resultsIterator == nil || metadata == nil {
+		return nil, newError(codeNotSupported, "Paginated queries are not supported by this state database")
+	}
+	defer resultsIterator.Close()
+
+	var buffer bytes.Buffer
+	buffer.WriteString("{\"records\":[")
+
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		if withKeys {
+			keyAsBytes, _ := json.Marshal(queryResponse.Key)
+			buffer.WriteString("{\"Key\":")
+			buffer.Write(keyAsBytes)
+			buffer.WriteString(", \"Record\":")
+			buffer.WriteString(string(queryResponse.Value))
+			buffer.WriteString("}")
+		} else {
+			buffer.WriteString(string(queryResponse.Value))
+		}
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("],\"metadata\":")
+
+	metadataAsBytes, err := json.Marshal(PageMetadata{metadata.FetchedRecordsCount, metadata.Bookmark})
+	if err != nil {
+		return nil, err
+	}
+	buffer.Write(metadataAsBytes)
+	buffer.WriteString("}")
+
+	return buffer.Bytes(), nil
+}
+
+// ===========================================================================
+// queryAgreements - paginated rich query over Agreements
+//
+// Only the Agreements the submitter's organization is a party of match,
+// administrators query every Agreement.
+//
+// selector - JSON object, fields and operators are checked against allowlists
+// sort - optional JSON array naming one sortable field, e.g. [{"Agreement_create_time":"desc"}]
+// pageSize - optional, defaults to 100
+// bookmark - optional, the bookmark returned with the previous page
+// ===========================================================================
+func (t *MAGNIT_CC) queryAgreements(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) < 1 || len(args) > 4 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting selector, sort, pageSize, bookmark"))
+	}
+	fields := make([]string, 4)
+	copy(fields, args)
+
+	selector := map[string]interface{}{}
+	err := json.Unmarshal([]byte(fields[0]), &selector)
+	if err != nil {
+		return errorResponse(&FieldError{"selector", "must be a JSON object: " + err.Error()})
+	}
+	err = validateSelector(selector, agreementQueryFields)
+	if err != nil {
+		return errorResponse(err)
+	}
+	// callers can only ever see live Agreements they are a party of
+	selector["docType"] = "Agreement"
+	selector["Agreement_tombstone"] = map[string]interface{}{"$exists": false}
+	caller, err := getCaller(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	restrictToParty(selector, caller)
+
+	query := map[string]interface{}{"selector": selector}
+	if fields[1] != "" {
+		sortField, direction, err := parseSort(fields[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		// CouchDB only uses an index whose fields all appear in the selector,
+		// and sorts on every field of the index in the same direction
+		if _, ok := selector[sortField]; !ok {
+			selector[sortField] = map[string]interface{}{"$gt": nil}
+		}
+		query["sort"] = []map[string]string{{"docType": direction}, {sortField: direction}}
+		query["use_index"] = agreementSortIndexes[sortField].useIndex()
+	} else {
+		query["use_index"] = agreementIndexFor(selector).useIndex()
+	}
+
+	pageSize, err := parsePageSize(fields[2])
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	queryAsBytes, err := json.Marshal(query)
+	if err != nil {
+		return errorResponse(err)
+	}
+	fmt.Printf("- queryAgreements queryString:\n%s\n", queryAsBytes)
+
+	resultsIterator, metadata, err := APIstub.GetQueryResultWithPagination(string(queryAsBytes), pageSize, fields[3])
+	if err != nil {
+		return errorResponse(err)
+	}
+	pageAsBytes, err := writePage(resultsIterator, metadata, false)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(pageAsBytes)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+type page struct {
+	Records  []json.RawMessage `json:"records"`
+	Metadata PageMetadata      `json:"metadata"`
+}
+
+func expectPage(t *testing.T, res peer.Response, count int) page {
+	t.Helper()
+	expectOK(t, res)
+
+	result := page{}
+	if err := json.Unmarshal(res.Payload, &result); err != nil {
+		t.Fatalf("Invalid page %s: %v", res.Payload, err)
+	}
+	if len(result.Records) != count || result.Metadata.FetchedRecordsCount != int32(count) {
+		t.Fatalf("Expected %d records, got %s", count, res.Payload)
+	}
+	return result
+}
+
+func TestValidateSelector(t *testing.T) {
+	valid := `{"$or":[{"Agreement_status":"active"},{"Agreement_issuer":{"$in":["Org1MSP","Org2MSP"]}}]}`
+	invalid := map[string]string{
+		"field":    `{"Agreement_hash":"abc"}`,
+		"nested":   `{"$and":[{"Agreement_status":"active"},{"Agreement_remark":"x"}]}`,
+		"operator": `{"Agreement_status":{"$where":"1"}}`,
+	}
+
+	selector := map[string]interface{}{}
+	json.Unmarshal([]byte(valid), &selector)
+	if err := validateSelector(selector, agreementQueryFields); err != nil {
+		t.Fatalf("Expected selector to be valid: %v", err)
+	}
+	for name, query := range invalid {
+		selector := map[string]interface{}{}
+		json.Unmarshal([]byte(query), &selector)
+		if err := validateSelector(selector, agreementQueryFields); err == nil {
+			t.Fatalf("Expected %s selector to be refused", name)
+		}
+	}
+}
+
+func TestQueryAgreementsPagination(t *testing.T) {
+	stub := setupAgreement(t)
+	for _, txID := range []string{"tx3", "tx4"} {
+		expectOK(t, stub.invoke(txID, "insertAgreementinfo", "Agreement", "Model-tx1", "10", "Org1MSP", "Org2MSP", "", "", "", docHash))
+	}
+
+	first := expectPage(t, stub.invoke("q1", "queryAgreements", `{"Agreement_issuer":"Org1MSP"}`, "", "2"), 2)
+	if first.Metadata.Bookmark == "" {
+		t.Fatalf("Expected a bookmark for the next page")
+	}
+	expectPage(t, stub.invoke("q2", "queryAgreements", `{"Agreement_issuer":"Org1MSP"}`, "", "2", first.Metadata.Bookmark), 1)
+
+	if res := stub.invoke("q3", "queryAgreements", `{"Agreement_remark":"secret"}`); res.Status == shim.OK {
+		t.Fatalf("Expected a selector outside the allowlist to be refused")
+	}
+	if res := stub.invoke("q4", "queryAgreements", `{}`, `[{"Agreement_hash":"asc"}]`); res.Status == shim.OK {
+		t.Fatalf("Expected a sort outside the allowlist to be refused")
+	}
+}
+
+func TestQueryAllAssetPagination(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", adminAttrs)
+
+	first := expectPage(t, stub.invoke("q1", "queryAllAsset", "1"), 1)
+	expectPage(t, stub.invoke("q2", "queryAllAsset", "1", first.Metadata.Bookmark), 1)
+
+	if res := stub.invoke("q3", "queryAllAsset", "0"); res.Status == shim.OK {
+		t.Fatalf("Expected an invalid page size to be refused")
+	}
+}
+
+func TestQueryAgreementsOnlyOfParties(t *testing.T) {
+	stub := setupAgreement(t)
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("tx3", "insertAgreementinfo", consortiumAgreement))
+	expectOK(t, stub.as(t, "Org4MSP", nil).invoke("tx4", "insertAgreementinfo", "Other", "Model-tx1", "10", "Org4MSP", "Org5MSP", "", "", "", docHash))
+
+	expectPage(t, stub.as(t, "Org2MSP", nil).invoke("q1", "queryAgreements", `{}`), 2)
+	// the approver of the consortium Agreement only sees that one
+	expectPage(t, stub.as(t, "Org3MSP", nil).invoke("q2", "queryAgreements", `{}`), 1)
+	// an explicit selector does not widen the result
+	expectPage(t, stub.as(t, "Org3MSP", nil).invoke("q3", "queryAgreements", `{"Agreement_issuer":"Org4MSP"}`), 0)
+	expectPage(t, stub.as(t, "Org3MSP", nil).invoke("q4", "queryAgreements", `{"$or":[{"Agreement_issuer":"Org4MSP"},{"Agreement_participant":"Org5MSP"}]}`), 0)
+	expectPage(t, stub.as(t, "Org6MSP", adminAttrs).invoke("q5", "queryAgreements", `{}`), 0)
+	expectPage(t, stub.as(t, "Org1MSP", adminAttrs).invoke("q6", "queryAgreements", `{}`), 3)
+
+	all := []json.RawMessage{}
+	res := stub.as(t, "Org5MSP", nil).invoke("q7", "queryAllAgreements")
+	expectOK(t, res)
+	if json.Unmarshal(res.Payload, &all); len(all) != 1 {
+		t.Fatalf("Expected only the Agreement of Org5MSP, got %s", res.Payload)
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Modes of a function, read functions cannot write to the ledger
+const (
+	modeRead  = "read"
+	modeWrite = "write"
+)
+
+// Types of a function argument or input field
+const (
+	argString  = "string"
+	argInteger = "integer"
+	argBoolean = "boolean"
+	argJSON    = "json" // a JSON document passed as a string
+	argArray   = "array"
+	argObject  = "object"
+)
+
+// Arg describes one positional argument or JSON input field of a function
+type Arg struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Optional    bool   `json:"optional,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Function is an entry of the function registry Invoke dispatches from
+type Function struct {
+	Name        string
+	Description string
+	Mode        string
+	Policy      string // name of the access policy, see accessPolicies
+	Args        []Arg  // the positional arguments
+	Deprecated  string // what to use instead, empty for current functions
+	// input is the zero value of the JSON input the function also accepts as
+	// its only argument or under "input" in the transient map
+	input interface{}
+	// transaction is a typed transaction function taking the TransactionContext
+	// and one parameter per arg, it returns a result and an error or an error
+	transaction interface{}
+	handler     func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, args []string) peer.Response
+}
+
+// FunctionDescription is a Function as listed by describe
+type FunctionDescription struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Mode        string `json:"mode"`
+	Policy      string `json:"policy"`
+	Args        []Arg  `json:"args"`
+	Input       []Arg  `json:"input,omitempty"` // fields of the JSON input
+	Deprecated  string `json:"deprecated,omitempty"`
+}
+
+// PolicyDescription is an access policy as listed by describe
+type PolicyDescription struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// APIDescription is the response of describe
+type APIDescription struct {
+	Functions []FunctionDescription `json:"functions"`
+	Policies  []PolicyDescription   `json:"policies"`
+	Errors    []ErrorCode           `json:"errors"`
+}
+
+// agreementIDArg is the argument of the functions working on one Agreement
+var agreementIDArg = Arg{Name: "AgreementID", Type: argString}
+
+// historyFilterArg is the optional filter of the history queries, see HistoryFilter
+var historyFilterArg = Arg{Name: "filter", Type: argJSON, Optional: true, Description: `{"from","to","tx_id","diffs"}, from and to are RFC3339 timestamps`}
+
+// functionRegistry lists the functions of the chaincode. The lifecycle
+// transitions without an own handler are added from agreementTransitions.
+var functionRegistry = []*Function{
+	{
+		Name: "initmodel", Description: "create a new model", Mode: modeWrite, Policy: policyModelUploader,
+		Args: []Arg{
+			{Name: "model_name", Type: argString}, {Name: "upload_org", Type: argString},
+			{Name: "model_version", Type: argString, Optional: true}, {Name: "model_description", Type: argString, Optional: true},
+			{Name: "model_framework", Type: argString, Optional: true}, {Name: "model_artifact_hash", Type: argString, Optional: true},
+			{Name: "model_artifact_uri", Type: argString, Optional: true}, {Name: "model_license", Type: argString, Optional: true},
+			{Name: "model_tags", Type: argString, Optional: true, Description: "comma separated"},
+		},
+		input:   ModelInput{},
+		handler: (*MAGNIT_CC).initmodel,
+	},
+	{
+		Name: "queryByModel_id", Description: "read a model", Mode: modeRead, Policy: policyAnyone,
+		Args:    []Arg{{Name: "model_id", Type: argString}},
+		handler: (*MAGNIT_CC).queryByModel_id,
+	},
+	{
+		Name: "publishModelVersion", Description: "add an immutable release of a model", Mode: modeWrite, Policy: policyModelOwner,
+		Args: []Arg{
+			{Name: "model_id", Type: argString}, {Name: "model_version", Type: argString},
+			{Name: "model_artifact_hash", Type: argString}, {Name: "model_artifact_uri", Type: argString},
+			{Name: "model_description", Type: argString, Optional: true},
+		},
+		input:   ModelVersionInput{},
+		handler: (*MAGNIT_CC).publishModelVersion,
+	},
+	{
+		Name: "queryModelVersions", Description: "list the releases of a model", Mode: modeRead, Policy: policyAnyone,
+		Args:        []Arg{{Name: "model_id", Type: argString}},
+		transaction: (*MAGNIT_CC).queryModelVersions,
+	},
+	{
+		Name: "queryModelHistory", Description: "every version of a model, oldest first", Mode: modeRead, Policy: policyAnyone,
+		Args:    []Arg{{Name: "model_id", Type: argString}, historyFilterArg},
+		handler: (*MAGNIT_CC).queryModelHistory,
+	},
+	{
+		Name: "migrateModels", Description: "rewrite models stored in the legacy shape", Mode: modeWrite, Policy: policyAdmin,
+		Args:    []Arg{{Name: "limit", Type: argInteger, Optional: true}, {Name: "bookmark", Type: argString, Optional: true, Description: "next of the previous call"}},
+		handler: (*MAGNIT_CC).migrateModels,
+	},
+	{
+		Name: "insertAgreementinfo", Description: "propose a new Agreement, confidential terms go under agreement_terms in the transient map", Mode: modeWrite, Policy: policyAgreementIssuer,
+		Args: []Arg{
+			{Name: "Agreement_name", Type: argString}, {Name: "Agreement_model_id", Type: argString},
+			{Name: "Agreement_model_account_use", Type: argInteger}, {Name: "Agreement_issuer", Type: argString},
+			{Name: "Agreement_participant", Type: argString}, {Name: "Agreement_remark", Type: argString},
+			{Name: "Agreement_url_image", Type: argString}, {Name: "Agreement_status", Type: argString, Description: "ignored, new agreements are proposed"},
+			{Name: "Agreement_hash", Type: argString}, {Name: "Agreement_model_version", Type: argString, Optional: true},
+		},
+		input:   AgreementInput{},
+		handler: (*MAGNIT_CC).insertAgreementinfo,
+	},
+	{
+		Name: "queryByAgreementID", Description: "read an Agreement", Mode: modeRead, Policy: policyAgreementReader,
+		Args:    []Arg{agreementIDArg},
+		handler: (*MAGNIT_CC).queryByAgreementID,
+	},
+	{
+		Name: "queryAgreementTerms", Description: "private terms of an Agreement", Mode: modeRead, Policy: policyTermsHolder,
+		Args:    []Arg{agreementIDArg},
+		handler: (*MAGNIT_CC).queryAgreementTerms,
+	},
+	{
+		Name: "queryPrivateAgreement", Description: "an Agreement with its private terms", Mode: modeRead, Policy: policyTermsHolder,
+		Args:    []Arg{agreementIDArg},
+		handler: (*MAGNIT_CC).queryPrivateAgreement,
+	},
+	{
+		Name: "approveAgreement", Description: "move a proposed Agreement to approved", Mode: modeWrite, Policy: policyTransitionParty,
+		Args:    []Arg{agreementIDArg, {Name: "status", Type: argString, Optional: true, Description: "must be approved"}},
+		handler: (*MAGNIT_CC).approveAgreement,
+	},
+	{
+		Name: "signAgreement", Description: "sign a proposed Agreement for the submitter's organization", Mode: modeWrite, Policy: policyAgreementSigner,
+		Args:    []Arg{agreementIDArg},
+		handler: (*MAGNIT_CC).signAgreement,
+	},
+	{
+		Name: "expireAgreements", Description: "expire Agreements past their validity", Mode: modeWrite, Policy: policyAdmin,
+		Args:    []Arg{{Name: "limit", Type: argInteger, Optional: true, Description: "defaults to 100"}},
+		handler: (*MAGNIT_CC).expireAgreements,
+	},
+	{
+		Name: "consumeModelUsage", Description: "consume units of the quota of an Agreement", Mode: modeWrite, Policy: policyParticipant,
+		Args:        []Arg{agreementIDArg, {Name: "units", Type: argInteger}},
+		transaction: (*MAGNIT_CC).consumeModelUsage,
+	},
+	{
+		Name: "queryModelByAgreementID", Description: "count a use of an Agreement and return its model version with an access grant", Mode: modeWrite, Policy: policyParticipant,
+		Args:       []Arg{agreementIDArg},
+		Deprecated: "consumeModelUsage",
+		handler:    (*MAGNIT_CC).queryModelByAgreementID,
+	},
+	{
+		Name: "redeemAccessGrant", Description: "redeem a single-use access grant minted by a consumption", Mode: modeWrite, Policy: policyGrantRedeemer,
+		Args: []Arg{
+			{Name: "nonce", Type: argString},
+			{Name: "signature", Type: argString, Description: "base64 ECDSA signature of the nonce by the consuming identity"},
+		},
+		transaction: (*MAGNIT_CC).redeemAccessGrant,
+	},
+	{
+		Name: "queryUsage", Description: "aggregated consumption of an Agreement", Mode: modeRead, Policy: policyAgreementReader,
+		Args:    []Arg{agreementIDArg},
+		handler: (*MAGNIT_CC).queryUsage,
+	},
+	{
+		Name: "compactUsage", Description: "fold the usage deltas of an Agreement into its count", Mode: modeWrite, Policy: policyAgreementReader,
+		Args:    []Arg{agreementIDArg},
+		handler: (*MAGNIT_CC).compactUsage,
+	},
+	{
+		Name: "proposeAmendment", Description: "propose a change of an Agreement", Mode: modeWrite, Policy: policyAmendmentProposer,
+		Args:    []Arg{},
+		input:   AmendmentInput{},
+		handler: (*MAGNIT_CC).proposeAmendment,
+	},
+	{
+		Name: "acceptAmendment", Description: "apply a pending amendment", Mode: modeWrite, Policy: policyAmendmentParty,
+		Args:    []Arg{{Name: "AmendmentID", Type: argString}},
+		handler: (*MAGNIT_CC).acceptAmendment,
+	},
+	{
+		Name: "rejectAmendment", Description: "discard a pending amendment", Mode: modeWrite, Policy: policyAmendmentParty,
+		Args:    []Arg{{Name: "AmendmentID", Type: argString}, {Name: "reason", Type: argString, Optional: true}},
+		handler: (*MAGNIT_CC).rejectAmendment,
+	},
+	{
+		Name: "queryAmendment", Description: "an amendment by ID", Mode: modeRead, Policy: policyAmendmentParty,
+		Args:        []Arg{{Name: "AmendmentID", Type: argString}},
+		transaction: (*MAGNIT_CC).queryAmendment,
+	},
+	{
+		Name: "queryAmendments", Description: "all amendments of an Agreement", Mode: modeRead, Policy: policyAgreementReader,
+		Args:    []Arg{agreementIDArg},
+		handler: (*MAGNIT_CC).queryAmendments,
+	},
+	{
+		Name: "verifyAgreementDocument", Description: "check a document digest against an Agreement", Mode: modeRead, Policy: policyAgreementReader,
+		Args:        []Arg{agreementIDArg, {Name: "digest", Type: argString, Description: "algorithm-tagged, e.g. sha256:<hex>"}},
+		transaction: (*MAGNIT_CC).verifyAgreementDocument,
+	},
+	{
+		Name: "queryAgreementDocuments", Description: "document revisions of an Agreement", Mode: modeRead, Policy: policyAgreementReader,
+		Args:        []Arg{agreementIDArg},
+		transaction: (*MAGNIT_CC).queryAgreementDocuments,
+	},
+	{
+		Name: "queryAgreementHistory", Description: "every version of an Agreement, oldest first", Mode: modeRead, Policy: policyAgreementReader,
+		Args:    []Arg{agreementIDArg, historyFilterArg},
+		handler: (*MAGNIT_CC).queryAgreementHistory,
+	},
+	{
+		Name: "getHistoryForRecord", Description: "every version of an Agreement or model", Mode: modeRead, Policy: policyAdmin,
+		Args:       []Arg{{Name: "key", Type: argString}},
+		Deprecated: "queryAgreementHistory or queryModelHistory",
+		handler:    (*MAGNIT_CC).getHistoryForRecord,
+	},
+	{
+		Name: "queryAllAgreements", Description: "every Agreement of the submitter's organization, administrators read all", Mode: modeRead, Policy: policyAnyone,
+		Args:    []Arg{},
+		handler: (*MAGNIT_CC).queryAllAgreements,
+	},
+	{
+		Name: "queryAgreements", Description: "paginated rich query over the Agreements of the submitter's organization, administrators query all", Mode: modeRead, Policy: policyAnyone,
+		Args: []Arg{
+			{Name: "selector", Type: argJSON}, {Name: "sort", Type: argJSON, Optional: true},
+			{Name: "pageSize", Type: argInteger, Optional: true, Description: "defaults to 100"},
+			{Name: "bookmark", Type: argString, Optional: true},
+		},
+		handler: (*MAGNIT_CC).queryAgreements,
+	},
+	{
+		Name: "queryAgreementsByIssuer", Description: "Agreements issued by an organization", Mode: modeRead, Policy: policyOwnOrganization,
+		Args:    []Arg{{Name: "msp_id", Type: argString}},
+		handler: (*MAGNIT_CC).queryAgreementsByIssuer,
+	},
+	{
+		Name: "queryAgreementsByParticipant", Description: "Agreements an organization participates in", Mode: modeRead, Policy: policyOwnOrganization,
+		Args:    []Arg{{Name: "msp_id", Type: argString}},
+		handler: (*MAGNIT_CC).queryAgreementsByParticipant,
+	},
+	{
+		Name: "queryAgreementsByModel", Description: "Agreements bound to a model", Mode: modeRead, Policy: policyModelReader,
+		Args:    []Arg{{Name: "model_id", Type: argString}},
+		handler: (*MAGNIT_CC).queryAgreementsByModel,
+	},
+	{
+		Name: "queryModelsByOrg", Description: "models uploaded by an organization", Mode: modeRead, Policy: policyAnyone,
+		Args:    []Arg{{Name: "msp_id", Type: argString}},
+		handler: (*MAGNIT_CC).queryModelsByOrg,
+	},
+	{
+		Name: "queryAllAsset", Description: "page through every key of the chaincode", Mode: modeRead, Policy: policyAdmin,
+		Args: []Arg{
+			{Name: "pageSize", Type: argInteger, Optional: true, Description: "defaults to 100"},
+			{Name: "bookmark", Type: argString, Optional: true},
+		},
+		handler: (*MAGNIT_CC).queryAllAsset,
+	},
+	{
+		Name: "rebuildIndexes", Description: "index records stored before the indexes existed, deleted records stay unindexed", Mode: modeWrite, Policy: policyAdmin,
+		Args: []Arg{
+			{Name: "pageSize", Type: argInteger, Optional: true, Description: "defaults to 100"},
+			{Name: "bookmark", Type: argString, Optional: true, Description: "next key of the previous call"},
+		},
+		handler: (*MAGNIT_CC).rebuildIndexes,
+	},
+	{
+		Name: "del", Description: "soft-delete a model or an Agreement", Mode: modeWrite, Policy: policyOwner,
+		Args: []Arg{
+			{Name: "id", Type: argString}, {Name: "reason", Type: argString},
+			{Name: "mode", Type: argString, Optional: true, Description: "block or suspend, for models"},
+		},
+		handler: (*MAGNIT_CC).del,
+	},
+	{
+		Name: "describe", Description: "the functions of the chaincode with their arguments and access policies", Mode: modeRead, Policy: policyAnyone,
+		Args:        []Arg{},
+		transaction: (*MAGNIT_CC).describe,
+	},
+	{
+		Name: metadataFunction, Description: "the contract metadata of the chaincode", Mode: modeRead, Policy: policyAnyone,
+		Args:        []Arg{},
+		transaction: (*MAGNIT_CC).getMetadata,
+	},
+}
+
+// functionIndex maps the function names to their registry entries
+var functionIndex = map[string]*Function{}
+
+func init() {
+	for _, function := range functionRegistry {
+		functionIndex[function.Name] = function
+	}
+
+	// the other lifecycle transitions share transitionAgreement
+	names := []string{}
+	for name := range agreementTransitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := functionIndex[name]; ok {
+			continue
+		}
+		tr, function := agreementTransitions[name], name
+		functionIndex[name] = &Function{
+			Name:        name,
+			Description: fmt.Sprintf("move an Agreement from %s to %s", strings.Join(tr.From, " or "), tr.To),
+			Mode:        modeWrite,
+			Policy:      policyTransitionParty,
+			Args:        []Arg{agreementIDArg},
+			handler: func(t *MAGNIT_CC, APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+				return t.transitionAgreement(APIstub, function, args)
+			},
+		}
+	}
+}
+
+// inputFields lists the JSON fields of an input type
+func inputFields(input interface{}) []Arg {
+	fields := []Arg{}
+	inputType := reflect.TypeOf(input)
+	for i := 0; i < inputType.NumField(); i++ {
+		field := inputType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields = append(fields, Arg{Name: name, Type: jsonType(field.Type)})
+	}
+	return fields
+}
+
+// jsonType names the JSON type of a Go type
+func jsonType(goType reflect.Type) string {
+	switch goType.Kind() {
+	case reflect.Ptr:
+		return jsonType(goType.Elem())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return argInteger
+	case reflect.Bool:
+		return argBoolean
+	case reflect.Slice, reflect.Array:
+		return argArray
+	case reflect.Struct, reflect.Map:
+		return argObject
+	}
+	return argString
+}
+
+// describe returns the registry entry as listed by describe
+func (function *Function) describe() FunctionDescription {
+	description := FunctionDescription{
+		Name:        function.Name,
+		Description: function.Description,
+		Mode:        function.Mode,
+		Policy:      function.Policy,
+		Args:        function.Args,
+		Deprecated:  function.Deprecated,
+	}
+	if function.input != nil {
+		description.Input = inputFields(function.input)
+	}
+	return description
+}
+
+// readOnlyStub refuses the writes of a function registered as a read
+type readOnlyStub struct {
+	shim.ChaincodeStubInterface
+	function string
+}
+
+func (s readOnlyStub) refuse() error {
+	return errors.New(s.function + " is a read-only function and cannot write to the ledger")
+}
+
+func (s readOnlyStub) PutState(key string, value []byte) error {
+	return s.refuse()
+}
+
+func (s readOnlyStub) DelState(key string) error {
+	return s.refuse()
+}
+
+func (s readOnlyStub) PutPrivateData(collection string, key string, value []byte) error {
+	return s.refuse()
+}
+
+func (s readOnlyStub) DelPrivateData(collection string, key string) error {
+	return s.refuse()
+}
+
+func (s readOnlyStub) SetEvent(name string, payload []byte) error {
+	return s.refuse()
+}
+
+// ===========================================================================
+// describe - the functions of the chaincode, ordered by name, with their
+// arguments, JSON input, mode and access policy
+// ===========================================================================
+func (t *MAGNIT_CC) describe(ctx *TransactionContext) (*APIDescription, error) {
+
+	api := APIDescription{Functions: []FunctionDescription{}, Policies: []PolicyDescription{}, Errors: errorCodes}
+	for _, function := range functionIndex {
+		api.Functions = append(api.Functions, function.describe())
+	}
+	sort.Slice(api.Functions, func(i, j int) bool { return api.Functions[i].Name < api.Functions[j].Name })
+	for name, policy := range accessPolicies {
+		api.Policies = append(api.Policies, PolicyDescription{name, policy.Description})
+	}
+	sort.Slice(api.Policies, func(i, j int) bool { return api.Policies[i].Name < api.Policies[j].Name })
+	return &api, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRegistryIsConsistent(t *testing.T) {
+	for name, function := range functionIndex {
+		if function.Name != name || (function.handler == nil) == (function.transaction == nil) || function.Args == nil {
+			t.Fatalf("Incomplete registry entry %s", name)
+		}
+		if function.Mode != modeRead && function.Mode != modeWrite {
+			t.Fatalf("%s has an unknown mode %q", name, function.Mode)
+		}
+		if _, ok := accessPolicies[function.Policy]; !ok {
+			t.Fatalf("%s has an unknown access policy %q", name, function.Policy)
+		}
+	}
+	for name := range agreementTransitions {
+		if functionIndex[name] == nil {
+			t.Fatalf("Lifecycle function %s is not registered", name)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org3MSP", nil)
+
+	res := stub.invoke("q1", "describe")
+	expectOK(t, res)
+	api := APIDescription{}
+	json.Unmarshal(res.Payload, &api)
+
+	names := []string{}
+	functions := map[string]FunctionDescription{}
+	for _, function := range api.Functions {
+		names = append(names, function.Name)
+		functions[function.Name] = function
+	}
+	if len(names) != len(functionIndex) || !sort.StringsAreSorted(names) {
+		t.Fatalf("Expected every function ordered by name: %v", names)
+	}
+
+	insert := functions["insertAgreementinfo"]
+	if insert.Mode != modeWrite || insert.Policy != policyAgreementIssuer || len(insert.Args) != 10 || !insert.Args[9].Optional {
+		t.Fatalf("Unexpected description %+v", insert)
+	}
+	inputTypes := map[string]string{}
+	for _, field := range insert.Input {
+		inputTypes[field.Name] = field.Type
+	}
+	if inputTypes["Agreement_model_account_use"] != argInteger || inputTypes["Agreement_parties"] != argArray || inputTypes["Agreement_issuer"] != argString {
+		t.Fatalf("Unexpected input fields %+v", insert.Input)
+	}
+	if activate := functions["activateAgreement"]; activate.Policy != policyTransitionParty || !strings.Contains(activate.Description, StatusApproved) {
+		t.Fatalf("Unexpected description %+v", activate)
+	}
+	if functions["queryModelByAgreementID"].Deprecated != "consumeModelUsage" || functions["queryByAgreementID"].Mode != modeRead {
+		t.Fatalf("Unexpected descriptions %s", res.Payload)
+	}
+
+	policies := map[string]bool{}
+	for _, policy := range api.Policies {
+		policies[policy.Name] = policy.Description != ""
+	}
+	for _, function := range api.Functions {
+		if !policies[function.Policy] {
+			t.Fatalf("Policy %s of %s is not described", function.Policy, function.Name)
+		}
+	}
+}
+
+func TestUnknownFunction(t *testing.T) {
+	stub := setupAgreement(t)
+
+	chaincodeErr := expectError(t, stub.invoke("tx3", "deleteEverything"), codeUnknownFunction)
+	if !strings.Contains(chaincodeErr.Message, "deleteEverything") || !strings.Contains(chaincodeErr.Message, "describe") {
+		t.Fatalf("Expected an error pointing to describe, got %s", chaincodeErr.Message)
+	}
+}
+
+func TestReadFunctionsCannotWrite(t *testing.T) {
+	stub := readOnlyStub{newCallerStub(t), "queryUsage"}
+	if err := stub.PutState("key", []byte("value")); err == nil || !strings.Contains(err.Error(), "queryUsage") {
+		t.Fatalf("Expected the write to be refused, got %v", err)
+	}
+	if err := stub.SetEvent("event", nil); err == nil {
+		t.Fatalf("Expected the event to be refused")
+	}
+}
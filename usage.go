@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/imineev/cc1/events"
+)
+
+// usageDeltaObjectType prefixes the per-transaction usage keys of an Agreement
+const usageDeltaObjectType = "UsageDelta"
+
+// UsageDelta records the units one transaction consumed from an Agreement.
+// Every metered read writes its own key, so consumers of the same Agreement
+// never write a shared key.
+type UsageDelta struct {
+	ObjectType  string `json:"docType"`
+	AgreementID string `json:"AgreementID"`
+	Units       int    `json:"units"`
+	Consumer    string `json:"consumer"`
+	TxID        string `json:"txid"`
+	Time        string `json:"time"`
+	// start of the window of each period quota the units count against
+	Windows map[string]string `json:"windows,omitempty"`
+}
+
+// Usage is the aggregated view of an Agreement's consumption
+type Usage struct {
+	AgreementID string `json:"AgreementID"`
+	Quota       int    `json:"quota"`
+	Compacted   int    `json:"compacted"` // units folded into Agreement_model_current_count
+	Pending     int    `json:"pending"`   // units in delta keys not compacted yet
+	Total       int    `json:"total"`
+	Remaining   int    `json:"remaining"`
+	// period quotas in the current window
+	Periods []PeriodRemaining `json:"periods,omitempty"`
+}
+
+// maxAgreementQuota bounds the quota of an Agreement and the units of one consumption
+const maxAgreementQuota = 1000000000
+
+// Stable codes of refused consumptions
+const (
+	codeQuotaExhausted       = "QUOTA_EXHAUSTED"
+	codeAgreementNotActive   = "AGREEMENT_NOT_ACTIVE"
+	codeAgreementNotYetValid = "AGREEMENT_NOT_YET_VALID"
+	codeAgreementExpired     = "AGREEMENT_EXPIRED"
+	codePeriodQuotaExhausted = "PERIOD_QUOTA_EXHAUSTED"
+)
+
+// Count is a usage counter of an Agreement. Agreements stored before the
+// counters were typed hold them as decimal strings, which still decode.
+type Count int
+
+func (c *Count) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		text = unquoted
+	}
+	// private agreements kept an empty public quota, see putAgreementTerms
+	if text == "" || text == "null" {
+		*c = 0
+		return nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: string(data), Type: reflect.TypeOf(value)}
+	}
+	*c = Count(value)
+	return nil
+}
+
+// validQuota checks a quota against the bounds of maxAgreementQuota
+func validQuota(field string, quota Count) error {
+	if quota < 0 || quota > maxAgreementQuota {
+		return &FieldError{field, fmt.Sprintf("must be an integer between 0 and %d, got %d", maxAgreementQuota, quota)}
+	}
+	return nil
+}
+
+// UsageError refuses a consumption, its fields after Code are the details
+// of the ChaincodeError
+type UsageError struct {
+	Message      string `json:"-"`
+	Code         string `json:"-"`
+	AgreementID  string `json:"AgreementID"`
+	Quota        int    `json:"quota"`
+	CurrentCount int    `json:"current_count"`
+	Requested    int    `json:"requested,omitempty"`
+	Period       string `json:"period,omitempty"`    // exhausted period quota
+	Resets_at    string `json:"resets_at,omitempty"` // end of its current window
+}
+
+func (e *UsageError) Error() string {
+	return e.Message
+}
+
+// agreementCounts returns the quota and the compacted count of an Agreement
+func agreementCounts(Agreement *Agreement) (int, int, error) {
+	if err := validQuota("Agreement_model_account_use", Agreement.Agreement_model_count_use); err != nil {
+		return 0, 0, fmt.Errorf("Agreement %s has an invalid quota: %s", Agreement.AgreementID, err.Error())
+	}
+	if Agreement.Agreement_model_current_count < 0 {
+		return 0, 0, fmt.Errorf("Agreement %s has a negative Agreement_model_current_count %d", Agreement.AgreementID, Agreement.Agreement_model_current_count)
+	}
+	return int(Agreement.Agreement_model_count_use), int(Agreement.Agreement_model_current_count), nil
+}
+
+// recordUsage writes the usage delta of the current transaction
+func recordUsage(APIstub shim.ChaincodeStubInterface, AgreementID string, units int, consumer string, time string, periods []PeriodRemaining) error {
+	txID := APIstub.GetTxID()
+	deltaKey, err := APIstub.CreateCompositeKey(usageDeltaObjectType, []string{AgreementID, txID})
+	if err != nil {
+		return err
+	}
+
+	var windows map[string]string
+	for _, period := range periods {
+		if windows == nil {
+			windows = map[string]string{}
+		}
+		windows[period.Period] = period.Start
+	}
+
+	deltaAsBytes, err := json.Marshal(UsageDelta{"usageDelta", AgreementID, units, consumer, txID, time, windows})
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(deltaKey, deltaAsBytes)
+}
+
+// pendingUsage sums the delta keys of an Agreement, in total and per window,
+// and returns them for compaction
+func pendingUsage(APIstub shim.ChaincodeStubInterface, AgreementID string) (int, map[usageWindow]int, []string, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(usageDeltaObjectType, []string{AgreementID})
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	pending := 0
+	windows := map[usageWindow]int{}
+	keys := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, nil, nil, err
+		}
+
+		delta := UsageDelta{}
+		err = json.Unmarshal(queryResponse.Value, &delta)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("Invalid usage delta %s: %s", queryResponse.Key, err.Error())
+		}
+		pending += delta.Units
+		for period, start := range delta.Windows {
+			windows[usageWindow{period, start}] += delta.Units
+		}
+		keys = append(keys, queryResponse.Key)
+	}
+	return pending, windows, keys, nil
+}
+
+// aggregateUsage returns the compacted and pending consumption of an Agreement,
+// with the delta keys and the pending units per window for compaction
+func aggregateUsage(APIstub shim.ChaincodeStubInterface, Agreement *Agreement) (*Usage, []string, map[usageWindow]int, error) {
+	view, err := withTerms(APIstub, Agreement)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	quota, compacted, err := agreementCounts(view.Agreement)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pending, windows, keys, err := pendingUsage(APIstub, Agreement.AgreementID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	periods, err := currentPeriods(APIstub, view.Agreement, now, windows)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	usage := &Usage{AgreementID: Agreement.AgreementID, Quota: quota, Compacted: compacted, Pending: pending, Periods: periods}
+	usage.Total = compacted + pending
+	usage.Remaining = quota - usage.Total
+	if usage.Remaining < 0 {
+		usage.Remaining = 0
+	}
+	return usage, keys, windows, nil
+}
+
+// ===========================================================================
+// queryUsage - aggregated consumption of an Agreement
+// ===========================================================================
+func (t *MAGNIT_CC) queryUsage(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting AgreementID"))
+	}
+
+	Agreement, err := readAgreement(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	} else if Agreement == nil {
+		return errorResponse(notFoundError("Agreement not exist"))
+	}
+
+	usage, _, _, err := aggregateUsage(APIstub, Agreement)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	usageAsBytes, err := json.Marshal(usage)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(usageAsBytes)
+}
+
+// ===========================================================================
+// compactUsage - fold the usage deltas of an Agreement into its count
+//
+// Metered reads check their quota against the compacted count only, see
+// consume. Compacting after each block keeps the overshoot of the quota
+// within the consumptions of that block.
+// ===========================================================================
+func (t *MAGNIT_CC) compactUsage(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) != 1 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting AgreementID"))
+	}
+
+	Agreement, err := readAgreement(APIstub, args[0])
+	if err != nil {
+		return errorResponse(err)
+	} else if Agreement == nil {
+		return errorResponse(notFoundError("Agreement not exist"))
+	}
+
+	usage, keys, windows, err := aggregateUsage(APIstub, Agreement)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = compactPeriods(APIstub, Agreement.AgreementID, windows)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	for _, key := range keys {
+		err = APIstub.DelState(key)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	update_time, errTx := t.GetTxTimestampChannel(APIstub)
+	if errTx != nil {
+		return errorResponse(errTx)
+	}
+
+	Agreement.Agreement_model_current_count = Count(usage.Total)
+	Agreement.Agreement_update_time = update_time
+	AgreementJSONasBytes, err := json.Marshal(Agreement)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = APIstub.PutState(Agreement.AgreementID, AgreementJSONasBytes)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(APIstub, &events.Event{Type: events.UsageCompacted, AssetID: Agreement.AgreementID}, &events.UsageDetails{
+		ModelID: Agreement.Agreement_model_id,
+		Units:   usage.Pending,
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	fmt.Printf("- compacted %d usage deltas of %s\n", len(keys), Agreement.AgreementID)
+
+	usage.Compacted = usage.Total
+	usage.Pending = 0
+	usageAsBytes, err := json.Marshal(usage)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(usageAsBytes)
+}
+
+// Consumption is the result of consumeModelUsage
+type Consumption struct {
+	AgreementID string `json:"AgreementID"`
+	Units       int    `json:"units"`
+	Quota       int    `json:"quota"`
+	// compacted units including this consumption, queryUsage adds the pending
+	CurrentCount int           `json:"current_count"`
+	Remaining    int           `json:"remaining"`
+	Model        *ModelVersion `json:"model"`
+	// period quotas in the current window, including this consumption
+	Periods []PeriodRemaining `json:"periods,omitempty"`
+	// single-use grant to fetch Model from the model server, see redeemAccessGrant
+	Access_grant *AccessGrant `json:"access_grant,omitempty"`
+}
+
+// consume records units of use of an active Agreement, checked against its
+// quota, resolves the model version the Agreement is bound to and mints the
+// access grant of the consumption.
+//
+// The quotas are checked against the compacted count only. Reading the
+// pending delta keys would be a range read, and of two consumptions of the
+// same Agreement in one block the later would fail with a phantom read
+// conflict. Consumptions between two compactions therefore do not see each
+// other and may overshoot the quota, by at most the units consumed since the
+// last compaction, each of them within the quota left at that compaction.
+// compactUsage bounds the overshoot, queryUsage reports it.
+func (t *MAGNIT_CC) consume(APIstub shim.ChaincodeStubInterface, AgreementID string, units int) (*Consumption, error) {
+	Agreement, err := readAgreement(APIstub, AgreementID)
+	if err != nil {
+		return nil, err
+	} else if Agreement == nil {
+		return nil, notFoundError("Agreement does not exist: " + AgreementID)
+	}
+
+	// the quotas of a confidential Agreement are in its private terms
+	view, err := withTerms(APIstub, Agreement)
+	if err != nil {
+		return nil, err
+	}
+	quota, total, err := agreementCounts(view.Agreement)
+	if err != nil {
+		return nil, err
+	}
+
+	if Agreement.Agreement_status != StatusActive {
+		return nil, &UsageError{Message: "Agreement " + AgreementID + " is " + Agreement.Agreement_status, Code: codeAgreementNotActive, AgreementID: AgreementID, Quota: quota, CurrentCount: total, Requested: units}
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkValidity(Agreement, now); err != nil {
+		if usageErr, ok := err.(*UsageError); ok {
+			usageErr.Quota, usageErr.CurrentCount, usageErr.Requested = quota, total, units
+		}
+		return nil, err
+	}
+	if total+units > quota {
+		return nil, &UsageError{Message: "Usage quota of " + AgreementID + " is exhausted", Code: codeQuotaExhausted, AgreementID: AgreementID, Quota: quota, CurrentCount: total, Requested: units}
+	}
+	// windows roll over with the tx timestamp
+	periods, err := currentPeriods(APIstub, view.Agreement, now, nil)
+	if err != nil {
+		return nil, err
+	}
+	periods, err = checkPeriodQuotas(AgreementID, periods, units)
+	if err != nil {
+		return nil, err
+	}
+
+	// resolve the model version the Agreement is bound to
+	ModelVersion, err := resolveModelVersion(APIstub, Agreement.Agreement_model_id, Agreement.Agreement_model_version)
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := getCaller(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	use_time, err := t.GetTxTimestampChannel(APIstub)
+	if err != nil {
+		return nil, err
+	}
+
+	// record the use in its own key instead of rewriting the Agreement
+	err = recordUsage(APIstub, AgreementID, units, caller.MSPID, use_time, periods)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(APIstub, &events.Event{Type: events.ModelUsed, AssetID: AgreementID}, &events.UsageDetails{
+		ModelID:      ModelVersion.Model_id,
+		ModelVersion: ModelVersion.Model_version,
+		Units:        units,
+		Consumer:     caller.MSPID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	consumption := &Consumption{AgreementID, units, quota, total + units, quota - total - units, ModelVersion, periods, nil}
+	consumption.Access_grant, err = mintAccessGrant(APIstub, consumption, caller)
+	if err != nil {
+		return nil, err
+	}
+	return consumption, nil
+}
+
+// ===========================================================================
+// consumeModelUsage - consume units of an Agreement's quota
+//
+// AgreementID
+// units - positive integer
+//
+// Returns the remaining quota, the current count, the model version and a
+// single-use access grant for the model server. A refusal is a
+// ChaincodeError with code QUOTA_EXHAUSTED, PERIOD_QUOTA_EXHAUSTED,
+// AGREEMENT_NOT_ACTIVE, AGREEMENT_NOT_YET_VALID or AGREEMENT_EXPIRED and
+// the UsageError as details.
+// ===========================================================================
+func (t *MAGNIT_CC) consumeModelUsage(ctx *TransactionContext, AgreementID string, units int) (*Consumption, error) {
+
+	if units <= 0 || units > maxAgreementQuota {
+		return nil, &FieldError{"units", fmt.Sprintf("must be an integer between 1 and %d", maxAgreementQuota)}
+	}
+	return t.consume(ctx.GetStub(), AgreementID, units)
+}
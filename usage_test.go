@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+func queryUsage(t *testing.T, stub *callerStub, txID string) Usage {
+	t.Helper()
+	res := stub.invoke(txID, "queryUsage", "Agreement-tx2")
+	expectOK(t, res)
+
+	usage := Usage{}
+	if err := json.Unmarshal(res.Payload, &usage); err != nil {
+		t.Fatal(err)
+	}
+	return usage
+}
+
+func TestConcurrentConsumptionInOneBlock(t *testing.T) {
+	stub := setupActiveAgreement(t)
+	stub.as(t, "Org2MSP", nil)
+
+	block := []*rwsetStub{}
+	for i := 0; i < 5; i++ {
+		rwset, res := stub.endorse(fmt.Sprintf("use%d", i), "queryModelByAgreementID", "Agreement-tx2")
+		expectOK(t, res)
+		block = append(block, rwset)
+	}
+
+	if valid := commitBlock(stub, block); valid != len(block) {
+		t.Fatalf("Expected all %d metered reads to commit, %d did", len(block), valid)
+	}
+
+	usage := queryUsage(t, stub, "tx3")
+	if usage.Pending != 5 || usage.Total != 5 || usage.Remaining != 5 {
+		t.Fatalf("Unexpected usage %+v", usage)
+	}
+}
+
+func TestCompactUsageEnforcesQuota(t *testing.T) {
+	stub := setupActiveAgreement(t)
+	stub.as(t, "Org2MSP", nil)
+
+	for i := 0; i < 10; i++ {
+		expectOK(t, stub.invoke(fmt.Sprintf("use%d", i), "queryModelByAgreementID", "Agreement-tx2"))
+	}
+	expectOK(t, stub.invoke("compact", "compactUsage", "Agreement-tx2"))
+
+	usage := queryUsage(t, stub, "tx3")
+	if usage.Compacted != 10 || usage.Pending != 0 || usage.Remaining != 0 {
+		t.Fatalf("Unexpected usage %+v", usage)
+	}
+	expectUsageError(t, stub.invoke("use10", "queryModelByAgreementID", "Agreement-tx2"), codeQuotaExhausted)
+}
+
+func TestQuotaOvershootIsBounded(t *testing.T) {
+	stub := setupActiveAgreement(t)
+	stub.as(t, "Org2MSP", nil)
+
+	// pending deltas are not read, the second consumption overshoots the quota
+	expectOK(t, stub.invoke("use0", "consumeModelUsage", "Agreement-tx2", "7"))
+	expectOK(t, stub.invoke("use1", "consumeModelUsage", "Agreement-tx2", "4"))
+	// each consumption stays within the quota left at the last compaction
+	expectUsageError(t, stub.invoke("use2", "consumeModelUsage", "Agreement-tx2", "11"), codeQuotaExhausted)
+	if usage := queryUsage(t, stub, "tx3"); usage.Compacted != 0 || usage.Pending != 11 || usage.Remaining != 0 {
+		t.Fatalf("Unexpected usage %+v", usage)
+	}
+
+	// compaction stops the overshoot
+	expectOK(t, stub.invoke("compact", "compactUsage", "Agreement-tx2"))
+	expectUsageError(t, stub.invoke("use3", "queryModelByAgreementID", "Agreement-tx2"), codeQuotaExhausted)
+}
+
+func TestCompactionConflictsWithConsumptionInOneBlock(t *testing.T) {
+	stub := setupActiveAgreement(t)
+	stub.as(t, "Org2MSP", nil)
+
+	use, res := stub.endorse("use0", "consumeModelUsage", "Agreement-tx2", "1")
+	expectOK(t, res)
+	compact, res := stub.endorse("compact", "compactUsage", "Agreement-tx2")
+	expectOK(t, res)
+
+	// the delta written by the consumption is a phantom in the compaction's range
+	if valid := commitBlock(stub, []*rwsetStub{use, compact}); valid != 1 {
+		t.Fatalf("Expected the compaction to conflict with the consumption, %d of 2 committed", valid)
+	}
+}
+
+func TestUsageInvalidCount(t *testing.T) {
+	stub := setupActiveAgreement(t).as(t, "Org2MSP", nil)
+
+	AgreementJSONasBytes := []byte(strings.Replace(string(stub.State["Agreement-tx2"]), `"Agreement_model_account_use":10`, `"Agreement_model_account_use":"ten"`, 1))
+	stub.MockTransactionStart("corrupt")
+	stub.PutState("Agreement-tx2", AgreementJSONasBytes)
+	stub.MockTransactionEnd("corrupt")
+
+	if res := stub.invoke("use", "queryModelByAgreementID", "Agreement-tx2"); res.Status == shim.OK {
+		t.Fatalf("Expected an unparsable quota to be refused")
+	}
+}
+
+// expectUsageError checks that res was refused with the given code
+func expectUsageError(t *testing.T, res peer.Response, code string) {
+	t.Helper()
+	if res.Status == shim.OK {
+		t.Fatalf("Expected consumption to be refused")
+	}
+	expectError(t, res, code)
+}
+
+func TestConsumeModelUsage(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org2MSP", nil)
+	expectUsageError(t, stub.invoke("use0", "consumeModelUsage", "Agreement-tx2", "1"), codeAgreementNotActive)
+
+	stub = setupActiveAgreement(t).as(t, "Org2MSP", nil)
+	res := stub.invoke("use1", "consumeModelUsage", "Agreement-tx2", "4")
+	expectOK(t, res)
+	consumption := Consumption{}
+	json.Unmarshal(res.Payload, &consumption)
+	if consumption.Units != 4 || consumption.Quota != 10 || consumption.CurrentCount != 4 || consumption.Remaining != 6 || consumption.Model == nil || consumption.Model.Model_id != "Model-tx1" {
+		t.Fatalf("Unexpected consumption %s", res.Payload)
+	}
+
+	expectOK(t, stub.invoke("compact", "compactUsage", "Agreement-tx2"))
+	expectUsageError(t, stub.invoke("use2", "consumeModelUsage", "Agreement-tx2", "7"), codeQuotaExhausted)
+	expectOK(t, stub.invoke("use3", "consumeModelUsage", "Agreement-tx2", "6"))
+
+	for _, units := range []string{"0", "-1", "many", ""} {
+		expectFailure(t, stub.invoke("bad", "consumeModelUsage", "Agreement-tx2", units))
+	}
+	expectDenied(t, stub.as(t, "Org1MSP", nil).invoke("use4", "consumeModelUsage", "Agreement-tx2", "1"))
+}
+
+func TestLegacyStringCounts(t *testing.T) {
+	stub := setupActiveAgreement(t).as(t, "Org2MSP", nil)
+
+	// counters stored as strings before they were typed
+	legacy := strings.Replace(string(stub.State["Agreement-tx2"]), `"Agreement_model_account_use":10`, `"Agreement_model_account_use":"3"`, 1)
+	legacy = strings.Replace(legacy, `"Agreement_model_current_count":0`, `"Agreement_model_current_count":"2"`, 1)
+	stub.MockTransactionStart("legacy")
+	stub.PutState("Agreement-tx2", []byte(legacy))
+	stub.MockTransactionEnd("legacy")
+
+	expectOK(t, stub.invoke("use1", "consumeModelUsage", "Agreement-tx2", "1"))
+	expectOK(t, stub.invoke("compact", "compactUsage", "Agreement-tx2"))
+	expectUsageError(t, stub.invoke("use2", "consumeModelUsage", "Agreement-tx2", "1"), codeQuotaExhausted)
+
+	if !strings.Contains(string(stub.State["Agreement-tx2"]), `"Agreement_model_current_count":3`) {
+		t.Fatalf("Compaction did not store a typed count: %s", stub.State["Agreement-tx2"])
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Length limits of the input fields
+const (
+	maxNameLength    = 128  // names, licenses and frameworks
+	maxVersionLength = 64   // model versions
+	maxTextLength    = 4096 // descriptions, remarks and prices
+	maxURLLength     = 2048
+	maxTagLength     = 64
+	maxTags          = 32
+)
+
+// allowedURLSchemes are the schemes of the links stored on the ledger
+var allowedURLSchemes = map[string]bool{"http": true, "https": true, "ipfs": true, "s3": true}
+
+// mspIDPattern is the format of an organization's MSP ID, e.g. Org1MSP
+var mspIDPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]{0,63}$`)
+
+// assetIDPatterns are the formats of the IDs of newAssetID and of the legacy
+// sequential IDs, e.g. Model-<txid> and Model1
+var assetIDPatterns = map[string]*regexp.Regexp{}
+
+func init() {
+	for _, prefix := range []string{modelIDPrefix, agreementIDPrefix, amendmentIDPrefix} {
+		assetIDPatterns[prefix] = regexp.MustCompile(`^` + prefix + `(-[A-Za-z0-9]{1,64}|[0-9]{1,20})$`)
+	}
+}
+
+// ValidationError reports every invalid field of an input at once
+type ValidationError struct {
+	Violations []*FieldError `json:"violations"`
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Violations) == 1 {
+		return e.Violations[0].Error()
+	}
+	reasons := []string{}
+	for _, violation := range e.Violations {
+		reasons = append(reasons, violation.Field+" "+violation.Reason)
+	}
+	return fmt.Sprintf("%d invalid fields: %s", len(e.Violations), strings.Join(reasons, "; "))
+}
+
+// validator collects the violations of the rules applied to an input. Fields
+// are named by their path, prefix included.
+type validator struct {
+	prefix     string
+	violations []*FieldError
+	failure    error // first error that is not a violation
+}
+
+// check records the violations of err, it reports whether err is nil
+func (v *validator) check(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return true
+	case *FieldError:
+		v.violations = append(v.violations, &FieldError{v.prefix + e.Field, e.Reason})
+	case *ValidationError:
+		for _, violation := range e.Violations {
+			v.check(violation)
+		}
+	default:
+		if v.failure == nil {
+			v.failure = err
+		}
+	}
+	return false
+}
+
+// fail records a violation of field
+func (v *validator) fail(field string, reason string) {
+	v.check(&FieldError{field, reason})
+}
+
+// err returns the violations found, nil when the input is valid
+func (v *validator) err() error {
+	if v.failure != nil {
+		return v.failure
+	} else if len(v.violations) > 0 {
+		return &ValidationError{v.violations}
+	}
+	return nil
+}
+
+// required fails when a mandatory field is empty
+func (v *validator) required(field string, value string) bool {
+	return v.check(requireField(field, value))
+}
+
+// maxLength fails when a field is longer than max characters
+func (v *validator) maxLength(field string, value string, max int) bool {
+	if length := len([]rune(value)); length > max {
+		v.fail(field, fmt.Sprintf("must have at most %d characters, got %d", max, length))
+		return false
+	}
+	return true
+}
+
+// url fails when an optional link is not an absolute URL of an allowed scheme
+func (v *validator) url(field string, value string) bool {
+	if value == "" {
+		return true
+	}
+	if !v.maxLength(field, value, maxURLLength) {
+		return false
+	}
+	link, err := url.Parse(value)
+	if err != nil || link.Scheme == "" || (link.Host == "" && link.Opaque == "") {
+		v.fail(field, "must be an absolute URL")
+		return false
+	}
+	if !allowedURLSchemes[strings.ToLower(link.Scheme)] {
+		v.fail(field, "scheme must be http, https, ipfs or s3, got "+link.Scheme)
+		return false
+	}
+	return true
+}
+
+// mspID fails when an optional organization is not an MSP ID
+func (v *validator) mspID(field string, value string) bool {
+	if value != "" && !mspIDPattern.MatchString(value) {
+		v.fail(field, "must be an MSP ID of letters, digits, dots, dashes and underscores, got "+value)
+		return false
+	}
+	return true
+}
+
+// assetID fails when an optional ID is not an ID of prefix
+func (v *validator) assetID(field string, value string, prefix string) bool {
+	if value != "" && !assetIDPatterns[prefix].MatchString(value) {
+		v.fail(field, "must be an ID such as "+prefix+"-<txid>, got "+value)
+		return false
+	}
+	return true
+}
+
+// hash normalizes an optional algorithm-tagged digest, see parseDigest
+func (v *validator) hash(field string, value *string) bool {
+	normalized, err := normalizeDocumentHash(field, *value)
+	if !v.check(err) {
+		return false
+	}
+	*value = normalized
+	return true
+}
+
+// tags fails on too many or too long tags
+func (v *validator) tags(field string, tags []string) bool {
+	valid := true
+	if len(tags) > maxTags {
+		v.fail(field, fmt.Sprintf("must have at most %d tags, got %d", maxTags, len(tags)))
+		valid = false
+	}
+	for i, tag := range tags {
+		valid = v.maxLength(fmt.Sprintf("%s[%d]", field, i), tag, maxTagLength) && valid
+	}
+	return valid
+}
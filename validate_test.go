@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// digest returns a valid sha256 digest repeating hex
+func digest(hex string) string {
+	return "sha256:" + strings.Repeat(hex, 64/len(hex))
+}
+
+// expectViolations checks that res failed with exactly the violated fields
+func expectViolations(t *testing.T, res peer.Response, fields ...string) {
+	t.Helper()
+	validationErr := ValidationError{}
+	errorDetails(t, expectError(t, res, codeInvalidField), &validationErr)
+	violated := []string{}
+	for _, violation := range validationErr.Violations {
+		violated = append(violated, violation.Field)
+	}
+	if strings.Join(violated, ",") != strings.Join(fields, ",") {
+		t.Fatalf("Expected violations of %v, got %s", fields, res.Message)
+	}
+}
+
+func TestValidationReportsAllViolations(t *testing.T) {
+	stub := newCallerStub(t).as(t, "Org1MSP", nil)
+
+	expectViolations(t, stub.invoke("tx1", "initmodel", `{"model_name":"`+strings.Repeat("n", maxNameLength+1)+`","upload_org":"Org1MSP","model_artifact_hash":"md5:aa","model_artifact_uri":"javascript:alert(1)","model_tags":["`+strings.Repeat("t", maxTagLength+1)+`"]}`),
+		"model_name", "model_artifact_hash", "model_artifact_uri", "model_tags[0]")
+	expectViolations(t, stub.invoke("tx2", "initmodel", `{"model_name":"Resnet","upload_org":"Org 1"}`), "upload_org")
+	expectOK(t, stub.invoke("tx3", "initmodel", `{"model_name":"Resnet","upload_org":"Org1MSP","model_artifact_uri":"ipfs://bafy"}`))
+
+	expectViolations(t, stub.invoke("tx4", "publishModelVersion", `{"model_id":"model-tx3","model_version":"latest","model_artifact_hash":"sha256:zz"}`),
+		"model_id", "model_version", "model_artifact_hash")
+
+	expectViolations(t, stub.invoke("tx5", "insertAgreementinfo", `{"Agreement_model_id":"Model/tx3","Agreement_model_account_use":-1,"Agreement_issuer":"Org1MSP","Agreement_participant":"Org2 MSP","Agreement_url_image":"file:///etc/passwd","Agreement_valid_from":"tomorrow"}`),
+		"Agreement_model_id", "Agreement_model_account_use", "Agreement_participant", "Agreement_url_image", "Agreement_valid_from")
+	res := stub.invoke("tx6", "insertAgreementinfo", "Agreement", "Model-tx3", "10", "Org1MSP", "Org2MSP", strings.Repeat("r", maxTextLength+1), "ftp://image", "", "")
+	expectViolations(t, res, "Agreement_remark", "Agreement_url_image")
+	expectOK(t, stub.invoke("tx7", "insertAgreementinfo", "Agreement", "Model-tx3", "10", "Org1MSP", "Org2MSP", "", "https://image", "", ""))
+
+	expectViolations(t, stub.invoke("tx8", "proposeAmendment", `{"AgreementID":"Agreement-tx7","Agreement_model_account_use":-5,"Agreement_url_image":"image.png"}`),
+		"Agreement_model_account_use", "Agreement_url_image")
+}
+
+func TestValidationOfPrivateTerms(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", nil)
+
+	terms := `{"Agreement_model_account_use":-1,"Agreement_hash":"sha256:aa","salt":"short"}`
+	res := stub.withTransient(map[string][]byte{agreementTermsTransientKey: []byte(terms)}).invoke("tx3", "insertAgreementinfo", "Agreement", "Model-tx1", "", "Org1MSP", "Org2MSP", "", "", "", "")
+	expectViolations(t, res, "agreement_terms.Agreement_model_account_use", "agreement_terms.Agreement_hash", "agreement_terms.salt")
+
+	terms = `{"Agreement_prize":"1200 EUR","salt":"0123456789abcdef"}`
+	res = stub.withTransient(map[string][]byte{agreementTermsTransientKey: []byte(terms)}).invoke("tx4", "insertAgreementinfo", "Agreement", "Model-tx1", "", "Org1MSP", "Org2MSP", "", "", "", "")
+	expectViolations(t, res, "agreement_terms.Agreement_prize")
+}
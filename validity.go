@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/imineev/cc1/events"
+)
+
+// parseValidityTime normalizes an RFC3339 validity bound to UTC, an empty bound stays open
+func parseValidityTime(field string, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	bound, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", &FieldError{field, "must be an RFC3339 timestamp, got " + value}
+	}
+	return bound.UTC().Format(time.RFC3339), nil
+}
+
+// validityWindow returns the bounds of an Agreement, zero for an open bound
+func validityWindow(Agreement *Agreement) (time.Time, time.Time, error) {
+	var from, until time.Time
+	var err error
+	if Agreement.Agreement_valid_from != "" {
+		from, err = time.Parse(time.RFC3339, Agreement.Agreement_valid_from)
+		if err != nil {
+			return from, until, fmt.Errorf("Agreement %s has an invalid Agreement_valid_from %q", Agreement.AgreementID, Agreement.Agreement_valid_from)
+		}
+	}
+	if Agreement.Agreement_valid_until != "" {
+		until, err = time.Parse(time.RFC3339, Agreement.Agreement_valid_until)
+		if err != nil {
+			return from, until, fmt.Errorf("Agreement %s has an invalid Agreement_valid_until %q", Agreement.AgreementID, Agreement.Agreement_valid_until)
+		}
+	}
+	return from, until, nil
+}
+
+// isOverdue reports whether the validity of an Agreement ended before now
+func isOverdue(Agreement *Agreement, now time.Time) (bool, error) {
+	_, until, err := validityWindow(Agreement)
+	if err != nil {
+		return false, err
+	}
+	return !until.IsZero() && !now.Before(until), nil
+}
+
+// requireFutureUntil fails when a new end of validity is not after the tx time
+func requireFutureUntil(APIstub shim.ChaincodeStubInterface, until string) error {
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	bound, err := time.Parse(time.RFC3339, until)
+	if err != nil || !now.Before(bound) {
+		return &FieldError{"Agreement_valid_until", "must be in the future"}
+	}
+	return nil
+}
+
+// checkValidity refuses a use of an Agreement outside its validity window
+func checkValidity(Agreement *Agreement, now time.Time) error {
+	from, until, err := validityWindow(Agreement)
+	if err != nil {
+		return err
+	}
+	if !from.IsZero() && now.Before(from) {
+		return &UsageError{Message: "Agreement " + Agreement.AgreementID + " is valid from " + Agreement.Agreement_valid_from, Code: codeAgreementNotYetValid, AgreementID: Agreement.AgreementID}
+	}
+	if !until.IsZero() && !now.Before(until) {
+		return &UsageError{Message: "Agreement " + Agreement.AgreementID + " expired at " + Agreement.Agreement_valid_until, Code: codeAgreementExpired, AgreementID: Agreement.AgreementID}
+	}
+	return nil
+}
+
+// ===========================================================================
+// expireAgreements - move active and suspended Agreements whose validity
+// ended to expired
+//
+// limit - optional, the most Agreements to expire at once, defaults to 100
+//
+// Returns {"expired":[...],"more":bool}, more is set when the sweep stopped
+// at the limit and should be invoked again. A sweep that expired nothing
+// emits no event.
+// ===========================================================================
+func (t *MAGNIT_CC) expireAgreements(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+
+	if len(args) > 1 {
+		return errorResponse(argumentError("Incorrect number of arguments. Expecting optional limit"))
+	}
+	limitArg := ""
+	if len(args) == 1 {
+		limitArg = args[0]
+	}
+	limit, err := parsePageSize(limitArg)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	overdue, more, err := overdueAgreements(APIstub, now, int(limit))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	update_time, errTx := t.GetTxTimestampChannel(APIstub)
+	if errTx != nil {
+		return errorResponse(errTx)
+	}
+
+	expired := []string{}
+	transitions := []events.Event{}
+	for _, Agreement := range overdue {
+		previousStatus := Agreement.Agreement_status
+		err = setAgreementStatus(APIstub, Agreement, StatusExpired, update_time)
+		if err != nil {
+			return errorResponse(err)
+		}
+		expired = append(expired, Agreement.AgreementID)
+
+		transition := events.Event{
+			Type:           events.AgreementStatusChanged,
+			AssetID:        Agreement.AgreementID,
+			PreviousStatus: previousStatus,
+			NewStatus:      StatusExpired,
+		}
+		err = stampEvent(APIstub, &transition, agreementDetails(Agreement))
+		if err != nil {
+			return errorResponse(err)
+		}
+		transitions = append(transitions, transition)
+	}
+
+	if len(expired) > 0 {
+		err = emitEvent(APIstub, &events.Event{Type: events.AgreementsExpired, NewStatus: StatusExpired}, &events.ExpiryDetails{Agreements: expired, Transitions: transitions, More: more})
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	fmt.Printf("- expireAgreements expired %d agreements\n", len(expired))
+
+	result := struct {
+		Expired []string `json:"expired"`
+		More    bool     `json:"more"`
+	}{expired, more}
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// overdueAgreements walks the status index for Agreements that can expire
+// and returns up to limit of them whose validity ended before now
+func overdueAgreements(APIstub shim.ChaincodeStubInterface, now time.Time, limit int) ([]*Agreement, bool, error) {
+	overdue := []*Agreement{}
+	for _, status := range agreementTransitions["expireAgreement"].From {
+		resultsIterator, err := APIstub.GetStateByPartialCompositeKey(statusIndex, []string{status})
+		if err != nil {
+			return nil, false, err
+		}
+
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, false, err
+			}
+			_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+			if err != nil {
+				resultsIterator.Close()
+				return nil, false, err
+			}
+
+			Agreement, err := readAgreement(APIstub, attributes[1])
+			if err == nil && Agreement == nil {
+				err = errors.New("Status index points to a missing Agreement " + attributes[1])
+			}
+			if err != nil {
+				resultsIterator.Close()
+				return nil, false, err
+			}
+			isDue, err := isOverdue(Agreement, now)
+			if err != nil {
+				resultsIterator.Close()
+				return nil, false, err
+			}
+			if !isDue {
+				continue
+			}
+			if len(overdue) == limit {
+				resultsIterator.Close()
+				return overdue, true, nil
+			}
+			overdue = append(overdue, Agreement)
+		}
+		resultsIterator.Close()
+	}
+	return overdue, false, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imineev/cc1/events"
+)
+
+// setupWindowedAgreement stores and activates Agreement-<txID> valid from from until until
+func setupWindowedAgreement(t *testing.T, stub *callerStub, txID string, from string, until string) string {
+	t.Helper()
+	input := `{"Agreement_model_id":"Model-tx1","Agreement_model_account_use":10,"Agreement_issuer":"Org1MSP","Agreement_participant":"Org2MSP","Agreement_valid_from":"` + from + `","Agreement_valid_until":"` + until + `"}`
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke(txID, "insertAgreementinfo", input))
+	AgreementID := "Agreement-" + txID
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke(txID+"-approve", "approveAgreement", AgreementID))
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke(txID+"-activate", "activateAgreement", AgreementID))
+	return AgreementID
+}
+
+// moveValidUntil rewrites the stored end of validity, new Agreements cannot end in the past
+func moveValidUntil(t *testing.T, stub *callerStub, AgreementID string, until time.Time) {
+	t.Helper()
+	Agreement, err := readAgreement(stub, AgreementID)
+	if err != nil || Agreement == nil {
+		t.Fatalf("Failed to read %s: %v", AgreementID, err)
+	}
+	Agreement.Agreement_valid_until = until.UTC().Format(time.RFC3339)
+	AgreementJSONasBytes, _ := json.Marshal(Agreement)
+	stub.MockTransactionStart("rewind")
+	stub.PutState(AgreementID, AgreementJSONasBytes)
+	stub.MockTransactionEnd("rewind")
+}
+
+func TestValidityWindowInput(t *testing.T) {
+	stub := setupAgreement(t).as(t, "Org1MSP", nil)
+	agreement := func(window string) string {
+		return `{"Agreement_model_id":"Model-tx1","Agreement_model_account_use":1,"Agreement_issuer":"Org1MSP","Agreement_participant":"Org2MSP",` + window + `}`
+	}
+
+	expectFieldError(t, stub.invoke("tx3", "insertAgreementinfo", agreement(`"Agreement_valid_from":"tomorrow"`)), "Agreement_valid_from")
+	expectFieldError(t, stub.invoke("tx4", "insertAgreementinfo", agreement(`"Agreement_valid_from":"2030-01-02T00:00:00Z","Agreement_valid_until":"2030-01-01T00:00:00Z"`)), "Agreement_valid_until")
+	expectFieldError(t, stub.invoke("tx5", "insertAgreementinfo", agreement(`"Agreement_valid_until":"2000-01-01T00:00:00Z"`)), "Agreement_valid_until")
+
+	res := stub.invoke("tx6", "insertAgreementinfo", agreement(`"Agreement_valid_from":"2000-01-01T03:00:00+03:00","Agreement_valid_until":"2099-12-31T23:59:59-01:00"`))
+	expectOK(t, res)
+	Agreement := &Agreement{}
+	json.Unmarshal(res.Payload, Agreement)
+	if Agreement.Agreement_valid_from != "2000-01-01T00:00:00Z" || Agreement.Agreement_valid_until != "2100-01-01T00:59:59Z" {
+		t.Fatalf("Validity was not normalized to UTC: %s", res.Payload)
+	}
+
+	// the legacy agreement is valid without bounds
+	if strings.Contains(string(stub.State["Agreement-tx2"]), "Agreement_valid") {
+		t.Fatalf("Open bounds are stored: %s", stub.State["Agreement-tx2"])
+	}
+}
+
+func TestConsumeOutsideValidity(t *testing.T) {
+	stub := setupAgreement(t)
+	future := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+
+	AgreementID := setupWindowedAgreement(t, stub, "tx3", future, "")
+	expectUsageError(t, stub.as(t, "Org2MSP", nil).invoke("use1", "consumeModelUsage", AgreementID, "1"), codeAgreementNotYetValid)
+
+	AgreementID = setupWindowedAgreement(t, stub, "tx4", "", future)
+	expectOK(t, stub.as(t, "Org2MSP", nil).invoke("use2", "consumeModelUsage", AgreementID, "1"))
+	moveValidUntil(t, stub, AgreementID, time.Now().Add(-time.Minute))
+	expectUsageError(t, stub.invoke("use3", "consumeModelUsage", AgreementID, "1"), codeAgreementExpired)
+}
+
+func TestExpireAgreements(t *testing.T) {
+	stub := setupActiveAgreement(t)
+	future := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+
+	overdue := []string{}
+	for _, txID := range []string{"tx3", "tx4", "tx5"} {
+		overdue = append(overdue, setupWindowedAgreement(t, stub, txID, "", future))
+		moveValidUntil(t, stub, overdue[len(overdue)-1], time.Now().Add(-time.Hour))
+	}
+	current := setupWindowedAgreement(t, stub, "tx6", "", future)
+	expectOK(t, stub.as(t, "Org1MSP", nil).invoke("suspend", "suspendAgreement", overdue[2]))
+
+	expectDenied(t, stub.as(t, "Org1MSP", nil).invoke("sweep0", "expireAgreements"))
+
+	stub.as(t, "Org1MSP", adminAttrs)
+	sweep := func(txID string, args ...string) ([]string, bool) {
+		t.Helper()
+		res := stub.invoke(txID, append([]string{"expireAgreements"}, args...)...)
+		expectOK(t, res)
+		result := struct {
+			Expired []string `json:"expired"`
+			More    bool     `json:"more"`
+		}{}
+		json.Unmarshal(res.Payload, &result)
+		return result.Expired, result.More
+	}
+
+	expired, more := sweep("sweep1", "2")
+	if len(expired) != 2 || !more {
+		t.Fatalf("Expected a limited sweep, got %v more=%v", expired, more)
+	}
+	details := events.ExpiryDetails{}
+	if err := lastEvent(t, stub, events.AgreementsExpired).DecodeDetails(&details); err != nil || len(details.Agreements) != 2 || !details.More {
+		t.Fatalf("Unexpected event details %+v: %v", details, err)
+	}
+	if len(details.Transitions) != 2 {
+		t.Fatalf("Expected a transition per expired agreement, got %+v", details.Transitions)
+	}
+	for i, transition := range details.Transitions {
+		agreementDetails := events.AgreementDetails{}
+		transition.DecodeDetails(&agreementDetails)
+		if transition.Type != events.AgreementStatusChanged || transition.AssetID != details.Agreements[i] ||
+			transition.PreviousStatus != StatusActive || transition.NewStatus != StatusExpired ||
+			transition.TxID != "sweep1" || agreementDetails.ModelID != "Model-tx1" {
+			t.Fatalf("Unexpected transition %+v", transition)
+		}
+	}
+
+	expired, more = sweep("sweep2")
+	if len(expired) != 1 || more {
+		t.Fatalf("Expected the rest of the sweep, got %v more=%v", expired, more)
+	}
+	details = events.ExpiryDetails{}
+	lastEvent(t, stub, events.AgreementsExpired).DecodeDetails(&details)
+	if len(details.Transitions) != 1 || details.Transitions[0].AssetID != overdue[2] || details.Transitions[0].PreviousStatus != StatusSuspended {
+		t.Fatalf("Expected the suspended agreement to expire, got %+v", details.Transitions)
+	}
+
+	for _, AgreementID := range append(overdue, current, "Agreement-tx2") {
+		Agreement, _ := readAgreement(stub, AgreementID)
+		isExpired := Agreement.Agreement_status == StatusExpired
+		if isExpired != (AgreementID != current && AgreementID != "Agreement-tx2") {
+			t.Fatalf("Unexpected status %s of %s", Agreement.Agreement_status, AgreementID)
+		}
+	}
+
+	if expired, _ = sweep("sweep3"); len(expired) != 0 {
+		t.Fatalf("Expected nothing left to expire, got %v", expired)
+	}
+	if len(stub.events) != 0 {
+		t.Fatalf("Expected no event of an empty sweep, got %d", len(stub.events))
+	}
+	expectFailure(t, stub.invoke("sweep4", "expireAgreements", "0"))
+}